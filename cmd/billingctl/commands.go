@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+// operation parses "<user> [<to user>] [<amount>]" or "<user> <review>" and makes operation of commandType.
+func (a *app) operation(ctx context.Context, commandType model.CommandType, args []string, argsCount int) error {
+	if len(args) != argsCount {
+		return fmt.Errorf("%w: %s takes %d arguments", errUsage, commandType, argsCount)
+	}
+	isReview := commandType == model.CommandTypeApproveReview || commandType == model.CommandTypeRejectReview
+	ids := args
+	if argsCount > 1 && !isReview {
+		ids = args[:argsCount-1]
+	}
+	values, err := parseInts(ids)
+	if err != nil {
+		return err
+	}
+
+	command := model.Command{ID: time.Now().UnixNano(), TenantID: a.tenantID, Type: commandType, FromUserID: values[0]}
+	if a.currency != nil {
+		command.Currency = a.currency.Code
+	}
+	switch {
+	case isReview:
+		command.ReviewID = &values[1]
+	case argsCount > 1:
+		amount, err := a.parseAmount(args[argsCount-1])
+		if err != nil {
+			return err
+		}
+		command.Amount = &amount
+		if argsCount == 3 {
+			command.ToUserID = &values[1]
+		}
+	}
+
+	if a.via == "nats" {
+		q, err := a.nats()
+		if err != nil {
+			return err
+		}
+		if err := q.PublishCommand(ctx, command); err != nil {
+			return err
+		}
+		return a.out.print(command, []string{"command_id", "status"}, [][]string{{strconv.FormatInt(command.ID, 10), "published"}})
+	}
+
+	srv, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	switch commandType {
+	case model.CommandTypeOpen:
+		err = srv.CreateAccount(ctx, command.FromUserID)
+	case model.CommandTypeDeposit:
+		err = srv.Deposit(ctx, command.FromUserID, *command.Amount)
+	case model.CommandTypeWithdraw:
+		err = srv.Withdraw(ctx, command.FromUserID, *command.Amount)
+	case model.CommandTypeTransfer:
+		err = srv.Transfer(ctx, command.FromUserID, *command.ToUserID, *command.Amount)
+	case model.CommandTypeApproveReview:
+		err = srv.ApproveReview(ctx, command.FromUserID, *command.ReviewID)
+	case model.CommandTypeRejectReview:
+		err = srv.RejectReview(ctx, command.FromUserID, *command.ReviewID)
+	}
+	if err != nil {
+		return err
+	}
+	if argsCount == 1 {
+		return a.balance(ctx, args)
+	}
+	return a.balance(ctx, args[:argsCount-1])
+}
+
+func (a *app) balance(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: user is required", errUsage)
+	}
+	userIDs, err := parseInts(args)
+	if err != nil {
+		return err
+	}
+	repo, err := a.postgres(ctx)
+	if err != nil {
+		return err
+	}
+
+	balances := make([]model.Balance, 0, len(userIDs))
+	rows := make([][]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		balance, err := repo.GetBalance(repo.GetDB(ctx), a.tenantID, userID, false)
+		if err != nil {
+			return err
+		}
+		balances = append(balances, balance)
+		rows = append(rows, []string{
+			strconv.FormatInt(balance.UserID, 10),
+			a.formatAmount(balance.Balance),
+			balance.Currency,
+			string(balance.Status),
+			a.formatAmount(balance.CreditLimit),
+		})
+	}
+	return a.out.print(balances, []string{"user_id", "balance", "currency", "status", "credit_limit"}, rows)
+}
+
+func (a *app) history(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	limit := fs.Int("limit", 20, "max number of events")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: user is required", errUsage)
+	}
+	userIDs, err := parseInts(fs.Args())
+	if err != nil {
+		return err
+	}
+	repo, err := a.postgres(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, err := repo.ListUserEvents(repo.GetDB(ctx), a.tenantID, userIDs[0], *limit)
+	if err != nil {
+		return err
+	}
+	return a.printEvents(events)
+}
+
+func (a *app) unsent(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("unsent", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "max number of events")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	repo, err := a.postgres(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, err := repo.ListUnsentEvents(repo.GetDB(ctx), 0, time.Now(), *limit)
+	if err != nil {
+		return err
+	}
+	return a.printEvents(events)
+}
+
+func (a *app) reviews(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reviews", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "max number of reviews")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	repo, err := a.postgres(ctx)
+	if err != nil {
+		return err
+	}
+
+	reviews, err := repo.ListPendingReviews(repo.GetDB(ctx), a.tenantID, *limit)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(reviews))
+	for _, review := range reviews {
+		rows = append(rows, []string{
+			strconv.FormatInt(review.ID, 10),
+			string(review.Command.Type),
+			strconv.FormatInt(review.UserID, 10),
+			optional(review.Command.ToUserID),
+			a.optionalAmount(review.Command.Amount),
+			review.Rule,
+			review.Reason,
+			review.CreatedTime.Format(time.RFC3339),
+		})
+	}
+	return a.out.print(reviews, []string{"id", "type", "from", "to", "amount", "rule", "reason", "created"}, rows)
+}
+
+func (a *app) resend(ctx context.Context) error {
+	srv, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	sent, err := srv.ResendUnsentEvents(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	return a.out.print(map[string]int{"sent": sent}, []string{"sent"}, [][]string{{strconv.Itoa(sent)}})
+}
+
+func (a *app) failures(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("failures", flag.ContinueOnError)
+	since := fs.Duration("since", time.Hour, "how old failures are shown")
+	userID := fs.Int64("user", 0, "show failures of commands of the user only")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	q, err := a.nats()
+	if err != nil {
+		return err
+	}
+
+	all, err := q.ReadOperationFailed(ctx, a.tenantID, time.Now().Add(-*since), 2*time.Second)
+	if err != nil {
+		return err
+	}
+	failures := make([]model.OperationFailed, 0, len(all))
+	rows := make([][]string, 0, len(all))
+	for _, failed := range all {
+		if *userID != 0 && failed.Command.FromUserID != *userID {
+			continue
+		}
+		failures = append(failures, failed)
+		rows = append(rows, []string{
+			failed.CreatedTime.Format(time.RFC3339),
+			strconv.FormatInt(failed.CommandID, 10),
+			string(failed.Command.Type),
+			strconv.FormatInt(failed.Command.FromUserID, 10),
+			failed.Error,
+			failed.Message,
+		})
+	}
+	return a.out.print(failures, []string{"time", "command_id", "type", "user_id", "error", "message"}, rows)
+}
+
+func (a *app) printEvents(events []model.Event) error {
+	rows := make([][]string, 0, len(events))
+	for _, event := range events {
+		sent := ""
+		if event.QueueSentTime != nil {
+			sent = event.QueueSentTime.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			strconv.FormatInt(event.ID, 10),
+			string(event.Type),
+			strconv.FormatInt(event.FromUserID, 10),
+			optional(event.ToUserID),
+			a.optionalAmount(event.Amount),
+			a.optionalAmount(event.Fee),
+			event.CreatedTime.Format(time.RFC3339),
+			sent,
+		})
+	}
+	return a.out.print(events, []string{"id", "type", "from", "to", "amount", "fee", "created", "sent"}, rows)
+}
+
+func parseInts(args []string) ([]int64, error) {
+	values := make([]int64, 0, len(args))
+	for _, arg := range args {
+		v, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a number", errUsage, arg)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseAmount parses minor units or, with -currency, decimal major units.
+func (a *app) parseAmount(arg string) (int64, error) {
+	if a.currency == nil {
+		v, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q is not a number, use -currency for decimal amounts", errUsage, arg)
+		}
+		return v, nil
+	}
+	m, err := money.Parse(arg, *a.currency)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	return m.Amount(), nil
+}
+
+func (a *app) formatAmount(v int64) string {
+	if a.currency == nil {
+		return strconv.FormatInt(v, 10)
+	}
+	return money.New(v, *a.currency).Format()
+}
+
+func (a *app) optionalAmount(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return a.formatAmount(*v)
+}
+
+func optional(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
@@ -1,104 +1,242 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/itimofeev/simple-billing/internal/app/model"
 	"github.com/itimofeev/simple-billing/internal/app/queue"
 	"github.com/itimofeev/simple-billing/pkg/shutdown"
 )
 
+const usage = `usage: client [flags]
+
+Reads model.Command JSON lines from -file, publishes them to workers and waits until every command
+is completed or failed. Commands with zero id get generated one, ids must be unique.
+Commands without tenant_id are made in -tenant, commands of other tenants are not allowed.
+Exit code is 1 if any command failed or was not answered in -timeout.
+`
+
+type status string
+
+const (
+	statusPending   status = "pending"
+	statusCompleted status = "completed"
+	statusFailed    status = "failed"
+)
+
+type result struct {
+	command model.Command
+	status  status
+	// details is the first event type of completed command or error of failed one.
+	details string
+}
+
+// results tracks published commands by id.
+type results struct {
+	mu      sync.Mutex
+	byID    map[int64]*result
+	order   []int64
+	pending int
+	done    chan struct{}
+}
+
 func main() {
+	natsURL := flag.String("nats", "nats://localhost:4222", "nats url")
+	file := flag.String("file", "-", "file with one JSON command per line, - is stdin")
+	clientID := flag.String("client-id", fmt.Sprintf("client-%d", os.Getpid()), "client id, commands are signed as this producer")
+	keyPath := flag.String("key", "", "JSON file with HMAC key commands are signed with")
+	tenantID := flag.String("tenant", model.DefaultTenant, "tenant of commands, results are read from its subjects")
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for results of all commands")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	log := newLogger()
 
-	q, err := queue.New(log, "nats://localhost:4222", "client")
+	commands, err := readCommands(*file, *tenantID)
 	if err != nil {
-		log.WithError(err).Panic("error on initializing queue")
+		log.WithError(err).Error("error on reading commands")
+		os.Exit(2)
 	}
-	defer q.Close()
 
-	ctx := context.Background()
+	var queueOpts []queue.Option
+	if *keyPath != "" {
+		key, err := queue.LoadKey(*keyPath)
+		if err != nil {
+			log.WithError(err).Error("error on loading key")
+			os.Exit(2)
+		}
+		queueOpts = append(queueOpts, queue.WithSigningKey(key))
+	}
+	q, err := queue.New(log, *natsURL, *clientID, queueOpts...)
+	if err != nil {
+		log.WithError(err).Fatal("error on initializing queue")
+	}
+	defer q.Close()
 
-	eg, ctx := errgroup.WithContext(ctx)
+	res := newResults(commands)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	eg.Go(func() error {
-		return q.SubscribeOperationCompleted(ctx, func(ctx context.Context, event model.Event) error {
-			log.WithField("event", event).Info("operation completed")
-			return nil
-		})
+	// subscribe before publishing so that no result is missed
+	err = q.SubscribeOperationCompleted(ctx, *tenantID, func(ctx context.Context, event model.Event) error {
+		if event.CommandID != nil {
+			res.set(*event.CommandID, statusCompleted, string(event.Type))
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Fatal("error on subscribing to events")
+	}
+	err = q.SubscribeOperationFailed(ctx, *tenantID, func(ctx context.Context, failed model.OperationFailed) error {
+		res.set(failed.CommandID, statusFailed, failed.Error+": "+failed.Message)
+		return nil
 	})
+	if err != nil {
+		log.WithError(err).Fatal("error on subscribing to failures")
+	}
+
+	for _, command := range commands {
+		if err := q.PublishCommand(ctx, command); err != nil {
+			log.WithError(err).WithField("command_id", command.ID).Fatal("error on publishing command")
+		}
+		log.WithField("command_id", command.ID).Debug("command published")
+	}
 
 	sigHandler := shutdown.TermSignalTrap()
-	eg.Go(func() error {
-		return sigHandler.Wait(ctx)
-	})
+	waitCtx, waitCancel := context.WithTimeout(ctx, *timeout)
+	defer waitCancel()
+	go func() {
+		_ = sigHandler.Wait(waitCtx)
+		waitCancel()
+	}()
+
+	select {
+	case <-res.done:
+	case <-waitCtx.Done():
+	}
 
-	eg.Go(func() error {
-		_ = publishCommand(ctx, log, q, model.Command{
-			ID:         1,
-			Type:       model.CommandTypeOpen,
-			FromUserID: 1,
-			ToUserID:   nil,
-			Amount:     nil,
-		})
-		_ = publishCommand(ctx, log, q, model.Command{
-			ID:         2,
-			Type:       model.CommandTypeOpen,
-			FromUserID: 2,
-			ToUserID:   nil,
-			Amount:     nil,
-		})
-		_ = publishCommand(ctx, log, q, model.Command{
-			ID:         3,
-			Type:       model.CommandTypeDeposit,
-			FromUserID: 1,
-			ToUserID:   nil,
-			Amount:     intPtr(777),
-		})
-		_ = publishCommand(ctx, log, q, model.Command{
-			ID:         3,
-			Type:       model.CommandTypeTransfer,
-			FromUserID: 1,
-			ToUserID:   intPtr(2),
-			Amount:     intPtr(111),
-		})
-		return nil
-	})
+	if !res.print(os.Stdout) {
+		os.Exit(1)
+	}
+}
 
-	err = eg.Wait()
-	if err != nil && err != shutdown.ErrTermSig && err != context.Canceled {
-		log.WithError(err).Panic("errgroup returned error")
+func readCommands(file, tenantID string) ([]model.Command, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
 	}
 
-	log.Info(ctx, "graceful shutdown successfully finished")
+	var commands []model.Command
+	ids := make(map[int64]int)
+	generatedID := time.Now().UnixNano()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		command := model.Command{}
+		if err := json.Unmarshal([]byte(text), &command); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if command.ID == 0 {
+			command.ID = generatedID + int64(line)
+		}
+		if command.TenantID == "" {
+			command.TenantID = tenantID
+		}
+		if command.TenantID != tenantID {
+			return nil, fmt.Errorf("line %d: command of tenant %q, results are read of tenant %q only", line, command.TenantID, tenantID)
+		}
+		if prev, ok := ids[command.ID]; ok {
+			return nil, fmt.Errorf("line %d: command id %d is already used on line %d", line, command.ID, prev)
+		}
+		ids[command.ID] = line
+		commands = append(commands, command)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(commands) == 0 {
+		return nil, errors.New("no commands")
+	}
+	return commands, nil
 }
 
-func intPtr(i int) *int64 {
-	i64 := int64(i)
-	return &i64
+func newResults(commands []model.Command) *results {
+	res := &results{byID: make(map[int64]*result), pending: len(commands), done: make(chan struct{})}
+	for _, command := range commands {
+		res.byID[command.ID] = &result{command: command, status: statusPending}
+		res.order = append(res.order, command.ID)
+	}
+	return res
 }
 
-func publishCommand(ctx context.Context, log *logrus.Logger, q *queue.Queue, command model.Command) error {
-	err := q.PublishCommand(ctx, command)
+// set saves the first result of the command, results of unknown commands are ignored.
+func (r *results) set(commandID int64, s status, details string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if err != nil {
-		log.WithError(err).Error("error on publishing command")
-		return err
+	res, ok := r.byID[commandID]
+	if !ok || res.status != statusPending {
+		return
 	}
-	log.Info("command published")
-	return nil
+	res.status, res.details = s, details
+	r.pending--
+	if r.pending == 0 {
+		close(r.done)
+	}
+}
+
+// print writes result of every command and summary, it returns true if all commands are completed.
+func (r *results) print(out io.Writer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[status]int)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND_ID\tTYPE\tUSER_ID\tRESULT\tDETAILS")
+	for _, id := range r.order {
+		res := r.byID[id]
+		counts[res.status]++
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			id, res.command.Type, strconv.FormatInt(res.command.FromUserID, 10), res.status, res.details)
+	}
+	_ = w.Flush()
+
+	fmt.Fprintf(out, "\n%d commands: %d completed, %d failed, %d without result\n",
+		len(r.order), counts[statusCompleted], counts[statusFailed], counts[statusPending])
+	return counts[statusCompleted] == len(r.order)
 }
 
 func newLogger() *logrus.Logger {
 	return &logrus.Logger{
-		Out:          os.Stdout,
+		Out:          os.Stderr,
 		Formatter:    new(logrus.TextFormatter),
 		Hooks:        make(logrus.LevelHooks),
-		Level:        logrus.DebugLevel,
+		Level:        logrus.InfoLevel,
 		ExitFunc:     os.Exit,
 		ReportCaller: false,
 	}
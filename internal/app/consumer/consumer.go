@@ -2,54 +2,404 @@ package consumer
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/itimofeev/simple-billing/internal/app/model"
 	"github.com/itimofeev/simple-billing/internal/app/queue"
+	"github.com/itimofeev/simple-billing/internal/app/repository"
 	"github.com/itimofeev/simple-billing/internal/app/service"
+	"github.com/itimofeev/simple-billing/pkg/shutdown"
 )
 
+// Config sets how many commands one worker process handles at the same time.
+type Config struct {
+	// Concurrency is the number of goroutines handling commands.
+	// Commands from the same user are always handled by the same goroutine in the order they were received.
+	// Commands of different senders are not ordered even if they touch the same account, see orderingKey.
+	Concurrency int
+	// MaxInflight is how many unacknowledged commands NATS sends to this worker, 0 means stan default.
+	MaxInflight int
+	// AckWait is how long NATS waits for ack before redelivering command, 0 means stan default.
+	AckWait time.Duration
+
+	// MaxShards limits how many of queue.CommandShards this worker owns, 0 means all free shards.
+	// With several workers set it to spread shards between them, otherwise the rest are hot standby.
+	MaxShards int
+	// ShardsCheckInterval is how often worker checks its shard locks and tries to take free shards.
+	ShardsCheckInterval time.Duration
+	// ScheduledClaimTimeout is how long scheduled command may stay executing before another worker
+	// claims it again, it must be longer than any command takes.
+	ScheduledClaimTimeout time.Duration
+
+	// Policies restrict commands producers may send, nil disables authorization.
+	// Producer is taken from the message envelope, so without signature verification it is not trusted.
+	Policies model.Policies
+
+	// AccountRateLimit and ProducerRateLimit limit how often commands of one account and of one producer
+	// are handled by all workers together, commands over the limit are rejected with model.ErrThrottled.
+	AccountRateLimit  model.RateLimit
+	ProducerRateLimit model.RateLimit
+
+	// Reviewers are producers allowed to approve and reject operations held by screening,
+	// with none set held operations can't be decided with commands.
+	Reviewers []string
+}
+
+// Consumer subscribes to command shards it owns. Shard is owned while Postgres advisory lock
+// for it is held, so each shard is handled by exactly one worker and commands of a user are
+// applied in publish order even with many workers running.
 type Consumer struct {
-	log *logrus.Logger
-	srv *service.Service
-	q   *queue.Queue
+	log  *logrus.Logger
+	srv  *service.Service
+	q    *queue.Queue
+	repo *repository.Repository
+	cfg  Config
+
+	pool *keyedPool
+	late *lateAcks
+
+	// stop is closed when shards are released, then commands are neither retried nor handled anymore
+	stopMu sync.Mutex
+	stop   chan struct{}
+	// scheduledInFlight counts scheduled commands being executed, releaseShards waits for them
+	scheduledInFlight sync.WaitGroup
+
+	mu     sync.Mutex
+	locks  *repository.ShardLocks
+	shards map[int]bool
 }
 
-func New(log *logrus.Logger, srv *service.Service, q *queue.Queue) *Consumer {
+func New(log *logrus.Logger, srv *service.Service, q *queue.Queue, repo *repository.Repository, cfg Config) *Consumer {
+	if cfg.MaxShards <= 0 || cfg.MaxShards > queue.CommandShards {
+		cfg.MaxShards = queue.CommandShards
+	}
+	if cfg.ShardsCheckInterval <= 0 {
+		cfg.ShardsCheckInterval = 5 * time.Second
+	}
+	if cfg.ScheduledClaimTimeout <= 0 {
+		cfg.ScheduledClaimTimeout = 5 * time.Minute
+	}
+	for _, limit := range []*model.RateLimit{&cfg.AccountRateLimit, &cfg.ProducerRateLimit} {
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+	}
 	return &Consumer{
-		log: log,
-		srv: srv,
-		q:   q,
+		log:  log,
+		srv:  srv,
+		q:    q,
+		repo: repo,
+		cfg:  cfg,
+		pool: newKeyedPool(cfg.Concurrency, cfg.MaxInflight),
+		late: newLateAcks(cfg.AckWait),
+		stop: make(chan struct{}),
 	}
 }
 
+// Start takes free shards until ctx is done. Shards stay owned after that until ReleaseShards is called,
+// so that in-flight commands are finished before another worker gets the shard.
 func (c *Consumer) Start(ctx context.Context) error {
-	return c.q.SubscribeCommand(ctx, func(ctx context.Context, command model.Command) error {
-		log := c.log.WithField("command", command)
-		log.Debug("received command")
+	ticker := time.NewTicker(c.cfg.ShardsCheckInterval)
+	defer ticker.Stop()
 
-		var err error
+	for {
+		if err := c.acquireShards(ctx); err != nil {
+			c.log.WithError(err).Error("error on acquiring command shards")
+		}
 
-		switch command.Type {
-		case model.CommandTypeOpen:
-			err = c.srv.CreateAccount(ctx, command.FromUserID)
-		case model.CommandTypeDeposit:
-			err = c.srv.Deposit(ctx, command.FromUserID, *command.Amount)
-		case model.CommandTypeWithdraw:
-			err = c.srv.Withdraw(ctx, command.FromUserID, *command.Amount)
-		case model.CommandTypeTransfer:
-			err = c.srv.Transfer(ctx, command.FromUserID, *command.ToUserID, *command.Amount)
-		default:
-			err = errors.New("unknown command")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
 		}
+	}
+}
+
+func (c *Consumer) acquireShards(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	if c.locks != nil {
+		held, err := c.locks.Held(ctx)
+		if err != nil || held != len(c.shards) {
+			c.log.WithError(err).WithField("held", held).Warn("command shard locks lost, resubscribing")
+			drainCtx, cancel := context.WithTimeout(ctx, c.cfg.ShardsCheckInterval)
+			err := c.releaseShards(drainCtx)
+			cancel()
+			if err != nil {
+				// shards are not taken again until commands received with the lost locks are done
+				return err
+			}
+		}
+	}
+	if c.locks == nil {
+		c.locks = c.repo.NewShardLocks()
+		c.shards = make(map[int]bool)
+		c.stopMu.Lock()
+		c.stop = make(chan struct{})
+		c.stopMu.Unlock()
+	}
+
+	subOpts := queue.SubscribeOptions{
+		MaxInflight: c.cfg.MaxInflight,
+		AckWait:     c.cfg.AckWait,
+	}
+	for shard := 0; shard < queue.CommandShards && len(c.shards) < c.cfg.MaxShards; shard++ {
+		if c.shards[shard] {
+			continue
+		}
+		locked, err := c.locks.TryLock(ctx, shard)
 		if err != nil {
-			log.WithError(err).Error("error on handling command")
 			return err
 		}
-		log.Info("command handled")
+		if !locked {
+			continue
+		}
+		if err := c.q.SubscribeCommand(ctx, shard, subOpts, c.dispatch); err != nil {
+			return err
+		}
+		c.shards[shard] = true
+		c.log.WithField("shard", shard).Info("command shard acquired")
+	}
+	return nil
+}
+
+// ReleaseShards unsubscribes from commands, waits until commands being handled are done and releases shard locks.
+// Received commands not started yet are left unacked for the next owner.
+func (c *Consumer) ReleaseShards(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.releaseShards(ctx)
+}
+
+// releaseShards keeps locks if in-flight commands are not done till ctx is done, so that the next owner
+// of a shard doesn't start the next command of a user while the previous one is still being handled.
+// If locks were lost already, at least this worker doesn't take shards again until commands are done.
+func (c *Consumer) releaseShards(ctx context.Context) error {
+	if c.locks == nil {
+		return nil
+	}
+	err := c.q.StopCommands()
+	c.stopMu.Lock()
+	close(c.stop)
+	c.stopMu.Unlock()
+	if err := c.q.WaitCommandsInFlight(ctx); err != nil {
+		return fmt.Errorf("error on waiting for commands of released shards: %w", err)
+	}
+	if err := shutdown.WaitGroup(ctx, &c.scheduledInFlight); err != nil {
+		return fmt.Errorf("error on waiting for scheduled commands of released shards: %w", err)
+	}
+	if closeErr := c.locks.Close(); err == nil {
+		err = closeErr
+	}
+	c.locks = nil
+	c.shards = nil
+	return err
+}
+
+func (c *Consumer) stopChan() <-chan struct{} {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	return c.stop
+}
+
+// orderingKey returns the account commands are ordered by. It is the sender only: transfer to user B
+// is in the shard of its sender, possibly on another worker, so keying it by B here wouldn't order it
+// with B's own commands anyway. Concurrent changes of B are serialized by balance row locks instead.
+func orderingKey(command model.Command) int64 {
+	return command.FromUserID
+}
+
+func (c *Consumer) dispatch(ctx context.Context, command model.Command, done func(err error)) {
+	received := time.Now()
+	stop := c.stopChan()
+	c.pool.submit(orderingKey(command), func() {
+		done(c.processInOrder(ctx, command, received, stop))
+	})
+}
+
+// processInOrder retries command failing with an error that may go away in place, so that later commands
+// of the key wait for it. Otherwise they would be acked before the failed one is redelivered.
+// After stop is closed queued commands are not handled: they stay unacked and the next owner
+// of the shard receives them in order.
+func (c *Consumer) processInOrder(ctx context.Context, command model.Command, received time.Time, stop <-chan struct{}) error {
+	delivery, _ := queue.DeliveryFromContext(ctx)
+	if c.late.applied(delivery) {
+		c.log.WithField("command_id", command.ID).Info("copy of command applied already, acking it")
 		return nil
+	}
+
+	redelivered := delivery.Redelivered
+	err := retryInPlace(stop, minRetryBackoff, func() error {
+		err := c.process(ctx, command, redelivered)
+		redelivered = true
+		if err != nil {
+			c.log.WithError(err).WithField("command_id", command.ID).Warn("error on processing command, retrying")
+		}
+		return err
 	})
+	if err == nil {
+		c.late.ack(delivery, received, time.Now())
+	}
+	return err
+}
+
+// process handles command and publishes failure event if command was rejected. Only errors
+// that may go away on retry are returned, so that the command is redelivered. Redelivered command
+// is not throttled again.
+func (c *Consumer) process(ctx context.Context, command model.Command, redelivered bool) error {
+	producer := ""
+	if env, ok := queue.EnvelopeFromContext(ctx); ok {
+		producer = env.Producer
+	}
+	err := c.handle(ctx, command, source{producer: &producer, redelivered: redelivered})
+	if err == nil {
+		return nil
+	}
+	if _, ok := model.ErrorCode(err); !ok {
+		return err
+	}
+	return c.q.PublishOperationFailed(ctx, model.NewOperationFailed(command, err))
+}
+
+// source is where the handled command comes from.
+type source struct {
+	// producer is the client id of the sender, nil if it is unknown but the command was authorized
+	// already, i.e. scheduled before producers of scheduled commands were stored.
+	producer *string
+	// scheduled is true for due commands executed by scheduler. They are not throttled again:
+	// rate limits were applied when the command was received.
+	scheduled bool
+	// redelivered is true for commands received or retried before, their tokens were taken already.
+	redelivered bool
+}
+
+func (c *Consumer) handle(ctx context.Context, command model.Command, src source) error {
+	ctx = model.ContextWithCommandID(ctx, command.ID)
+	ctx = model.ContextWithTenantID(ctx, command.Tenant())
+	if command.Currency != "" {
+		ctx = model.ContextWithCurrency(ctx, command.Currency)
+	}
+	producer := ""
+	if src.producer != nil {
+		producer = *src.producer
+		ctx = model.ContextWithProducer(ctx, producer)
+	}
+	if c.isReviewer(src) {
+		ctx = model.ContextWithReviewer(ctx)
+	}
+	log := c.log.WithField("command", command).WithField("producer", producer)
+	log.Debug("received command")
+
+	err := command.Validate()
+	if err != nil {
+		log.WithError(err).Error("invalid command")
+		return err
+	}
+	if c.cfg.Policies != nil && src.producer != nil {
+		if err := c.cfg.Policies.Authorize(producer, command); err != nil {
+			log.WithError(err).Warn("command is not authorized")
+			return err
+		}
+	}
+	if err := c.checkReviewer(src, command); err != nil {
+		log.WithError(err).Warn("command is not authorized")
+		return err
+	}
+	if err := c.throttle(ctx, src, command); err != nil {
+		log.WithError(err).Warn("command is throttled")
+		return err
+	}
+
+	switch {
+	case command.IsScheduled(time.Now()):
+		err = c.srv.Schedule(ctx, producer, command)
+	case command.Type == model.CommandTypeCancelScheduled:
+		err = c.srv.CancelScheduled(ctx, command.FromUserID, *command.ScheduledID)
+	default:
+		err = c.execute(ctx, command)
+	}
+
+	if err != nil {
+		log.WithError(err).Error("error on handling command")
+		return err
+	}
+	log.Info("command handled")
+	return nil
+}
+
+// checkReviewer returns model.ErrForbidden if the command decides a review and its producer
+// is not one of Config.Reviewers. Service also forbids producer of the held command to decide it.
+func (c *Consumer) checkReviewer(src source, command model.Command) error {
+	if command.Type != model.CommandTypeApproveReview && command.Type != model.CommandTypeRejectReview {
+		return nil
+	}
+	if src.producer == nil {
+		return fmt.Errorf("%w: unknown producer can't decide reviews", model.ErrForbidden)
+	}
+	if !c.isReviewer(src) {
+		return fmt.Errorf("%w: %q is not a reviewer", model.ErrForbidden, *src.producer)
+	}
+	return nil
+}
+
+// isReviewer returns true if the command is sent by one of Config.Reviewers.
+func (c *Consumer) isReviewer(src source) bool {
+	if src.producer == nil {
+		return false
+	}
+	for _, reviewer := range c.cfg.Reviewers {
+		if reviewer == *src.producer {
+			return true
+		}
+	}
+	return false
+}
+
+// execute calls service method for the command type.
+func (c *Consumer) execute(ctx context.Context, command model.Command) (err error) {
+	switch command.Type {
+	case model.CommandTypeOpen:
+		err = c.srv.CreateAccount(ctx, command.FromUserID)
+	case model.CommandTypeDeposit:
+		err = c.srv.Deposit(ctx, command.FromUserID, *command.Amount)
+	case model.CommandTypeWithdraw:
+		err = c.srv.Withdraw(ctx, command.FromUserID, *command.Amount)
+	case model.CommandTypeTransfer:
+		err = c.srv.Transfer(ctx, command.FromUserID, *command.ToUserID, *command.Amount)
+	case model.CommandTypeFreeze:
+		err = c.srv.Freeze(ctx, command.FromUserID)
+	case model.CommandTypeUnfreeze:
+		err = c.srv.Unfreeze(ctx, command.FromUserID)
+	case model.CommandTypeClose:
+		err = c.srv.Close(ctx, command.FromUserID, command.ToUserID)
+	case model.CommandTypeSetCreditLimit:
+		err = c.srv.SetCreditLimit(ctx, command.FromUserID, *command.Amount)
+	case model.CommandTypeSetLimits:
+		err = c.srv.SetLimits(ctx, command.FromUserID, *command.Limits)
+	case model.CommandTypeReverse:
+		err = c.srv.Reverse(ctx, command.FromUserID, *command.EventID, command.Amount)
+	case model.CommandTypeBatchTransfer:
+		err = c.srv.BatchTransfer(ctx, command.FromUserID, command.Legs)
+	case model.CommandTypeSetFeeRule:
+		err = c.srv.SetFeeRule(ctx, *command.FeeRule)
+	case model.CommandTypeCreateMandate:
+		err = c.srv.CreateMandate(ctx, command.FromUserID, *command.ToUserID, *command.Amount, *command.Mandate)
+	case model.CommandTypeCancelMandate:
+		err = c.srv.CancelMandate(ctx, command.FromUserID, *command.MandateID)
+	case model.CommandTypeResumeMandate:
+		err = c.srv.ResumeMandate(ctx, command.FromUserID, *command.MandateID)
+	case model.CommandTypeApproveReview:
+		err = c.srv.ApproveReview(ctx, command.FromUserID, *command.ReviewID)
+	case model.CommandTypeRejectReview:
+		err = c.srv.RejectReview(ctx, command.FromUserID, *command.ReviewID)
+	default:
+		err = model.ErrUnknownCommand
+	}
+	return err
 }
@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+func TestOnlyReviewersDecideReviews(t *testing.T) {
+	c := &Consumer{cfg: Config{Reviewers: []string{"admin"}}}
+	reviewID := int64(1)
+	approve := model.Command{Type: model.CommandTypeApproveReview, FromUserID: 1, ReviewID: &reviewID}
+	admin, shop := "admin", "shop"
+
+	require.NoError(t, c.checkReviewer(source{producer: &admin}, approve))
+	require.ErrorIs(t, c.checkReviewer(source{producer: &shop}, approve), model.ErrForbidden)
+	require.ErrorIs(t, c.checkReviewer(source{scheduled: true}, approve), model.ErrForbidden)
+	require.ErrorIs(t, (&Consumer{}).checkReviewer(source{producer: &admin}, approve), model.ErrForbidden)
+
+	// other commands are not restricted
+	require.NoError(t, c.checkReviewer(source{producer: &shop}, model.Command{Type: model.CommandTypeOpen, FromUserID: 1}))
+}
+
+func TestReviewersAreMarkedInContext(t *testing.T) {
+	c := &Consumer{cfg: Config{Reviewers: []string{"admin"}}}
+	admin, shop := "admin", "shop"
+
+	require.True(t, c.isReviewer(source{producer: &admin}))
+	require.False(t, c.isReviewer(source{producer: &shop}))
+	require.False(t, c.isReviewer(source{scheduled: true}))
+	require.True(t, model.ReviewerFromContext(model.ContextWithReviewer(context.Background())))
+	require.False(t, model.ReviewerFromContext(context.Background()))
+}
+
+func TestScheduledCommandsOfReleasedShardsAreNotStarted(t *testing.T) {
+	c := &Consumer{stop: make(chan struct{})}
+	stop := c.stopChan()
+
+	require.True(t, c.startScheduled(stop))
+	close(c.stop)
+	require.False(t, c.startScheduled(stop))
+
+	// release waits for the started one only
+	c.scheduledInFlight.Done()
+	c.scheduledInFlight.Wait()
+}
+
+func TestMandateIsAuthorizedAgain(t *testing.T) {
+	c := &Consumer{cfg: Config{Policies: model.Policies{
+		{Producer: "shop", Commands: []model.CommandType{model.CommandTypeCreateMandate}, Accounts: []model.AccountRange{{Min: 1, Max: 10}}},
+	}}}
+	shop, bank := "shop", "bank"
+	mandate := model.Mandate{TenantID: model.DefaultTenant, FromUserID: 1, ToUserID: 2, Amount: 10, Producer: &shop}
+
+	require.NoError(t, c.authorizeMandate(mandate))
+	mandate.ToUserID = 11
+	require.ErrorIs(t, c.authorizeMandate(mandate), model.ErrForbidden)
+	mandate.Producer = &bank
+	require.ErrorIs(t, c.authorizeMandate(mandate), model.ErrForbidden)
+
+	// mandates created before producers were stored are not checked
+	mandate.Producer = nil
+	require.NoError(t, c.authorizeMandate(mandate))
+}
+
+func TestRedeliveredCommandsAreNotThrottledAgain(t *testing.T) {
+	// without repo taking a token would panic
+	c := &Consumer{cfg: Config{AccountRateLimit: model.RateLimit{Rate: 1, Burst: 1}}}
+	shop := "shop"
+	command := model.Command{Type: model.CommandTypeOpen, FromUserID: 1}
+
+	require.NoError(t, c.throttle(context.Background(), source{producer: &shop, redelivered: true}, command))
+	require.NoError(t, c.throttle(context.Background(), source{producer: &shop, scheduled: true}, command))
+}
@@ -0,0 +1,30 @@
+package consumer
+
+// keyedPool runs jobs in a fixed number of goroutines. Jobs with the same key always go
+// to the same goroutine, so they are executed one by one in submission order.
+type keyedPool struct {
+	queues []chan func()
+}
+
+func newKeyedPool(size, queueSize int) *keyedPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &keyedPool{queues: make([]chan func(), size)}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queueSize)
+		go p.run(p.queues[i])
+	}
+	return p
+}
+
+func (p *keyedPool) run(jobs <-chan func()) {
+	for job := range jobs {
+		job()
+	}
+}
+
+// submit blocks if the goroutine for the key has queueSize jobs waiting already.
+func (p *keyedPool) submit(key int64, job func()) {
+	p.queues[uint64(key)%uint64(len(p.queues))] <- job
+}
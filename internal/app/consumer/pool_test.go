@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+func TestKeyedPoolKeepsOrderForKey(t *testing.T) {
+	p := newKeyedPool(4, 0)
+
+	const keys, jobsPerKey = 10, 100
+
+	mu := sync.Mutex{}
+	got := make(map[int64][]int)
+	wg := sync.WaitGroup{}
+	wg.Add(keys * jobsPerKey)
+
+	for i := 0; i < jobsPerKey; i++ {
+		for key := int64(0); key < keys; key++ {
+			key, i := key, i
+			p.submit(key, func() {
+				defer wg.Done()
+				mu.Lock()
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			})
+		}
+	}
+	wg.Wait()
+
+	for key := int64(0); key < keys; key++ {
+		require.Len(t, got[key], jobsPerKey)
+		for i, v := range got[key] {
+			require.Equal(t, i, v)
+		}
+	}
+}
+
+func TestKeyedPoolDoesNotOrderDifferentKeys(t *testing.T) {
+	p := newKeyedPool(2, 0)
+
+	blocked, released := make(chan struct{}), make(chan struct{})
+	p.submit(0, func() {
+		close(blocked)
+		<-released
+	})
+	<-blocked
+
+	done := make(chan struct{})
+	p.submit(1, func() { close(done) })
+	<-done
+	close(released)
+}
+
+func TestOrderingKeyIsSender(t *testing.T) {
+	to, amount := int64(2), int64(10)
+	transfer := model.Command{Type: model.CommandTypeTransfer, FromUserID: 1, ToUserID: &to, Amount: &amount}
+
+	// transfer to user 2 is not ordered with commands of user 2
+	require.EqualValues(t, 1, orderingKey(transfer))
+}
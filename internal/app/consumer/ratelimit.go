@@ -0,0 +1,42 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// throttle takes tokens of the producer and the account of the command, it returns model.ErrThrottled
+// if any of the buckets is empty. Producer is checked first, so that a flooding producer doesn't use up
+// tokens of accounts it sends commands for. Scheduled and redelivered commands took their tokens already.
+func (c *Consumer) throttle(ctx context.Context, src source, command model.Command) error {
+	if src.scheduled || src.redelivered {
+		return nil
+	}
+	producer := ""
+	if src.producer != nil {
+		producer = *src.producer
+	}
+	buckets := []struct {
+		key   string
+		limit model.RateLimit
+	}{
+		{"producer:" + producer, c.cfg.ProducerRateLimit},
+		{"account:" + strconv.FormatInt(command.FromUserID, 10), c.cfg.AccountRateLimit},
+	}
+	for _, b := range buckets {
+		if b.limit.Rate <= 0 {
+			continue
+		}
+		ok, err := c.repo.TakeToken(c.repo.GetDB(ctx), command.Tenant(), b.key, b.limit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s is over %g per second", model.ErrThrottled, b.key, b.limit.Rate)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/itimofeev/simple-billing/internal/app/service"
+)
+
+// RunReconciliation reconciles balances every interval until ctx is done and saves reports to reportDir.
+// If several workers run it, only one of them reconciles at a time.
+func (c *Consumer) RunReconciliation(ctx context.Context, interval time.Duration, reportDir string) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := c.reconcile(ctx, reportDir); err != nil {
+			c.log.WithError(err).Error("error on reconciling balances")
+		}
+	}
+}
+
+func (c *Consumer) reconcile(ctx context.Context, reportDir string) error {
+	report, err := c.srv.Reconcile(ctx)
+	if errors.Is(err, service.ErrReconciliationRunning) {
+		c.log.Info("reconciliation is running in another worker")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(reportDir, report.FileName())
+	if err := report.WriteFile(path); err != nil {
+		return fmt.Errorf("error on writing reconciliation report: %w", err)
+	}
+
+	log := c.log.WithField("accounts", report.Accounts).WithField("discrepancies", len(report.Discrepancies)).WithField("report", path)
+	if len(report.Discrepancies) > 0 {
+		log.Error("balances do not match events")
+		return nil
+	}
+	log.Info("balances reconciled")
+	return nil
+}
@@ -0,0 +1,91 @@
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/stan.go"
+
+	"github.com/itimofeev/simple-billing/internal/app/queue"
+)
+
+const (
+	minRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+// errShardsReleased is returned for commands left unacked because shards were released,
+// the next owner of the shard receives them again in order.
+var errShardsReleased = errors.New("command shards released, command is left to the next owner")
+
+// retryInPlace calls f until it succeeds or stop is closed, waiting backoff between calls and doubling it
+// up to maxRetryBackoff. If stop is closed before the first call, f is not called.
+func retryInPlace(stop <-chan struct{}, backoff time.Duration, f func() error) error {
+	select {
+	case <-stop:
+		return errShardsReleased
+	default:
+	}
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-stop:
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// lateAcks remembers commands acked after AckWait. NATS has sent their copies meanwhile, the copies are queued
+// after the command for the same key and must be acked without applying the command again.
+type lateAcks struct {
+	ackWait time.Duration
+
+	mu    sync.Mutex
+	acked map[queue.Delivery]time.Time
+}
+
+func newLateAcks(ackWait time.Duration) *lateAcks {
+	if ackWait <= 0 {
+		ackWait = stan.DefaultAckWait
+	}
+	return &lateAcks{ackWait: ackWait, acked: make(map[queue.Delivery]time.Time)}
+}
+
+// ack is called when command received at received time is acked at now. Its copies were sent before the ack
+// and only wait in the pool queue, so late acks are forgotten after a few AckWaits.
+func (l *lateAcks) ack(d queue.Delivery, received, now time.Time) {
+	if now.Sub(received) < l.ackWait {
+		return
+	}
+	d.Redelivered = false
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for acked, t := range l.acked {
+		if now.Sub(t) > 10*l.ackWait {
+			delete(l.acked, acked)
+		}
+	}
+	l.acked[d] = now
+}
+
+// applied returns true if d is a copy of a command acked late, there may be several copies of it.
+func (l *lateAcks) applied(d queue.Delivery) bool {
+	if !d.Redelivered {
+		return false
+	}
+	d.Redelivered = false
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.acked[d]
+	return ok
+}
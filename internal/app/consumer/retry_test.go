@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/queue"
+)
+
+func TestFailedCommandBlocksLaterCommandsOfKey(t *testing.T) {
+	p := newKeyedPool(1, 10)
+	stop := make(chan struct{})
+
+	mu := sync.Mutex{}
+	var handled []string
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+
+	attempts := 0
+	p.submit(1, func() {
+		defer wg.Done()
+		err := retryInPlace(stop, time.Millisecond, func() error {
+			if attempts++; attempts < 3 {
+				return errors.New("db is down")
+			}
+			mu.Lock()
+			handled = append(handled, "first")
+			mu.Unlock()
+			return nil
+		})
+		require.NoError(t, err)
+	})
+	p.submit(1, func() {
+		defer wg.Done()
+		require.NoError(t, retryInPlace(stop, time.Millisecond, func() error {
+			mu.Lock()
+			handled = append(handled, "second")
+			mu.Unlock()
+			return nil
+		}))
+	})
+	wg.Wait()
+
+	require.Equal(t, 3, attempts)
+	require.Equal(t, []string{"first", "second"}, handled)
+}
+
+func TestRetryStopsWhenShardsReleased(t *testing.T) {
+	stop := make(chan struct{})
+	failing := errors.New("db is down")
+
+	calls := 0
+	err := retryInPlace(stop, time.Millisecond, func() error {
+		if calls++; calls == 2 {
+			close(stop)
+		}
+		return failing
+	})
+	require.ErrorIs(t, err, failing)
+	require.Equal(t, 2, calls)
+
+	// queued commands are not handled after release
+	err = retryInPlace(stop, time.Millisecond, func() error {
+		calls++
+		return nil
+	})
+	require.ErrorIs(t, err, errShardsReleased)
+	require.Equal(t, 2, calls)
+}
+
+func TestCopiesOfLateAckedCommandAreNotApplied(t *testing.T) {
+	l := newLateAcks(time.Second)
+	now := time.Now()
+	late := queue.Delivery{Subject: "input.command.0", Sequence: 1}
+	inTime := queue.Delivery{Subject: "input.command.0", Sequence: 2}
+
+	l.ack(late, now.Add(-2*time.Second), now)
+	l.ack(inTime, now.Add(-time.Millisecond), now)
+
+	require.False(t, l.applied(late))
+	late.Redelivered, inTime.Redelivered = true, true
+	require.True(t, l.applied(late))
+	require.True(t, l.applied(late))
+	require.False(t, l.applied(inTime))
+
+	// forgotten when no copies can be queued anymore
+	l.ack(inTime, now, now.Add(time.Minute))
+	require.False(t, l.applied(late))
+}
@@ -0,0 +1,184 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/internal/app/queue"
+)
+
+const scheduledBatchSize = 100
+
+// RunScheduler executes due scheduled commands and mandates until ctx is done. It is safe to run in every worker:
+// each worker executes commands of the shards it owns only. Commands failed with temporary errors are retried
+// on the next run, commands rejected by business rules are not retried.
+func (c *Consumer) RunScheduler(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.executeDueCommands(ctx); err != nil {
+			c.log.WithError(err).Error("error on executing scheduled commands")
+		}
+		if err := c.runDueMandates(ctx); err != nil {
+			c.log.WithError(err).Error("error on running mandates")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// executeDueCommands claims commands while ctx is not done. Commands interrupted by shutdown stay claimed
+// and are claimed again after ScheduledClaimTimeout, WasScheduledExecuted tells if they were executed.
+func (c *Consumer) executeDueCommands(ctx context.Context) error {
+	for ctx.Err() == nil {
+		n, err := c.executeDueBatch(ctx)
+		if err != nil || n < scheduledBatchSize {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeDueBatch executes commands of owned shards through the pool, so they are ordered with commands
+// of the same user received from the queue. Commands of shards released after they were claimed are
+// put back, releaseShards waits for the ones being executed.
+func (c *Consumer) executeDueBatch(ctx context.Context) (int, error) {
+	shards, stop := c.ownedShards()
+	if len(shards) == 0 {
+		return 0, nil
+	}
+
+	commands, err := c.srv.ClaimDueCommands(ctx, shards, queue.CommandShards, c.cfg.ScheduledClaimTimeout, scheduledBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(commands))
+	for _, scheduled := range commands {
+		scheduled := scheduled
+		scheduled.Command.TenantID = scheduled.TenantID
+		c.pool.submit(orderingKey(scheduled.Command), func() {
+			defer wg.Done()
+			c.executeOwned(ctx, scheduled, stop)
+		})
+	}
+	wg.Wait()
+	return len(commands), nil
+}
+
+// ownedShards returns shards owned now and the channel closed once they are released. Shards and stop are
+// changed together under c.mu.
+func (c *Consumer) ownedShards() ([]int, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	shards := make([]int, 0, len(c.shards))
+	for shard := range c.shards {
+		shards = append(shards, shard)
+	}
+	return shards, c.stopChan()
+}
+
+// executeOwned executes scheduled command unless shards were released after it was claimed.
+func (c *Consumer) executeOwned(ctx context.Context, scheduled model.ScheduledCommand, stop <-chan struct{}) {
+	if !c.startScheduled(stop) {
+		if err := c.srv.ReleaseScheduled(ctx, scheduled); err != nil {
+			c.log.WithError(err).WithField("scheduled_id", scheduled.ID).Error("error on releasing scheduled command")
+		}
+		return
+	}
+	defer c.scheduledInFlight.Done()
+
+	c.executeScheduled(ctx, scheduled)
+}
+
+// startScheduled counts scheduled command in flight if stop is not closed yet.
+func (c *Consumer) startScheduled(stop <-chan struct{}) bool {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	c.scheduledInFlight.Add(1)
+	return true
+}
+
+func (c *Consumer) executeScheduled(ctx context.Context, scheduled model.ScheduledCommand) {
+	log := c.log.WithField("scheduled_id", scheduled.ID)
+	// events of the command get the scheduled id, so that WasScheduledExecuted finds them
+	ctx = model.ContextWithScheduledCommandID(ctx, scheduled.ID)
+
+	executed, err := c.srv.WasScheduledExecuted(ctx, scheduled)
+	if err != nil {
+		// stays executing and is claimed again after timeout
+		log.WithError(err).Error("error on checking previous execution of scheduled command")
+		return
+	}
+
+	var execErr error
+	if !executed {
+		// policies of the producer are checked again, they may have changed since the command was scheduled
+		execErr = c.handle(ctx, scheduled.Command, source{producer: scheduled.Producer, scheduled: true})
+	}
+	if execErr != nil {
+		if _, ok := model.ErrorCode(execErr); !ok {
+			log.WithError(execErr).Warn("scheduled command failed with temporary error, will be retried")
+			if err := c.srv.ReleaseScheduled(ctx, scheduled); err != nil {
+				log.WithError(err).Error("error on releasing scheduled command")
+			}
+			return
+		}
+		if err := c.q.PublishOperationFailed(ctx, model.NewOperationFailed(scheduled.Command, execErr)); err != nil {
+			log.WithError(err).Error("error on publishing failure of scheduled command")
+		}
+	}
+	if err := c.srv.FinishScheduled(ctx, scheduled, execErr); err != nil {
+		log.WithError(err).Error("error on finishing scheduled command")
+	}
+}
+
+// runDueMandates runs due mandates of payers in owned shards one by one while ctx is not done.
+func (c *Consumer) runDueMandates(ctx context.Context) error {
+	shards, stop := c.ownedShards()
+	if len(shards) == 0 {
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		if !c.startScheduled(stop) {
+			return nil
+		}
+		ok, err := c.srv.RunDueMandate(ctx, shards, queue.CommandShards, c.authorizeMandate)
+		c.scheduledInFlight.Done()
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeMandate checks policies of the producer of the mandate again, they may have changed since it was created.
+func (c *Consumer) authorizeMandate(mandate model.Mandate) error {
+	if c.cfg.Policies == nil || mandate.Producer == nil {
+		return nil
+	}
+	toUserID, amount := mandate.ToUserID, mandate.Amount
+	return c.cfg.Policies.Authorize(*mandate.Producer, model.Command{
+		TenantID:   mandate.TenantID,
+		Type:       model.CommandTypeCreateMandate,
+		FromUserID: mandate.FromUserID,
+		ToUserID:   &toUserID,
+		Amount:     &amount,
+	})
+}
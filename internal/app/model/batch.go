@@ -0,0 +1,47 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+// EventLeg is a part of batch transfer, negative Amount is debited from the user, positive is credited.
+type EventLeg struct {
+	EventID  int64  `pg:"event_id,pk" json:"-"`
+	Number   int    `pg:"number,pk,use_zero" json:"-"`
+	TenantID string `pg:"tenant_id,notnull" json:"-"`
+	UserID   int64  `pg:"user_id,notnull" json:"user_id"`
+	Amount   int64  `pg:"amount,notnull" json:"amount"`
+}
+
+// ValidateLegs checks that batch transfer legs net to zero and payer is the only debited user:
+// sender of the command can't take money from other accounts. It returns total amount debited.
+func ValidateLegs(payerID int64, legs []*EventLeg) (total int64, err error) {
+	var credited int64
+	for i, leg := range legs {
+		switch {
+		case leg == nil:
+			return 0, fmt.Errorf("%w: leg %d is empty", ErrInvalidCommand, i)
+		case leg.Amount < 0 && leg.UserID != payerID:
+			return 0, fmt.Errorf("%w: leg %d debits user %d, not the payer", ErrInvalidCommand, i, leg.UserID)
+		case leg.Amount < 0:
+			if total, err = money.Sub(total, leg.Amount); err != nil {
+				return 0, fmt.Errorf("%w: leg %d overflows total", ErrInvalidCommand, i)
+			}
+		case leg.Amount > 0:
+			if credited, err = money.Add(credited, leg.Amount); err != nil {
+				return 0, fmt.Errorf("%w: leg %d overflows total", ErrInvalidCommand, i)
+			}
+		default:
+			return 0, fmt.Errorf("%w: leg %d has zero amount", ErrInvalidCommand, i)
+		}
+	}
+	if total == 0 || credited == 0 {
+		return 0, fmt.Errorf("%w: batch must have debit and credit legs", ErrInvalidCommand)
+	}
+	if total != credited {
+		return 0, fmt.Errorf("%w: debited %d, credited %d", ErrInvalidCommand, total, credited)
+	}
+	return total, nil
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLegs(t *testing.T) {
+	total, err := ValidateLegs(1, []*EventLeg{
+		{UserID: 1, Amount: -60},
+		{UserID: 1, Amount: -40},
+		{UserID: 2, Amount: 80},
+		{UserID: 3, Amount: 15},
+		{UserID: 4, Amount: 5},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 100, total)
+
+	invalid := map[string][]*EventLeg{
+		"empty":             nil,
+		"nil leg":           {{UserID: 1, Amount: -1}, nil},
+		"zero leg":          {{UserID: 1, Amount: -1}, {UserID: 2, Amount: 1}, {UserID: 3}},
+		"no credit":         {{UserID: 1, Amount: -1}},
+		"not netting":       {{UserID: 1, Amount: -10}, {UserID: 2, Amount: 9}},
+		"payer not debited": {{UserID: 2, Amount: -10}, {UserID: 1, Amount: 10}},
+		"foreign debit":     {{UserID: 1, Amount: -10}, {UserID: 2, Amount: -5}, {UserID: 3, Amount: 15}},
+		"overflow":          {{UserID: 1, Amount: -math.MaxInt64}, {UserID: 1, Amount: -1}, {UserID: 2, Amount: 1}},
+	}
+	for name, legs := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := ValidateLegs(1, legs)
+			require.ErrorIs(t, err, ErrInvalidCommand)
+		})
+	}
+}
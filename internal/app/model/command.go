@@ -1,5 +1,13 @@
 package model
 
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
 type CommandType string
 
 const (
@@ -7,12 +15,204 @@ const (
 	CommandTypeDeposit  CommandType = "deposit"
 	CommandTypeWithdraw CommandType = "withdraw"
 	CommandTypeTransfer CommandType = "transfer"
+	CommandTypeFreeze   CommandType = "freeze"
+	CommandTypeUnfreeze CommandType = "unfreeze"
+	// CommandTypeClose closes account of FromUserID. If ToUserID is set, the rest of the balance
+	// is transferred to it, otherwise balance must be zero.
+	CommandTypeClose CommandType = "close"
+	// CommandTypeSetCreditLimit sets how far below zero balance of FromUserID may go to Amount.
+	CommandTypeSetCreditLimit CommandType = "set_credit_limit"
+	// CommandTypeSetLimits sets Limits for outgoing operations of FromUserID.
+	CommandTypeSetLimits CommandType = "set_limits"
+	// CommandTypeSetFeeRule adds new version of FeeRule.
+	CommandTypeSetFeeRule CommandType = "set_fee_rule"
+	// CommandTypeReverse reverses Amount of deposit, withdraw or transfer EventID made by FromUserID.
+	// If Amount is nil, everything that was not reversed yet is reversed.
+	CommandTypeReverse CommandType = "reverse"
+	// CommandTypeBatchTransfer moves money according to Legs that must net to zero,
+	// only FromUserID may be debited.
+	CommandTypeBatchTransfer CommandType = "batch_transfer"
+	// CommandTypeCancelScheduled cancels ScheduledID of FromUserID if it is not executed yet.
+	CommandTypeCancelScheduled CommandType = "cancel_scheduled"
+	// CommandTypeCreateMandate creates mandate to transfer Amount from FromUserID to ToUserID
+	// according to Mandate terms.
+	CommandTypeCreateMandate CommandType = "create_mandate"
+	// CommandTypeCancelMandate and CommandTypeResumeMandate change MandateID of FromUserID.
+	CommandTypeCancelMandate CommandType = "cancel_mandate"
+	CommandTypeResumeMandate CommandType = "resume_mandate"
+	// CommandTypeApproveReview makes operation of ReviewID held by screening, CommandTypeRejectReview drops it.
+	// FromUserID must be the user of the review.
+	CommandTypeApproveReview CommandType = "approve_review"
+	CommandTypeRejectReview  CommandType = "reject_review"
 )
 
 type Command struct {
-	ID         int64       `json:"id"`
+	ID int64 `json:"id"`
+	// TenantID is the tenant all accounts of the command belong to, DefaultTenant if empty.
+	TenantID   string      `json:"tenant_id,omitempty"`
 	Type       CommandType `json:"type"`
 	FromUserID int64       `json:"from_user_id"`
 	ToUserID   *int64      `json:"to_user_id"`
 	Amount     *int64      `json:"amount"`
+	Limits     *Limits     `json:"limits,omitempty"`
+	FeeRule    *FeeRule    `json:"fee_rule,omitempty"`
+	EventID    *int64      `json:"event_id,omitempty"`
+	Legs       []*EventLeg `json:"legs,omitempty"`
+	// Currency is ISO 4217 code of Amount and Legs, they must be in the currency of the accounts.
+	// For open it is the currency of the new account. Empty means the currency of the accounts,
+	// DefaultCurrency for open.
+	Currency string `json:"currency,omitempty"`
+	// ExecuteAt in the future makes worker save the command and execute it when due.
+	ExecuteAt   *time.Time `json:"execute_at,omitempty"`
+	ScheduledID *int64     `json:"scheduled_id,omitempty"`
+
+	Mandate   *MandateTerms `json:"mandate,omitempty"`
+	MandateID *int64        `json:"mandate_id,omitempty"`
+
+	ReviewID *int64 `json:"review_id,omitempty"`
+}
+
+// IsScheduled returns true if command must be executed later than now.
+func (c Command) IsScheduled(now time.Time) bool {
+	return c.ExecuteAt != nil && c.ExecuteAt.After(now)
+}
+
+// Tenant returns TenantID or DefaultTenant if it is not set.
+func (c Command) Tenant() string {
+	if c.TenantID == "" {
+		return DefaultTenant
+	}
+	return c.TenantID
+}
+
+// Validate checks that command has all fields required by its type.
+func (c Command) Validate() error {
+	if err := ValidateTenantID(c.Tenant()); err != nil {
+		return err
+	}
+	if c.Currency != "" {
+		if currency, err := money.ParseCurrency(c.Currency); err != nil || currency.Code != c.Currency {
+			return fmt.Errorf("%w: currency %q must be upper case code of a known currency", ErrInvalidCommand, c.Currency)
+		}
+	}
+	switch c.Type {
+	case CommandTypeOpen, CommandTypeFreeze, CommandTypeUnfreeze, CommandTypeClose:
+	case CommandTypeDeposit, CommandTypeWithdraw, CommandTypeSetCreditLimit:
+		if c.Amount == nil {
+			return fmt.Errorf("%w: amount is required", ErrInvalidCommand)
+		}
+	case CommandTypeTransfer:
+		if c.Amount == nil || c.ToUserID == nil {
+			return fmt.Errorf("%w: amount and to_user_id are required", ErrInvalidCommand)
+		}
+	case CommandTypeSetLimits:
+		if c.Limits == nil {
+			return fmt.Errorf("%w: limits are required", ErrInvalidCommand)
+		}
+	case CommandTypeReverse:
+		if c.EventID == nil {
+			return fmt.Errorf("%w: event_id is required", ErrInvalidCommand)
+		}
+	case CommandTypeBatchTransfer:
+		if len(c.Legs) == 0 {
+			return fmt.Errorf("%w: legs are required", ErrInvalidCommand)
+		}
+	case CommandTypeCancelScheduled:
+		if c.ScheduledID == nil {
+			return fmt.Errorf("%w: scheduled_id is required", ErrInvalidCommand)
+		}
+	case CommandTypeCreateMandate:
+		if c.Amount == nil || c.ToUserID == nil || c.Mandate == nil {
+			return fmt.Errorf("%w: amount, to_user_id and mandate are required", ErrInvalidCommand)
+		}
+	case CommandTypeCancelMandate, CommandTypeResumeMandate:
+		if c.MandateID == nil {
+			return fmt.Errorf("%w: mandate_id is required", ErrInvalidCommand)
+		}
+	case CommandTypeApproveReview, CommandTypeRejectReview:
+		if c.ReviewID == nil {
+			return fmt.Errorf("%w: review_id is required", ErrInvalidCommand)
+		}
+	case CommandTypeSetFeeRule:
+		if c.FeeRule == nil {
+			return fmt.Errorf("%w: fee_rule is required", ErrInvalidCommand)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, c.Type)
+	}
+	return nil
+}
+
+type commandIDKey struct{}
+
+// ContextWithCommandID returns ctx of handling the command, events added in transactions with this ctx get the command id.
+func ContextWithCommandID(ctx context.Context, commandID int64) context.Context {
+	return context.WithValue(ctx, commandIDKey{}, commandID)
+}
+
+// CommandIDFromContext returns id of the command being handled, nil if ctx is not related to a command.
+func CommandIDFromContext(ctx context.Context) *int64 {
+	if id, ok := ctx.Value(commandIDKey{}).(int64); ok {
+		return &id
+	}
+	return nil
+}
+
+type scheduledCommandIDKey struct{}
+
+// ContextWithScheduledCommandID returns ctx of executing the scheduled command, events added in transactions
+// with this ctx get its id.
+func ContextWithScheduledCommandID(ctx context.Context, scheduledID int64) context.Context {
+	return context.WithValue(ctx, scheduledCommandIDKey{}, scheduledID)
+}
+
+// ScheduledCommandIDFromContext returns id of the scheduled command being executed, nil if there is none.
+func ScheduledCommandIDFromContext(ctx context.Context) *int64 {
+	if id, ok := ctx.Value(scheduledCommandIDKey{}).(int64); ok {
+		return &id
+	}
+	return nil
+}
+
+type producerKey struct{}
+
+// ContextWithProducer returns ctx of handling the command sent by producer.
+func ContextWithProducer(ctx context.Context, producer string) context.Context {
+	return context.WithValue(ctx, producerKey{}, producer)
+}
+
+// ProducerFromContext returns producer of the command being handled, nil if it is unknown.
+func ProducerFromContext(ctx context.Context) *string {
+	if producer, ok := ctx.Value(producerKey{}).(string); ok {
+		return &producer
+	}
+	return nil
+}
+
+type reviewerKey struct{}
+
+// ContextWithReviewer returns ctx of handling the command sent by a reviewer. Only reviewers may make
+// operations taking money from an account that didn't send the command, i.e. reverse transfers.
+func ContextWithReviewer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reviewerKey{}, true)
+}
+
+// ReviewerFromContext returns true if the command being handled was sent by a reviewer.
+func ReviewerFromContext(ctx context.Context) bool {
+	reviewer, _ := ctx.Value(reviewerKey{}).(bool)
+	return reviewer
+}
+
+type currencyKey struct{}
+
+// ContextWithCurrency returns ctx of handling the command with amounts in currency.
+func ContextWithCurrency(ctx context.Context, currency string) context.Context {
+	return context.WithValue(ctx, currencyKey{}, currency)
+}
+
+// CurrencyFromContext returns currency of amounts of the command being handled, empty if they are
+// in the currency of the accounts.
+func CurrencyFromContext(ctx context.Context) string {
+	currency, _ := ctx.Value(currencyKey{}).(string)
+	return currency
 }
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandIDFromContext(t *testing.T) {
+	require.Nil(t, CommandIDFromContext(context.Background()))
+
+	ctx := ContextWithCommandID(context.Background(), 42)
+	require.Equal(t, int64(42), *CommandIDFromContext(ctx))
+}
+
+func TestCommandCurrency(t *testing.T) {
+	amount := int64(10)
+	deposit := Command{Type: CommandTypeDeposit, FromUserID: 1, Amount: &amount}
+	require.NoError(t, deposit.Validate())
+
+	deposit.Currency = "USD"
+	require.NoError(t, deposit.Validate())
+	for _, currency := range []string{"usd", "XXX"} {
+		deposit.Currency = currency
+		require.ErrorIs(t, deposit.Validate(), ErrInvalidCommand, currency)
+	}
+
+	require.Empty(t, CurrencyFromContext(context.Background()))
+	require.Equal(t, "USD", CurrencyFromContext(ContextWithCurrency(context.Background(), "USD")))
+}
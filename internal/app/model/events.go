@@ -9,10 +9,49 @@ const (
 	EventTypeDeposit  EventType = "deposit"
 	EventTypeWithdraw EventType = "withdraw"
 	EventTypeTransfer EventType = "transfer"
+	EventTypeFreeze   EventType = "freeze"
+	EventTypeUnfreeze EventType = "unfreeze"
+	EventTypeClose    EventType = "close"
+	// EventTypeCreditLimit has new credit limit in Amount.
+	EventTypeCreditLimit EventType = "credit_limit"
+	// EventTypeOverdraft is sent when balance goes below zero, Amount is the negative balance.
+	EventTypeOverdraft EventType = "overdraft"
+	// EventTypeLimits is sent when limits of the account are changed.
+	EventTypeLimits EventType = "limits"
+	// EventTypeFeeRule is sent when new fee rule version is added, FromUserID is the fee account.
+	EventTypeFeeRule EventType = "fee_rule"
+	// EventTypeReversal undoes Amount of ReversedEventID. FromUserID and ToUserID are the same
+	// as in the reversed event, money goes in the opposite direction.
+	EventTypeReversal EventType = "reversal"
+	// EventTypeBatchTransfer moves money between users according to Legs, Amount is the total debited.
+	EventTypeBatchTransfer EventType = "batch_transfer"
+	// EventTypeScheduled is sent when command is saved to be executed later, ScheduledCommandID
+	// may be used to cancel it.
+	EventTypeScheduled         EventType = "scheduled"
+	EventTypeScheduleCancelled EventType = "schedule_cancelled"
+	// EventTypeMandateCreated has ToUserID and Amount of the recurring transfer. Transfers made
+	// by the mandate have MandateID as well.
+	EventTypeMandateCreated EventType = "mandate_created"
+	// EventTypeMandateFailed is sent when transfer of the mandate is rejected, ErrorCode is the reason.
+	EventTypeMandateFailed    EventType = "mandate_failed"
+	EventTypeMandateSuspended EventType = "mandate_suspended"
+	EventTypeMandateResumed   EventType = "mandate_resumed"
+	EventTypeMandateCancelled EventType = "mandate_cancelled"
+	// EventTypeBalanceMismatch is sent by reconciliation if balance of FromUserID differs from the sum
+	// of its events, Amount is the difference.
+	EventTypeBalanceMismatch EventType = "balance_mismatch"
+	// EventTypeReviewHeld is sent instead of withdrawal or transfer held by screening, ReviewID may be
+	// approved or rejected. Approved operation is sent after EventTypeReviewApproved.
+	EventTypeReviewHeld     EventType = "review_held"
+	EventTypeReviewApproved EventType = "review_approved"
+	EventTypeReviewRejected EventType = "review_rejected"
 )
 
 type Event struct {
-	ID int64 `pg:"id,pk" json:"id"`
+	ID       int64  `pg:"id,pk" json:"id"`
+	TenantID string `pg:"tenant_id,notnull" json:"tenant_id"`
+	// CommandID is the id of the command that caused the event, nil for events made by workers themselves, e.g. mandate runs.
+	CommandID *int64 `pg:"command_id" json:"command_id,omitempty"`
 
 	Type EventType `pg:"type" json:"type"`
 
@@ -20,6 +59,27 @@ type Event struct {
 	ToUserID   *int64 `pg:"to_user_id" json:"to_user_id"`
 
 	Amount *int64 `pg:"amount" json:"amount"`
+	// Currency is ISO 4217 code of Amount, Fee and Legs, for open event it is the currency of the account.
+	// It is set on events moving money and may be empty on others, then amounts are in the currency
+	// of the account. Events made before currencies don't have it, their amounts are in DefaultCurrency.
+	Currency string `pg:"currency" json:"currency,omitempty"`
+
+	// Fee is paid by FromUserID on top of Amount to FeeAccountID according to FeeRuleID.
+	Fee          *int64 `pg:"fee" json:"fee,omitempty"`
+	FeeRuleID    *int64 `pg:"fee_rule_id" json:"fee_rule_id,omitempty"`
+	FeeAccountID *int64 `pg:"fee_account_id" json:"fee_account_id,omitempty"`
+
+	ReversedEventID *int64 `pg:"reversed_event_id" json:"reversed_event_id,omitempty"`
+
+	Legs []*EventLeg `pg:"rel:has-many" json:"legs,omitempty"`
+
+	// ScheduledCommandID is set for scheduling events and for events made by executing the scheduled command.
+	ScheduledCommandID *int64 `pg:"scheduled_command_id" json:"scheduled_command_id,omitempty"`
+
+	MandateID *int64  `pg:"mandate_id" json:"mandate_id,omitempty"`
+	ErrorCode *string `pg:"error_code" json:"error_code,omitempty"`
+
+	ReviewID *int64 `pg:"review_id" json:"review_id,omitempty"`
 
 	CreatedTime time.Time `pg:"created_time,notnull" json:"created_time"`
 
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+var ErrUnknownCommand = errors.New("unknown command")
+var ErrInvalidCommand = errors.New("invalid command")
+
+// ErrInvalidSignature is returned if command is not signed by a known key of its producer.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrStaleMessage is returned if command was created too long ago or too far in the future.
+var ErrStaleMessage = errors.New("message is too old")
+
+// ErrReplayedMessage is returned if command with the same producer and message id was received before.
+var ErrReplayedMessage = errors.New("message was received before")
+
+// OperationFailed is published when command is rejected. Rejected commands are not retried.
+type OperationFailed struct {
+	CommandID int64   `json:"command_id"`
+	Command   Command `json:"command"`
+
+	// Error is the code of the error, e.g. "negative_balance".
+	Error   string `json:"error"`
+	Message string `json:"message"`
+
+	// LimitUsage is set if command was rejected because of ErrLimitExceeded.
+	LimitUsage *LimitUsage `json:"limit_usage,omitempty"`
+
+	CreatedTime time.Time `json:"created_time"`
+}
+
+// ErrorCode returns code of business error, ok is false if err is not caused by command itself
+// (e.g. database is not available), so the command may succeed if retried.
+func ErrorCode(err error) (code string, ok bool) {
+	codes := []struct {
+		err  error
+		code string
+	}{
+		{ErrUserNotFound, "user_not_found"},
+		{ErrAlreadyExists, "already_exists"},
+		{ErrNegativeAmount, "negative_amount"},
+		{ErrNegativeBalance, "negative_balance"},
+		{ErrAccountFrozen, "account_frozen"},
+		{ErrAccountClosed, "account_closed"},
+		{ErrWrongAccountStatus, "wrong_account_status"},
+		{ErrNonZeroBalance, "non_zero_balance"},
+		{ErrLimitExceeded, "limit_exceeded"},
+		{ErrUnknownCommand, "unknown_command"},
+		{ErrInvalidCommand, "invalid_command"},
+		{ErrInvalidFeeRule, "invalid_fee_rule"},
+		{ErrSameAccount, "same_account"},
+		{ErrEventNotFound, "event_not_found"},
+		{ErrNotReversible, "not_reversible"},
+		{ErrReversalExceedsAmount, "reversal_exceeds_amount"},
+		{ErrScheduledNotFound, "scheduled_not_found"},
+		{ErrScheduledNotPending, "scheduled_not_pending"},
+		{ErrFeesDisabled, "fees_disabled"},
+		{ErrInvalidSchedule, "invalid_schedule"},
+		{ErrMandateNotFound, "mandate_not_found"},
+		{ErrWrongMandateStatus, "wrong_mandate_status"},
+		{ErrInvalidSignature, "invalid_signature"},
+		{ErrStaleMessage, "stale_message"},
+		{ErrReplayedMessage, "replayed_message"},
+		{ErrForbidden, "forbidden"},
+		{ErrThrottled, "throttled"},
+		{ErrScreeningRejected, "screening_rejected"},
+		{ErrReviewNotFound, "review_not_found"},
+		{ErrReviewNotPending, "review_not_pending"},
+		{ErrInvalidTenant, "invalid_tenant"},
+		{money.ErrOverflow, "amount_overflow"},
+		{money.ErrCurrencyMismatch, "currency_mismatch"},
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code, true
+		}
+	}
+	return "", false
+}
+
+// NewOperationFailed returns failure event for the command rejected with err.
+func NewOperationFailed(command Command, err error) OperationFailed {
+	code, _ := ErrorCode(err)
+	failed := OperationFailed{
+		CommandID:   command.ID,
+		Command:     command,
+		Error:       code,
+		Message:     err.Error(),
+		CreatedTime: time.Now(),
+	}
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		failed.LimitUsage = &limitErr.Usage
+	}
+	return failed
+}
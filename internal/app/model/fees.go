@@ -0,0 +1,145 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+var ErrInvalidFeeRule = errors.New("invalid fee rule")
+var ErrFeesDisabled = errors.New("fee account is not configured")
+
+type FeeKind string
+
+const (
+	// FeeKindNone disables fee for the operation.
+	FeeKindNone FeeKind = "none"
+	// FeeKindFlat is Flat for every operation.
+	FeeKindFlat FeeKind = "flat"
+	// FeeKindPercent is PercentBP of amount bounded by MinFee and MaxFee.
+	FeeKindPercent FeeKind = "percent"
+	// FeeKindTiered is Flat + PercentBP of the first tier the amount fits in, bounded by MinFee and MaxFee.
+	FeeKindTiered FeeKind = "tiered"
+)
+
+// FeeEntry is a fee credited to the fee account. Entries are appended without locking the fee account,
+// they are added to its balance when the account itself is locked.
+type FeeEntry struct {
+	ID          int64     `pg:"id,pk"`
+	TenantID    string    `pg:"tenant_id,notnull"`
+	AccountID   int64     `pg:"account_id,notnull"`
+	EventID     int64     `pg:"event_id,notnull"`
+	Amount      int64     `pg:"amount,notnull"`
+	CreatedTime time.Time `pg:"created_time,notnull"`
+}
+
+// FeeRule is a version of fee schedule for an operation, the latest version is applied.
+// Rules are never changed, so events reference the rule that was used for them.
+type FeeRule struct {
+	ID        int64     `pg:"id,pk" json:"id"`
+	TenantID  string    `pg:"tenant_id,notnull" json:"-"`
+	Operation EventType `pg:"operation,notnull" json:"operation"`
+	Kind      FeeKind   `pg:"kind,notnull" json:"kind"`
+
+	Flat int64 `pg:"flat,notnull,use_zero" json:"flat"`
+	// PercentBP is percent in basis points, 150 is 1.5%.
+	PercentBP int64 `pg:"percent_bp,notnull,use_zero" json:"percent_bp"`
+	MinFee    int64 `pg:"min_fee,notnull,use_zero" json:"min_fee"`
+	// MaxFee of 0 means no upper bound.
+	MaxFee int64     `pg:"max_fee,notnull,use_zero" json:"max_fee"`
+	Tiers  []FeeTier `pg:"tiers,type:jsonb" json:"tiers,omitempty"`
+
+	CreatedTime time.Time `pg:"created_time,notnull" json:"created_time"`
+}
+
+// FeeTier applies to amounts up to UpTo inclusive, UpTo of 0 means any amount.
+type FeeTier struct {
+	UpTo      int64 `json:"up_to"`
+	Flat      int64 `json:"flat"`
+	PercentBP int64 `json:"percent_bp"`
+}
+
+func (r FeeRule) Validate() error {
+	if r.Operation != EventTypeWithdraw && r.Operation != EventTypeTransfer {
+		return fmt.Errorf("%w: fee can't be set for %q", ErrInvalidFeeRule, r.Operation)
+	}
+	if r.Flat < 0 || r.PercentBP < 0 || r.MinFee < 0 || r.MaxFee < 0 {
+		return fmt.Errorf("%w: negative value", ErrInvalidFeeRule)
+	}
+	if r.MaxFee != 0 && r.MaxFee < r.MinFee {
+		return fmt.Errorf("%w: max fee is less than min fee", ErrInvalidFeeRule)
+	}
+	switch r.Kind {
+	case FeeKindNone, FeeKindFlat, FeeKindPercent:
+	case FeeKindTiered:
+		if len(r.Tiers) == 0 {
+			return fmt.Errorf("%w: tiers are required", ErrInvalidFeeRule)
+		}
+		for i, tier := range r.Tiers {
+			if tier.Flat < 0 || tier.PercentBP < 0 {
+				return fmt.Errorf("%w: negative value in tier %d", ErrInvalidFeeRule, i)
+			}
+			if i > 0 && tier.UpTo != 0 && tier.UpTo <= r.Tiers[i-1].UpTo {
+				return fmt.Errorf("%w: tiers must be sorted by up_to", ErrInvalidFeeRule)
+			}
+			if tier.UpTo == 0 && i != len(r.Tiers)-1 {
+				return fmt.Errorf("%w: only the last tier may have no up_to", ErrInvalidFeeRule)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidFeeRule, r.Kind)
+	}
+	return nil
+}
+
+// Calculate returns fee for the operation amount. Fee that doesn't fit int64 is MaxFee if it is set,
+// otherwise money.ErrOverflow is returned.
+func (r FeeRule) Calculate(amount int64) (int64, error) {
+	var fee int64
+	var err error
+	switch r.Kind {
+	case FeeKindFlat:
+		return r.Flat, nil
+	case FeeKindPercent:
+		fee, err = percent(amount, r.PercentBP)
+	case FeeKindTiered:
+		for _, tier := range r.Tiers {
+			if tier.UpTo == 0 || amount <= tier.UpTo {
+				if fee, err = percent(amount, tier.PercentBP); err == nil {
+					fee, err = money.Add(tier.Flat, fee)
+				}
+				break
+			}
+		}
+	default:
+		return 0, nil
+	}
+	if errors.Is(err, money.ErrOverflow) && r.MaxFee != 0 {
+		return r.MaxFee, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if fee < r.MinFee {
+		fee = r.MinFee
+	}
+	if r.MaxFee != 0 && fee > r.MaxFee {
+		fee = r.MaxFee
+	}
+	return fee, nil
+}
+
+// percent returns amount*bp/10000 rounded half up, amount is not negative.
+func percent(amount, bp int64) (int64, error) {
+	fee := new(big.Int).Mul(big.NewInt(amount), big.NewInt(bp))
+	fee.Add(fee, big.NewInt(5000))
+	fee.Quo(fee, big.NewInt(10000))
+	if !fee.IsInt64() {
+		return 0, fmt.Errorf("%w: %d bp of %d", money.ErrOverflow, bp, amount)
+	}
+	return fee.Int64(), nil
+}
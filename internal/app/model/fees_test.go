@@ -0,0 +1,67 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+func TestFeeRuleCalculate(t *testing.T) {
+	cases := []struct {
+		name   string
+		rule   FeeRule
+		amount int64
+		fee    int64
+	}{
+		{"none", FeeRule{Kind: FeeKindNone, Flat: 10}, 1000, 0},
+		{"flat", FeeRule{Kind: FeeKindFlat, Flat: 10}, 1000, 10},
+		{"percent", FeeRule{Kind: FeeKindPercent, PercentBP: 150}, 1000, 15},
+		{"percent rounds half up", FeeRule{Kind: FeeKindPercent, PercentBP: 150}, 1010, 15},
+		{"percent min", FeeRule{Kind: FeeKindPercent, PercentBP: 100, MinFee: 5}, 100, 5},
+		{"percent max", FeeRule{Kind: FeeKindPercent, PercentBP: 100, MaxFee: 50}, 100000, 50},
+		{"percent of huge amount", FeeRule{Kind: FeeKindPercent, PercentBP: 10000}, 1 << 62, 1 << 62},
+		{"overflow is capped by max", FeeRule{Kind: FeeKindPercent, PercentBP: 30000, MaxFee: 100}, 1 << 62, 100},
+		{"first tier", tieredRule(), 100, 1},
+		{"tier bound is inclusive", tieredRule(), 1000, 10},
+		{"second tier", tieredRule(), 1001, 25},
+		{"last tier", tieredRule(), 100000, 150},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fee, err := c.rule.Calculate(c.amount)
+			require.NoError(t, err)
+			require.Equal(t, c.fee, fee)
+		})
+	}
+
+	_, err := FeeRule{Kind: FeeKindPercent, PercentBP: 30000}.Calculate(1 << 62)
+	require.ErrorIs(t, err, money.ErrOverflow)
+	_, err = FeeRule{Kind: FeeKindTiered, Tiers: []FeeTier{{Flat: 1 << 62, PercentBP: 10000}}}.Calculate(1 << 62)
+	require.ErrorIs(t, err, money.ErrOverflow)
+}
+
+func TestFeeRuleValidate(t *testing.T) {
+	rule := tieredRule()
+	require.NoError(t, rule.Validate())
+
+	rule.Tiers[0], rule.Tiers[1] = rule.Tiers[1], rule.Tiers[0]
+	require.ErrorIs(t, rule.Validate(), ErrInvalidFeeRule)
+
+	require.ErrorIs(t, FeeRule{Operation: EventTypeDeposit, Kind: FeeKindFlat}.Validate(), ErrInvalidFeeRule)
+	require.ErrorIs(t, FeeRule{Operation: EventTypeWithdraw, Kind: FeeKindFlat, Flat: -1}.Validate(), ErrInvalidFeeRule)
+	require.ErrorIs(t, FeeRule{Operation: EventTypeWithdraw, Kind: FeeKindTiered}.Validate(), ErrInvalidFeeRule)
+}
+
+func tieredRule() FeeRule {
+	return FeeRule{
+		Operation: EventTypeTransfer,
+		Kind:      FeeKindTiered,
+		Tiers: []FeeTier{
+			{UpTo: 1000, PercentBP: 100},
+			{UpTo: 10000, Flat: 5, PercentBP: 200},
+			{Flat: 50, PercentBP: 10},
+		},
+	}
+}
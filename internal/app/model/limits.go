@@ -0,0 +1,100 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// ErrThrottled is returned if command was not handled because its account or producer sends commands too often.
+var ErrThrottled = errors.New("too many commands")
+
+// RateLimit is a token bucket: up to Burst commands at once and Rate commands per second on average.
+// Zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limits restrict outgoing operations (withdrawals and transfers) of an account, zero value means no limit.
+type Limits struct {
+	// MaxAmount is the max amount of a single operation.
+	MaxAmount int64 `pg:"max_amount,notnull,use_zero" json:"max_amount"`
+	// MaxDailyOutgoing is the max total amount sent in the last 24 hours.
+	MaxDailyOutgoing int64 `pg:"max_daily_outgoing,notnull,use_zero" json:"max_daily_outgoing"`
+	// MaxMonthlyOutgoing is the max total amount sent in the last 30 days.
+	MaxMonthlyOutgoing int64 `pg:"max_monthly_outgoing,notnull,use_zero" json:"max_monthly_outgoing"`
+	// MaxHourlyOperations is the max number of operations in the last hour.
+	MaxHourlyOperations int64 `pg:"max_hourly_operations,notnull,use_zero" json:"max_hourly_operations"`
+}
+
+// AccountLimits are limits set for one account, they are applied together with global limits.
+type AccountLimits struct {
+	TenantID string `pg:"tenant_id,pk"`
+	UserID   int64  `pg:"user_id,pk"`
+	Limits
+}
+
+// OutgoingUsage is what account already sent in limit windows.
+type OutgoingUsage struct {
+	Daily            int64
+	Monthly          int64
+	HourlyOperations int64
+}
+
+type LimitName string
+
+const (
+	LimitMaxAmount           LimitName = "max_amount"
+	LimitMaxDailyOutgoing    LimitName = "max_daily_outgoing"
+	LimitMaxMonthlyOutgoing  LimitName = "max_monthly_outgoing"
+	LimitMaxHourlyOperations LimitName = "max_hourly_operations"
+)
+
+// LimitUsage describes the limit that rejected operation.
+type LimitUsage struct {
+	Limit LimitName `json:"limit"`
+	// Max is the limit value.
+	Max int64 `json:"max"`
+	// Used is how much of the limit was used before the operation.
+	Used int64 `json:"used"`
+	// Requested is how much the operation needed.
+	Requested int64 `json:"requested"`
+}
+
+// LimitExceededError is ErrLimitExceeded with details about the limit.
+type LimitExceededError struct {
+	Usage LimitUsage
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s: %s is %d, used %d, requested %d",
+		ErrLimitExceeded, e.Usage.Limit, e.Usage.Max, e.Usage.Used, e.Usage.Requested)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Check returns *LimitExceededError if operation with amount exceeds one of the limits.
+func (l Limits) Check(usage OutgoingUsage, amount int64) error {
+	checks := []LimitUsage{
+		{Limit: LimitMaxAmount, Max: l.MaxAmount, Used: 0, Requested: amount},
+		{Limit: LimitMaxDailyOutgoing, Max: l.MaxDailyOutgoing, Used: usage.Daily, Requested: amount},
+		{Limit: LimitMaxMonthlyOutgoing, Max: l.MaxMonthlyOutgoing, Used: usage.Monthly, Requested: amount},
+		{Limit: LimitMaxHourlyOperations, Max: l.MaxHourlyOperations, Used: usage.HourlyOperations, Requested: 1},
+	}
+	for _, check := range checks {
+		if check.Max == 0 {
+			continue
+		}
+		// usage that doesn't fit into int64 is certainly over the limit
+		if total, err := money.Add(check.Used, check.Requested); err != nil || total > check.Max {
+			return &LimitExceededError{Usage: check}
+		}
+	}
+	return nil
+}
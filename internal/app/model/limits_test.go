@@ -0,0 +1,37 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{MaxAmount: 100, MaxDailyOutgoing: 150, MaxMonthlyOutgoing: 1000, MaxHourlyOperations: 3}
+
+	require.NoError(t, Limits{}.Check(OutgoingUsage{Daily: 1e9, Monthly: 1e9, HourlyOperations: 1e9}, 1e9))
+	require.NoError(t, limits.Check(OutgoingUsage{Daily: 50, Monthly: 900, HourlyOperations: 2}, 100))
+
+	cases := []struct {
+		usage  OutgoingUsage
+		amount int64
+		limit  LimitName
+	}{
+		{OutgoingUsage{}, 101, LimitMaxAmount},
+		{OutgoingUsage{Daily: 51}, 100, LimitMaxDailyOutgoing},
+		{OutgoingUsage{Monthly: 901}, 100, LimitMaxMonthlyOutgoing},
+		{OutgoingUsage{HourlyOperations: 3}, 1, LimitMaxHourlyOperations},
+		{OutgoingUsage{Daily: math.MaxInt64}, 1, LimitMaxDailyOutgoing},
+	}
+	for _, c := range cases {
+		err := limits.Check(c.usage, c.amount)
+		require.True(t, errors.Is(err, ErrLimitExceeded))
+
+		failed := NewOperationFailed(Command{ID: 7}, err)
+		require.Equal(t, "limit_exceeded", failed.Error)
+		require.EqualValues(t, 7, failed.CommandID)
+		require.Equal(t, c.limit, failed.LimitUsage.Limit)
+	}
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrMandateNotFound = errors.New("mandate not found")
+var ErrWrongMandateStatus = errors.New("wrong mandate status")
+
+type MandateStatus string
+
+const (
+	MandateStatusActive MandateStatus = "active"
+	// MandateStatusSuspended mandate failed more than MaxRetries times in a row and waits to be resumed.
+	MandateStatusSuspended MandateStatus = "suspended"
+	MandateStatusCancelled MandateStatus = "cancelled"
+	// MandateStatusFinished mandate has no runs left before EndTime.
+	MandateStatusFinished MandateStatus = "finished"
+)
+
+// MandateTerms define when recurring transfer of a mandate is made.
+type MandateTerms struct {
+	// Schedule is a cron expression, see ParseSchedule.
+	Schedule string     `pg:"schedule,notnull" json:"schedule"`
+	EndTime  *time.Time `pg:"end_time" json:"end_time,omitempty"`
+	// MaxRetries is how many times in a row transfer may fail because of insufficient funds
+	// before the mandate is suspended. Other errors suspend it at once.
+	MaxRetries int `pg:"max_retries,notnull,use_zero" json:"max_retries"`
+}
+
+func (t MandateTerms) Validate() error {
+	if _, err := ParseSchedule(t.Schedule); err != nil {
+		return err
+	}
+	if t.MaxRetries < 0 {
+		return fmt.Errorf("%w: max_retries must not be negative", ErrInvalidCommand)
+	}
+	return nil
+}
+
+// Mandate is a recurring transfer of Amount from FromUserID to ToUserID executed by workers.
+type Mandate struct {
+	ID         int64  `pg:"id,pk" json:"id"`
+	TenantID   string `pg:"tenant_id,notnull" json:"tenant_id"`
+	FromUserID int64  `pg:"from_user_id,notnull" json:"from_user_id"`
+	ToUserID   int64  `pg:"to_user_id,notnull" json:"to_user_id"`
+	Amount     int64  `pg:"amount,notnull" json:"amount"`
+	MandateTerms
+
+	Status      MandateStatus `pg:"status,notnull" json:"status"`
+	NextRunTime time.Time     `pg:"next_run_time,notnull" json:"next_run_time"`
+	// Failures is the number of failed runs since the last successful one.
+	Failures  int     `pg:"failures,notnull,use_zero" json:"failures"`
+	LastError *string `pg:"last_error" json:"last_error,omitempty"`
+	// Producer is the client id of the sender of create_mandate, nil if it is unknown.
+	Producer *string `pg:"producer" json:"producer,omitempty"`
+
+	CreatedTime time.Time `pg:"created_time,notnull" json:"created_time"`
+}
+
+// NextRun returns time of the first run after t, finished is true if there are no runs left.
+func (m Mandate) NextRun(t time.Time) (next time.Time, finished bool) {
+	schedule, err := ParseSchedule(m.Schedule)
+	if err != nil {
+		return time.Time{}, true
+	}
+	next = schedule.Next(t)
+	return next, next.IsZero() || (m.EndTime != nil && next.After(*m.EndTime))
+}
@@ -2,14 +2,77 @@ package model
 
 import (
 	"errors"
+	"math"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+type AccountStatus string
+
+const (
+	// AccountStatusActive account may send and receive money.
+	AccountStatusActive AccountStatus = "active"
+	// AccountStatusFrozen account may receive money but not send it.
+	AccountStatusFrozen AccountStatus = "frozen"
+	// AccountStatusClosed account can't be used anymore.
+	AccountStatusClosed AccountStatus = "closed"
 )
 
+// DefaultCurrency is the currency of accounts opened without one, all accounts opened before currencies have it.
+var DefaultCurrency = money.RUB
+
+// Balance and all amounts in commands and events are int64 minor units of the account currency
+// (kopecks for RUB), arithmetic on them is done with money.Add and money.Sub to detect overflow.
+// Currency is chosen when account is opened and never changes.
 type Balance struct {
-	UserID  int64 `pg:"id,pk"`
-	Balance int64 `pg:"balance,notnull,use_zero"`
+	TenantID string        `pg:"tenant_id,pk" json:"tenant_id"`
+	UserID   int64         `pg:"id,pk" json:"user_id"`
+	Balance  int64         `pg:"balance,notnull,use_zero" json:"balance"`
+	Currency string        `pg:"currency,notnull" json:"currency"`
+	Status   AccountStatus `pg:"status,notnull" json:"status"`
+	// CreditLimit is how far below zero the balance may go.
+	CreditLimit int64 `pg:"credit_limit,notnull,use_zero" json:"credit_limit"`
+}
+
+// Available returns how much money may be withdrawn from the account including credit.
+func (b Balance) Available() int64 {
+	available, err := money.Add(b.Balance, b.CreditLimit)
+	if err != nil {
+		// credit limit is never negative, so only huge credit on positive balance gets here
+		return math.MaxInt64
+	}
+	return available
+}
+
+// CanSend returns error if money can't be withdrawn or transferred from the account.
+func (b Balance) CanSend() error {
+	switch b.Status {
+	case AccountStatusActive:
+		return nil
+	case AccountStatusFrozen:
+		return ErrAccountFrozen
+	default:
+		return ErrAccountClosed
+	}
+}
+
+// CanReceive returns error if money can't be deposited or transferred to the account.
+func (b Balance) CanReceive() error {
+	if b.Status == AccountStatusClosed {
+		return ErrAccountClosed
+	}
+	return nil
 }
 
 var ErrUserNotFound = errors.New("user not found")
 var ErrAlreadyExists = errors.New("user already exists")
 var ErrNegativeAmount = errors.New("negative amount")
 var ErrNegativeBalance = errors.New("negative balance")
+var ErrAccountFrozen = errors.New("account is frozen")
+var ErrAccountClosed = errors.New("account is closed")
+var ErrWrongAccountStatus = errors.New("operation is not allowed in current account status")
+var ErrNonZeroBalance = errors.New("account balance is not zero")
+var ErrSameAccount = errors.New("operation with the same account")
+var ErrEventNotFound = errors.New("event not found")
+var ErrNotReversible = errors.New("event can't be reversed")
+var ErrReversalExceedsAmount = errors.New("reversal exceeds amount of the event")
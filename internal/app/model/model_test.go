@@ -0,0 +1,23 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+func TestBalanceAvailable(t *testing.T) {
+	require.EqualValues(t, 150, Balance{Balance: -50, CreditLimit: 200}.Available())
+	require.EqualValues(t, int64(math.MaxInt64), Balance{Balance: 10, CreditLimit: math.MaxInt64}.Available())
+}
+
+func TestErrorCodeOfOverflow(t *testing.T) {
+	_, err := money.Add(math.MaxInt64, 1)
+	code, ok := ErrorCode(fmt.Errorf("balance of user 1: %w", err))
+	require.True(t, ok)
+	require.Equal(t, "amount_overflow", code)
+}
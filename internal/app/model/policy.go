@@ -0,0 +1,127 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrForbidden is returned if no policy allows producer to send the command.
+var ErrForbidden = errors.New("forbidden")
+
+// AnyCommand in Policy.Commands allows all command types.
+const AnyCommand CommandType = "*"
+
+// AccountRange is an inclusive range of user ids.
+type AccountRange struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+func (r AccountRange) Contains(userID int64) bool {
+	return userID >= r.Min && userID <= r.Max
+}
+
+// Policy allows Producer to send commands of Commands types. If Accounts are set, every account
+// the command touches (sender, receiver and legs of batch transfer) must be in one of the ranges,
+// so reverse is not allowed: the accounts it touches are known only from the reversed event.
+// If Tenants are set, the command must be of one of them.
+type Policy struct {
+	Producer string         `json:"producer"`
+	Commands []CommandType  `json:"commands"`
+	Accounts []AccountRange `json:"accounts,omitempty"`
+	Tenants  []string       `json:"tenants,omitempty"`
+}
+
+// Policies are allowing rules, command is allowed if at least one policy of its producer allows it.
+type Policies []Policy
+
+// LoadPolicies reads Policies from JSON file.
+func LoadPolicies(path string) (Policies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies Policies
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("error on reading policies %s: %w", path, err)
+	}
+	for i, p := range policies {
+		if len(p.Commands) == 0 {
+			return nil, fmt.Errorf("policy %d of %q in %s has no commands", i, p.Producer, path)
+		}
+		for _, r := range p.Accounts {
+			if r.Min > r.Max {
+				return nil, fmt.Errorf("policy %d of %q in %s has empty account range %d-%d", i, p.Producer, path, r.Min, r.Max)
+			}
+		}
+	}
+	return policies, nil
+}
+
+// Authorize returns ErrForbidden if producer is not allowed to send the command.
+func (p Policies) Authorize(producer string, command Command) error {
+	for _, policy := range p {
+		if policy.Producer == producer && policy.allowsType(command.Type) && policy.allowsTenant(command.Tenant()) &&
+			policy.allowsAccounts(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q can't send %s for account %d of %q", ErrForbidden, producer, command.Type, command.FromUserID, command.Tenant())
+}
+
+func (p Policy) allowsTenant(tenantID string) bool {
+	if len(p.Tenants) == 0 {
+		return true
+	}
+	for _, t := range p.Tenants {
+		if t == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsType(commandType CommandType) bool {
+	for _, t := range p.Commands {
+		if t == commandType || t == AnyCommand {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsAccounts(command Command) bool {
+	if len(p.Accounts) == 0 {
+		return true
+	}
+	if command.Type == CommandTypeReverse {
+		// reversal of transfer debits its recipient, which is not in the command
+		return false
+	}
+	accounts := []int64{command.FromUserID}
+	if command.ToUserID != nil {
+		accounts = append(accounts, *command.ToUserID)
+	}
+	for _, leg := range command.Legs {
+		if leg != nil {
+			accounts = append(accounts, leg.UserID)
+		}
+	}
+	for _, userID := range accounts {
+		if !p.allowsAccount(userID) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Policy) allowsAccount(userID int64) bool {
+	for _, r := range p.Accounts {
+		if r.Contains(userID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoliciesAuthorize(t *testing.T) {
+	policies := Policies{
+		{Producer: "shop", Commands: []CommandType{CommandTypeWithdraw, CommandTypeTransfer, CommandTypeBatchTransfer, CommandTypeReverse}, Accounts: []AccountRange{{Min: 100, Max: 199}}},
+		{Producer: "gateway", Commands: []CommandType{CommandTypeDeposit}},
+		{Producer: "admin", Commands: []CommandType{AnyCommand}},
+		{Producer: "brand-shop", Commands: []CommandType{AnyCommand}, Tenants: []string{"brand"}},
+	}
+	amount, inside, outside := int64(10), int64(150), int64(200)
+
+	allowed := []struct {
+		producer string
+		command  Command
+	}{
+		{"shop", Command{Type: CommandTypeWithdraw, FromUserID: 100, Amount: &amount}},
+		{"shop", Command{Type: CommandTypeBatchTransfer, FromUserID: 100, Legs: []*EventLeg{{UserID: 100, Amount: -10}, {UserID: 150, Amount: 10}}}},
+		{"shop", Command{Type: CommandTypeTransfer, FromUserID: 199, ToUserID: &inside, Amount: &amount}},
+		{"gateway", Command{Type: CommandTypeDeposit, FromUserID: 1, Amount: &amount}},
+		{"admin", Command{Type: CommandTypeSetFeeRule}},
+		{"admin", Command{TenantID: "brand", Type: CommandTypeSetFeeRule}},
+		{"brand-shop", Command{TenantID: "brand", Type: CommandTypeDeposit, FromUserID: 1, Amount: &amount}},
+	}
+	for _, c := range allowed {
+		require.NoError(t, policies.Authorize(c.producer, c.command), c.producer, c.command.Type)
+	}
+
+	forbidden := []struct {
+		producer string
+		command  Command
+	}{
+		{"shop", Command{Type: CommandTypeDeposit, FromUserID: 100, Amount: &amount}},
+		{"shop", Command{Type: CommandTypeBatchTransfer, FromUserID: 100, Legs: []*EventLeg{{UserID: 100, Amount: -10}, {UserID: 200, Amount: 10}}}},
+		// accounts of the reversed event are not known
+		{"shop", Command{Type: CommandTypeReverse, FromUserID: 100, EventID: &amount}},
+		{"shop", Command{Type: CommandTypeWithdraw, FromUserID: 99, Amount: &amount}},
+		{"shop", Command{Type: CommandTypeTransfer, FromUserID: 150, ToUserID: &outside, Amount: &amount}},
+		{"gateway", Command{Type: CommandTypeWithdraw, FromUserID: 1, Amount: &amount}},
+		{"", Command{Type: CommandTypeDeposit, FromUserID: 1, Amount: &amount}},
+		{"brand-shop", Command{Type: CommandTypeDeposit, FromUserID: 1, Amount: &amount}},
+		{"brand-shop", Command{TenantID: "other", Type: CommandTypeDeposit, FromUserID: 1, Amount: &amount}},
+	}
+	for _, c := range forbidden {
+		err := policies.Authorize(c.producer, c.command)
+		require.ErrorIs(t, err, ErrForbidden, c.producer, c.command.Type)
+		code, ok := ErrorCode(err)
+		require.True(t, ok)
+		require.Equal(t, "forbidden", code)
+	}
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// ReceivedMessage is a command remembered until ExpiresTime to reject its copies.
+type ReceivedMessage struct {
+	TenantID  string `pg:"tenant_id,pk"`
+	Producer  string `pg:"producer,pk"`
+	MessageID string `pg:"message_id,pk"`
+	// Subject and Sequence identify NATS message, redelivery of the same message is not a copy.
+	Subject     string    `pg:"subject,notnull"`
+	Sequence    int64     `pg:"sequence,notnull,use_zero"`
+	ExpiresTime time.Time `pg:"expires_time,notnull"`
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Discrepancy is an account which balance differs from the sum of its events.
+type Discrepancy struct {
+	TenantID string `json:"tenant_id"`
+	UserID   int64  `json:"user_id"`
+	Balance  int64  `json:"balance"`
+	Expected int64  `json:"expected"`
+}
+
+// Difference is positive if account has more money than its events explain.
+func (d Discrepancy) Difference() int64 {
+	return d.Balance - d.Expected
+}
+
+// ReportedDiscrepancy is a discrepancy balance_mismatch was sent for. It is not sent again
+// by the following reconciliations until the difference changes.
+type ReportedDiscrepancy struct {
+	TenantID     string    `pg:"tenant_id,pk"`
+	UserID       int64     `pg:"user_id,pk"`
+	Difference   int64     `pg:"difference,use_zero"`
+	ReportedTime time.Time `pg:"reported_time,notnull"`
+}
+
+type ReconciliationReport struct {
+	StartedTime   time.Time     `json:"started_time"`
+	FinishedTime  time.Time     `json:"finished_time"`
+	Accounts      int           `json:"accounts"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// WriteFile saves report as indented JSON.
+func (r ReconciliationReport) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644) // nolint:gosec
+}
+
+// FileName returns name the report is saved with.
+func (r ReconciliationReport) FileName() string {
+	return fmt.Sprintf("reconciliation-%s.json", r.StartedTime.UTC().Format("20060102T150405Z"))
+}
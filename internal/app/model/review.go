@@ -0,0 +1,33 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrReviewNotFound = errors.New("review not found")
+var ErrReviewNotPending = errors.New("review is already decided")
+
+type ReviewStatus string
+
+const (
+	ReviewStatusPending  ReviewStatus = "pending"
+	ReviewStatusApproved ReviewStatus = "approved"
+	ReviewStatusRejected ReviewStatus = "rejected"
+)
+
+// Review is an operation held by screening until it is approved or rejected with a command.
+// Approved Command is made without screening. Producer of the held command can't decide the review.
+type Review struct {
+	ID       int64        `pg:"id,pk" json:"id"`
+	TenantID string       `pg:"tenant_id,notnull" json:"tenant_id"`
+	UserID   int64        `pg:"user_id,notnull" json:"user_id"`
+	Command  Command      `pg:"command,type:jsonb,notnull" json:"command"`
+	Rule     string       `pg:"rule,notnull" json:"rule"`
+	Reason   string       `pg:"reason,notnull" json:"reason"`
+	Status   ReviewStatus `pg:"status,notnull" json:"status"`
+	Producer *string      `pg:"producer" json:"producer,omitempty"`
+
+	CreatedTime time.Time  `pg:"created_time,notnull" json:"created_time"`
+	DecidedTime *time.Time `pg:"decided_time" json:"decided_time,omitempty"`
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+// Balance rules are shared by the service, which rejects operations breaking them, and by replay,
+// which records them as violations of the history and applies the event anyway. Check* methods
+// return business errors, After* methods only compute the new balance.
+
+// CheckDebit returns error if debit can't be sent from the account.
+func (b Balance) CheckDebit(debit int64) error {
+	if err := b.CanSend(); err != nil {
+		return err
+	}
+	if debit > b.Available() {
+		return ErrNegativeBalance
+	}
+	return nil
+}
+
+// CheckCredit returns error if the account can't receive money.
+func (b Balance) CheckCredit() error {
+	return b.CanReceive()
+}
+
+// CheckReverseDebit returns error if amount received by the account can't be taken back.
+// Unlike debit it is allowed from frozen account.
+func (b Balance) CheckReverseDebit(amount int64) error {
+	if b.Status == AccountStatusClosed {
+		return ErrAccountClosed
+	}
+	if amount > b.Available() {
+		return ErrNegativeBalance
+	}
+	return nil
+}
+
+// CheckLegs returns error if the account can't be debited and credited by batch transfer legs,
+// only its net debit must be available.
+func (b Balance) CheckLegs(debit, credit int64) error {
+	if credit > 0 {
+		if err := b.CanReceive(); err != nil {
+			return err
+		}
+	}
+	if debit == 0 {
+		return nil
+	}
+	if err := b.CanSend(); err != nil {
+		return err
+	}
+	if debit-credit > b.Available() {
+		return ErrNegativeBalance
+	}
+	return nil
+}
+
+// CheckCreditLimit returns error if creditLimit can't be set, it can't be lower than the current debt.
+func (b Balance) CheckCreditLimit(creditLimit int64) error {
+	if b.Status == AccountStatusClosed {
+		return ErrAccountClosed
+	}
+	// credit limit is not negative, so the sum overflows only above zero
+	if available, err := money.Add(b.Balance, creditLimit); err == nil && available < 0 {
+		return ErrNegativeBalance
+	}
+	return nil
+}
+
+// AfterCredit returns balance after amount is received.
+func (b Balance) AfterCredit(amount int64) (int64, error) {
+	balance, err := money.Add(b.Balance, amount)
+	if err != nil {
+		return 0, fmt.Errorf("balance of user %d: %w", b.UserID, err)
+	}
+	return balance, nil
+}
+
+// AfterDebit returns balance after amount is sent.
+func (b Balance) AfterDebit(amount int64) (int64, error) {
+	balance, err := money.Sub(b.Balance, amount)
+	if err != nil {
+		return 0, fmt.Errorf("balance of user %d: %w", b.UserID, err)
+	}
+	return balance, nil
+}
+
+// CheckCurrency returns money.ErrCurrencyMismatch if accounts of the operation have different currencies
+// or currency of the command amounts is set and differs from them.
+func CheckCurrency(currency string, balances ...Balance) error {
+	for _, b := range balances {
+		if currency == "" {
+			currency = b.Currency
+		}
+		if b.Currency != currency {
+			return fmt.Errorf("%w: account %d is in %s, not %s", money.ErrCurrencyMismatch, b.UserID, b.Currency, currency)
+		}
+	}
+	return nil
+}
+
+// AmountCurrency returns currency of amounts of the event moving money, events made before currencies
+// are in DefaultCurrency.
+func (e Event) AmountCurrency() string {
+	if e.Currency == "" {
+		return DefaultCurrency.Code
+	}
+	return e.Currency
+}
+
+// DebitWithFee returns how much the payer is debited: fee is paid on top of amount.
+func DebitWithFee(amount, fee int64) (int64, error) {
+	return money.Add(amount, fee)
+}
+
+// FeeCredit returns the account fee of the event is credited to and the fee, fee is 0 if there is none.
+// defaultFeeAccountID is used for events made before fee account was recorded on them.
+func (e Event) FeeCredit(defaultFeeAccountID int64) (accountID, fee int64) {
+	if e.Fee == nil {
+		return 0, 0
+	}
+	accountID = defaultFeeAccountID
+	if e.FeeAccountID != nil {
+		accountID = *e.FeeAccountID
+	}
+	return accountID, *e.Fee
+}
+
+// LegTotals returns how much the user is debited and credited by legs. Sums are bounded by the total
+// of legs checked by ValidateLegs.
+func LegTotals(userID int64, legs []*EventLeg) (debit, credit int64) {
+	for _, leg := range legs {
+		if leg.UserID != userID {
+			continue
+		}
+		if leg.Amount < 0 {
+			debit -= leg.Amount
+		} else {
+			credit += leg.Amount
+		}
+	}
+	return debit, credit
+}
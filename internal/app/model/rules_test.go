@@ -0,0 +1,72 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+func TestBalanceRules(t *testing.T) {
+	active := Balance{UserID: 1, Balance: 50, CreditLimit: 20, Status: AccountStatusActive}
+	frozen := Balance{UserID: 1, Balance: 50, Status: AccountStatusFrozen}
+	closed := Balance{UserID: 1, Status: AccountStatusClosed}
+
+	require.NoError(t, active.CheckDebit(70))
+	require.ErrorIs(t, active.CheckDebit(71), ErrNegativeBalance)
+	require.ErrorIs(t, frozen.CheckDebit(1), ErrAccountFrozen)
+
+	require.NoError(t, frozen.CheckCredit())
+	require.ErrorIs(t, closed.CheckCredit(), ErrAccountClosed)
+
+	require.NoError(t, frozen.CheckReverseDebit(50))
+	require.ErrorIs(t, frozen.CheckReverseDebit(51), ErrNegativeBalance)
+	require.ErrorIs(t, closed.CheckReverseDebit(0), ErrAccountClosed)
+
+	require.NoError(t, active.CheckLegs(100, 30))
+	require.ErrorIs(t, active.CheckLegs(100, 29), ErrNegativeBalance)
+	require.NoError(t, frozen.CheckLegs(0, 10))
+	require.ErrorIs(t, frozen.CheckLegs(10, 10), ErrAccountFrozen)
+
+	require.ErrorIs(t, Balance{Balance: -30, Status: AccountStatusActive}.CheckCreditLimit(29), ErrNegativeBalance)
+	require.NoError(t, Balance{Balance: 10, Status: AccountStatusActive}.CheckCreditLimit(math.MaxInt64))
+
+	_, err := Balance{Balance: math.MaxInt64}.AfterCredit(1)
+	require.ErrorIs(t, err, money.ErrOverflow)
+	_, err = Balance{Balance: math.MinInt64}.AfterDebit(1)
+	require.ErrorIs(t, err, money.ErrOverflow)
+	_, err = DebitWithFee(math.MaxInt64, 1)
+	require.ErrorIs(t, err, money.ErrOverflow)
+}
+
+func TestEventFeeCredit(t *testing.T) {
+	fee, feeAccountID := int64(5), int64(200)
+	accountID, amount := Event{}.FeeCredit(100)
+	require.Zero(t, amount)
+	require.Zero(t, accountID)
+
+	accountID, amount = Event{Fee: &fee}.FeeCredit(100)
+	require.EqualValues(t, 100, accountID)
+	require.EqualValues(t, 5, amount)
+
+	accountID, _ = Event{Fee: &fee, FeeAccountID: &feeAccountID}.FeeCredit(100)
+	require.EqualValues(t, 200, accountID)
+}
+
+func TestCheckCurrency(t *testing.T) {
+	rub, usd := Balance{UserID: 1, Currency: "RUB"}, Balance{UserID: 2, Currency: "USD"}
+
+	require.NoError(t, CheckCurrency("", rub, rub))
+	require.NoError(t, CheckCurrency("USD", usd))
+	require.ErrorIs(t, CheckCurrency("", rub, usd), money.ErrCurrencyMismatch)
+	require.ErrorIs(t, CheckCurrency("USD", rub), money.ErrCurrencyMismatch)
+
+	code, ok := ErrorCode(CheckCurrency("USD", rub))
+	require.True(t, ok)
+	require.Equal(t, "currency_mismatch", code)
+
+	require.Equal(t, "RUB", Event{}.AmountCurrency())
+	require.Equal(t, "USD", Event{Currency: "USD"}.AmountCurrency())
+}
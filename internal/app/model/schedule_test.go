@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2021, time.January, 31, 10, 30, 15, 0, time.UTC) // Sunday
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2021, time.January, 31, 10, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, time.February, 7, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/20 9-17 * * 1-5", time.Date(2021, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 12 31 * *", time.Date(2021, time.January, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 12 30 * *", time.Date(2021, time.March, 30, 12, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2021, time.February, 5, 0, 0, 0, 0, time.UTC)},
+		{"15,45 10 * * *", time.Date(2021, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		t.Run(c.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(c.spec)
+			require.NoError(t, err)
+			require.Equal(t, c.next, schedule.Next(from))
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		_, err := ParseSchedule(spec)
+		require.ErrorIs(t, err, ErrInvalidSchedule, spec)
+	}
+}
+
+func TestMandateNextRun(t *testing.T) {
+	from := time.Date(2021, time.January, 31, 10, 30, 0, 0, time.UTC)
+	endTime := time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC)
+	mandate := Mandate{MandateTerms: MandateTerms{Schedule: "@daily", EndTime: &endTime}}
+
+	next, finished := mandate.NextRun(from)
+	require.False(t, finished)
+	require.Equal(t, endTime, next)
+
+	_, finished = mandate.NextRun(endTime)
+	require.True(t, finished)
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrScheduledNotFound = errors.New("scheduled command not found")
+var ErrScheduledNotPending = errors.New("scheduled command is already executed or cancelled")
+
+type ScheduledStatus string
+
+const (
+	ScheduledStatusPending ScheduledStatus = "pending"
+	// ScheduledStatusExecuting command is taken by a worker. If worker died while executing, the command
+	// is claimed again after a timeout and executed only if it has no events of its execution yet.
+	ScheduledStatusExecuting ScheduledStatus = "executing"
+	ScheduledStatusDone      ScheduledStatus = "done"
+	ScheduledStatusFailed    ScheduledStatus = "failed"
+	ScheduledStatusCancelled ScheduledStatus = "cancelled"
+)
+
+// ScheduledCommand is a command with ExecuteAt in the future, it is executed by a worker when due.
+type ScheduledCommand struct {
+	ID       int64   `pg:"id,pk" json:"id"`
+	TenantID string  `pg:"tenant_id,notnull" json:"tenant_id"`
+	UserID   int64   `pg:"user_id,notnull" json:"user_id"`
+	Command  Command `pg:"command,type:jsonb,notnull" json:"command"`
+	// Producer is the client id of the sender, nil for commands scheduled before it was stored.
+	Producer  *string         `pg:"producer" json:"producer,omitempty"`
+	ExecuteAt time.Time       `pg:"execute_at,notnull" json:"execute_at"`
+	Status    ScheduledStatus `pg:"status,notnull" json:"status"`
+	Error     *string         `pg:"error" json:"error,omitempty"`
+
+	CreatedTime  time.Time  `pg:"created_time,notnull" json:"created_time"`
+	ExecutedTime *time.Time `pg:"executed_time" json:"executed_time,omitempty"`
+	// ClaimedTime is when the command was taken by a worker for execution.
+	ClaimedTime *time.Time `pg:"claimed_time" json:"claimed_time,omitempty"`
+	// Attempts is how many times the command was claimed, it is more than 1 if the command was put back
+	// because of temporary error or taken from a crashed worker.
+	Attempts int `pg:"attempts,use_zero" json:"attempts"`
+}
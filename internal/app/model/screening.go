@@ -0,0 +1,157 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// ErrScreeningRejected is returned if withdrawal or transfer is rejected by screening rules.
+var ErrScreeningRejected = errors.New("rejected by screening")
+
+type ScreeningDecision string
+
+const (
+	ScreeningAllow  ScreeningDecision = "allow"
+	ScreeningReject ScreeningDecision = "reject"
+	// ScreeningReview holds operation until it is approved or rejected manually, see Review.
+	ScreeningReview ScreeningDecision = "review"
+)
+
+// Operation is a withdrawal or transfer being screened, ToUserID is nil for withdrawals.
+// MandateID is set for transfers made by mandate, nobody waits for them, so they are rejected instead of held.
+type Operation struct {
+	TenantID   string
+	Type       EventType
+	FromUserID int64
+	ToUserID   *int64
+	Amount     int64
+	Currency   string
+	MandateID  *int64
+}
+
+// Command returns command that makes the operation, withdraw and transfer commands have the same types as their events.
+func (o Operation) Command() Command {
+	return Command{
+		TenantID: o.TenantID, Type: CommandType(o.Type), FromUserID: o.FromUserID, ToUserID: o.ToUserID,
+		Amount: &o.Amount, Currency: o.Currency,
+	}
+}
+
+// Screening is the decision about operation, Rule is the name of the rule that made it.
+type Screening struct {
+	Decision ScreeningDecision
+	Rule     string
+	Reason   string
+}
+
+// AccountActivity is the history of the sender that screening rules look at.
+type AccountActivity struct {
+	OpenedTime time.Time
+	// RecentIncoming is the sum of money received in ScreeningRules.RapidWindow.
+	RecentIncoming int64
+}
+
+// Duration is time.Duration written as "1h30m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ScreeningRules decide which withdrawals and transfers are made, rejected or held for review.
+// Zero value of every rule disables it.
+type ScreeningRules struct {
+	// RejectAmount and ReviewAmount are the smallest amounts that are rejected or held.
+	RejectAmount int64 `json:"reject_amount"`
+	ReviewAmount int64 `json:"review_amount"`
+	// Accounts opened less than NewAccountAge ago may send up to NewAccountMaxAmount without review.
+	NewAccountAge       Duration `json:"new_account_age"`
+	NewAccountMaxAmount int64    `json:"new_account_max_amount"`
+	// Operation is held if the sender received at least RapidMinIncoming in RapidWindow
+	// and sends at least RapidOutPercent of it.
+	RapidWindow      Duration `json:"rapid_window"`
+	RapidMinIncoming int64    `json:"rapid_min_incoming"`
+	RapidOutPercent  int64    `json:"rapid_out_percent"`
+	// BlockedAccounts can't send or receive money.
+	BlockedAccounts []int64 `json:"blocked_accounts"`
+}
+
+// LoadScreeningRules reads ScreeningRules from JSON file.
+func LoadScreeningRules(path string) (ScreeningRules, error) {
+	rules := ScreeningRules{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("error on reading screening rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// NeedsActivity returns true if rules look at AccountActivity, so it has to be loaded.
+func (r ScreeningRules) NeedsActivity() bool {
+	return r.NewAccountAge > 0 || r.RapidWindow > 0
+}
+
+// Evaluate returns decision about the operation. Rejecting rules are checked first.
+func (r ScreeningRules) Evaluate(op Operation, activity AccountActivity, now time.Time) Screening {
+	userIDs := []int64{op.FromUserID}
+	if op.ToUserID != nil {
+		userIDs = append(userIDs, *op.ToUserID)
+	}
+	if screening := r.CheckBlocked(userIDs...); screening.Decision != ScreeningAllow {
+		return screening
+	}
+	if r.RejectAmount > 0 && op.Amount >= r.RejectAmount {
+		return Screening{ScreeningReject, "reject_amount", fmt.Sprintf("amount is at least %d", r.RejectAmount)}
+	}
+
+	if r.ReviewAmount > 0 && op.Amount >= r.ReviewAmount {
+		return Screening{ScreeningReview, "review_amount", fmt.Sprintf("amount is at least %d", r.ReviewAmount)}
+	}
+	if r.NewAccountAge > 0 && now.Sub(activity.OpenedTime) < time.Duration(r.NewAccountAge) && op.Amount > r.NewAccountMaxAmount {
+		return Screening{ScreeningReview, "new_account",
+			fmt.Sprintf("account is opened %s ago and sends more than %d", now.Sub(activity.OpenedTime).Round(time.Second), r.NewAccountMaxAmount)}
+	}
+	if r.RapidWindow > 0 && activity.RecentIncoming >= r.RapidMinIncoming && activity.RecentIncoming > 0 &&
+		atLeastPercent(op.Amount, activity.RecentIncoming, r.RapidOutPercent) {
+		return Screening{ScreeningReview, "rapid_in_out",
+			fmt.Sprintf("account received %d in %s and sends %d", activity.RecentIncoming, time.Duration(r.RapidWindow), op.Amount)}
+	}
+	return Screening{Decision: ScreeningAllow}
+}
+
+// CheckBlocked rejects operation if any of its accounts is blocked. It is the only rule of operations
+// without a single sender and amount, i.e. batch transfers and sweeps on close.
+func (r ScreeningRules) CheckBlocked(userIDs ...int64) Screening {
+	for _, blocked := range r.BlockedAccounts {
+		for _, userID := range userIDs {
+			if blocked == userID {
+				return Screening{ScreeningReject, "blocked_account", fmt.Sprintf("account %d is blocked", blocked)}
+			}
+		}
+	}
+	return Screening{Decision: ScreeningAllow}
+}
+
+// atLeastPercent returns true if amount is at least percent of total, products don't fit int64 for large amounts.
+func atLeastPercent(amount, total, percent int64) bool {
+	scaled := new(big.Int).Mul(big.NewInt(amount), big.NewInt(100))
+	share := new(big.Int).Mul(big.NewInt(total), big.NewInt(percent))
+	return scaled.Cmp(share) >= 0
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScreeningRulesEvaluate(t *testing.T) {
+	rules := ScreeningRules{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"reject_amount": 10000, "review_amount": 1000,
+		"new_account_age": "24h", "new_account_max_amount": 100,
+		"rapid_window": "1h", "rapid_min_incoming": 500, "rapid_out_percent": 80,
+		"blocked_accounts": [13]
+	}`), &rules))
+	require.Equal(t, Duration(24*time.Hour), rules.NewAccountAge)
+
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	old := AccountActivity{OpenedTime: now.AddDate(0, -1, 0)}
+	blocked := int64(13)
+
+	cases := []struct {
+		name     string
+		op       Operation
+		activity AccountActivity
+		decision ScreeningDecision
+		rule     string
+	}{
+		{"allowed", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 999}, old, ScreeningAllow, ""},
+		{"blocked receiver", Operation{Type: EventTypeTransfer, FromUserID: 1, ToUserID: &blocked, Amount: 1}, old, ScreeningReject, "blocked_account"},
+		{"blocked sender", Operation{Type: EventTypeWithdraw, FromUserID: 13, Amount: 1}, old, ScreeningReject, "blocked_account"},
+		{"reject amount", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 10000}, old, ScreeningReject, "reject_amount"},
+		{"review amount", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 1000}, old, ScreeningReview, "review_amount"},
+		{"new account", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 101},
+			AccountActivity{OpenedTime: now.Add(-time.Hour)}, ScreeningReview, "new_account"},
+		{"new account small amount", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 100},
+			AccountActivity{OpenedTime: now.Add(-time.Hour)}, ScreeningAllow, ""},
+		{"rapid in and out", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 400},
+			AccountActivity{OpenedTime: old.OpenedTime, RecentIncoming: 500}, ScreeningReview, "rapid_in_out"},
+		{"rapid in, small out", Operation{Type: EventTypeWithdraw, FromUserID: 1, Amount: 399},
+			AccountActivity{OpenedTime: old.OpenedTime, RecentIncoming: 500}, ScreeningAllow, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			screening := rules.Evaluate(c.op, c.activity, now)
+			require.Equal(t, c.decision, screening.Decision)
+			require.Equal(t, c.rule, screening.Rule)
+		})
+	}
+
+	require.Equal(t, ScreeningAllow, ScreeningRules{}.Evaluate(Operation{Amount: 1e9}, AccountActivity{}, now).Decision)
+}
+
+func TestScreeningRapidInOutLargeAmounts(t *testing.T) {
+	rules := ScreeningRules{RapidWindow: Duration(time.Hour), RapidMinIncoming: 1, RapidOutPercent: 80}
+	now := time.Now()
+	activity := AccountActivity{OpenedTime: now.AddDate(-1, 0, 0), RecentIncoming: math.MaxInt64}
+
+	// amount*100 and incoming*percent don't fit int64
+	require.Equal(t, ScreeningAllow, rules.Evaluate(Operation{FromUserID: 1, Amount: math.MaxInt64 / 2}, activity, now).Decision)
+	require.Equal(t, ScreeningReview, rules.Evaluate(Operation{FromUserID: 1, Amount: math.MaxInt64}, activity, now).Decision)
+}
+
+func TestScreeningRulesCheckBlocked(t *testing.T) {
+	rules := ScreeningRules{BlockedAccounts: []int64{13}, RejectAmount: 1}
+
+	require.Equal(t, ScreeningAllow, rules.CheckBlocked(1, 2, 3).Decision)
+	require.Equal(t, "blocked_account", rules.CheckBlocked(1, 13).Rule)
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidTenant is returned if command has tenant id that can't be used.
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// DefaultTenant is the tenant of commands without tenant id and of all data created before tenants were added.
+const DefaultTenant = "default"
+
+// tenant id is a part of NATS subjects, so it can't have dots, wildcards or spaces.
+var tenantIDRe = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ValidateTenantID returns ErrInvalidTenant if id is not 1-32 lowercase letters, digits, '_' or '-'.
+func ValidateTenantID(tenantID string) error {
+	if !tenantIDRe.MatchString(tenantID) {
+		return fmt.Errorf("%w: %q must be 1-32 of a-z, 0-9, '_' and '-'", ErrInvalidTenant, tenantID)
+	}
+	return nil
+}
+
+type tenantIDKey struct{}
+
+// ContextWithTenantID returns ctx of handling command of the tenant, service methods called with it
+// read and change only accounts of this tenant.
+func ContextWithTenantID(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantIDKey{}, tenantID)
+}
+
+// TenantIDFromContext returns tenant of the command being handled, DefaultTenant if ctx has none.
+func TenantIDFromContext(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(tenantIDKey{}).(string); ok && tenantID != "" {
+		return tenantID
+	}
+	return DefaultTenant
+}
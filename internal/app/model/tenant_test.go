@@ -0,0 +1,26 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTenantID(t *testing.T) {
+	for _, tenantID := range []string{DefaultTenant, "brand-1", "a", "shop_2"} {
+		require.NoError(t, ValidateTenantID(tenantID), tenantID)
+	}
+	for _, tenantID := range []string{"", "Brand", "brand.1", "brand*", "brand 1", "a23456789012345678901234567890123"} {
+		require.ErrorIs(t, ValidateTenantID(tenantID), ErrInvalidTenant, tenantID)
+	}
+
+	amount := int64(1)
+	require.NoError(t, Command{Type: CommandTypeDeposit, Amount: &amount}.Validate())
+	require.ErrorIs(t, Command{TenantID: "a.>", Type: CommandTypeDeposit, Amount: &amount}.Validate(), ErrInvalidTenant)
+}
+
+func TestTenantIDFromContext(t *testing.T) {
+	require.Equal(t, DefaultTenant, TenantIDFromContext(context.Background()))
+	require.Equal(t, "brand", TenantIDFromContext(ContextWithTenantID(context.Background(), "brand")))
+}
@@ -0,0 +1,157 @@
+// Package billingpb has Go types of billing.proto. They are written by hand instead of generated,
+// so that build doesn't need protoc: gogo/protobuf marshals them by the struct tags.
+// Every change of billing.proto must be repeated here with the same field numbers.
+package billingpb
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+)
+
+type Envelope struct {
+	SchemaVersion int32            `protobuf:"varint,1,opt,name=schema_version,proto3"`
+	MessageId     string           `protobuf:"bytes,2,opt,name=message_id,proto3"`
+	Type          string           `protobuf:"bytes,3,opt,name=type,proto3"`
+	Producer      string           `protobuf:"bytes,4,opt,name=producer,proto3"`
+	CreatedTime   *types.Timestamp `protobuf:"bytes,5,opt,name=created_time,proto3"`
+	Traceparent   string           `protobuf:"bytes,6,opt,name=traceparent,proto3"`
+	CausationId   string           `protobuf:"bytes,7,opt,name=causation_id,proto3"`
+	ContentType   string           `protobuf:"bytes,8,opt,name=content_type,proto3"`
+	Payload       []byte           `protobuf:"bytes,9,opt,name=payload,proto3"`
+	KeyId         string           `protobuf:"bytes,10,opt,name=key_id,proto3"`
+	Signature     string           `protobuf:"bytes,11,opt,name=signature,proto3"`
+}
+
+type Command struct {
+	Id          int64             `protobuf:"varint,1,opt,name=id,proto3"`
+	Type        string            `protobuf:"bytes,2,opt,name=type,proto3"`
+	FromUserId  int64             `protobuf:"varint,3,opt,name=from_user_id,proto3"`
+	ToUserId    *types.Int64Value `protobuf:"bytes,4,opt,name=to_user_id,proto3"`
+	Amount      *types.Int64Value `protobuf:"bytes,5,opt,name=amount,proto3"`
+	Limits      *Limits           `protobuf:"bytes,6,opt,name=limits,proto3"`
+	FeeRule     *FeeRule          `protobuf:"bytes,7,opt,name=fee_rule,proto3"`
+	EventId     *types.Int64Value `protobuf:"bytes,8,opt,name=event_id,proto3"`
+	Legs        []*EventLeg       `protobuf:"bytes,9,rep,name=legs,proto3"`
+	ExecuteAt   *types.Timestamp  `protobuf:"bytes,10,opt,name=execute_at,proto3"`
+	ScheduledId *types.Int64Value `protobuf:"bytes,11,opt,name=scheduled_id,proto3"`
+	Mandate     *MandateTerms     `protobuf:"bytes,12,opt,name=mandate,proto3"`
+	MandateId   *types.Int64Value `protobuf:"bytes,13,opt,name=mandate_id,proto3"`
+	ReviewId    *types.Int64Value `protobuf:"bytes,14,opt,name=review_id,proto3"`
+	TenantId    string            `protobuf:"bytes,15,opt,name=tenant_id,proto3"`
+	Currency    string            `protobuf:"bytes,16,opt,name=currency,proto3"`
+}
+
+type Event struct {
+	Id                 int64              `protobuf:"varint,1,opt,name=id,proto3"`
+	CommandId          *types.Int64Value  `protobuf:"bytes,2,opt,name=command_id,proto3"`
+	Type               string             `protobuf:"bytes,3,opt,name=type,proto3"`
+	FromUserId         int64              `protobuf:"varint,4,opt,name=from_user_id,proto3"`
+	ToUserId           *types.Int64Value  `protobuf:"bytes,5,opt,name=to_user_id,proto3"`
+	Amount             *types.Int64Value  `protobuf:"bytes,6,opt,name=amount,proto3"`
+	Fee                *types.Int64Value  `protobuf:"bytes,7,opt,name=fee,proto3"`
+	FeeRuleId          *types.Int64Value  `protobuf:"bytes,8,opt,name=fee_rule_id,proto3"`
+	ReversedEventId    *types.Int64Value  `protobuf:"bytes,9,opt,name=reversed_event_id,proto3"`
+	Legs               []*EventLeg        `protobuf:"bytes,10,rep,name=legs,proto3"`
+	ScheduledCommandId *types.Int64Value  `protobuf:"bytes,11,opt,name=scheduled_command_id,proto3"`
+	MandateId          *types.Int64Value  `protobuf:"bytes,12,opt,name=mandate_id,proto3"`
+	ErrorCode          *types.StringValue `protobuf:"bytes,13,opt,name=error_code,proto3"`
+	CreatedTime        *types.Timestamp   `protobuf:"bytes,14,opt,name=created_time,proto3"`
+	QueueId            string             `protobuf:"bytes,15,opt,name=queue_id,proto3"`
+	QueueSentTime      *types.Timestamp   `protobuf:"bytes,16,opt,name=queue_sent_time,proto3"`
+	ReviewId           *types.Int64Value  `protobuf:"bytes,17,opt,name=review_id,proto3"`
+	TenantId           string             `protobuf:"bytes,18,opt,name=tenant_id,proto3"`
+	FeeAccountId       *types.Int64Value  `protobuf:"bytes,19,opt,name=fee_account_id,proto3"`
+	Currency           string             `protobuf:"bytes,20,opt,name=currency,proto3"`
+}
+
+type OperationFailed struct {
+	CommandId   int64            `protobuf:"varint,1,opt,name=command_id,proto3"`
+	Command     *Command         `protobuf:"bytes,2,opt,name=command,proto3"`
+	Error       string           `protobuf:"bytes,3,opt,name=error,proto3"`
+	Message     string           `protobuf:"bytes,4,opt,name=message,proto3"`
+	LimitUsage  *LimitUsage      `protobuf:"bytes,5,opt,name=limit_usage,proto3"`
+	CreatedTime *types.Timestamp `protobuf:"bytes,6,opt,name=created_time,proto3"`
+}
+
+type EventLeg struct {
+	UserId int64 `protobuf:"varint,1,opt,name=user_id,proto3"`
+	Amount int64 `protobuf:"varint,2,opt,name=amount,proto3"`
+}
+
+type Limits struct {
+	MaxAmount           int64 `protobuf:"varint,1,opt,name=max_amount,proto3"`
+	MaxDailyOutgoing    int64 `protobuf:"varint,2,opt,name=max_daily_outgoing,proto3"`
+	MaxMonthlyOutgoing  int64 `protobuf:"varint,3,opt,name=max_monthly_outgoing,proto3"`
+	MaxHourlyOperations int64 `protobuf:"varint,4,opt,name=max_hourly_operations,proto3"`
+}
+
+type LimitUsage struct {
+	Limit     string `protobuf:"bytes,1,opt,name=limit,proto3"`
+	Max       int64  `protobuf:"varint,2,opt,name=max,proto3"`
+	Used      int64  `protobuf:"varint,3,opt,name=used,proto3"`
+	Requested int64  `protobuf:"varint,4,opt,name=requested,proto3"`
+}
+
+type FeeRule struct {
+	Id          int64            `protobuf:"varint,1,opt,name=id,proto3"`
+	Operation   string           `protobuf:"bytes,2,opt,name=operation,proto3"`
+	Kind        string           `protobuf:"bytes,3,opt,name=kind,proto3"`
+	Flat        int64            `protobuf:"varint,4,opt,name=flat,proto3"`
+	PercentBp   int64            `protobuf:"varint,5,opt,name=percent_bp,proto3"`
+	MinFee      int64            `protobuf:"varint,6,opt,name=min_fee,proto3"`
+	MaxFee      int64            `protobuf:"varint,7,opt,name=max_fee,proto3"`
+	Tiers       []*FeeTier       `protobuf:"bytes,8,rep,name=tiers,proto3"`
+	CreatedTime *types.Timestamp `protobuf:"bytes,9,opt,name=created_time,proto3"`
+}
+
+type FeeTier struct {
+	UpTo      int64 `protobuf:"varint,1,opt,name=up_to,proto3"`
+	Flat      int64 `protobuf:"varint,2,opt,name=flat,proto3"`
+	PercentBp int64 `protobuf:"varint,3,opt,name=percent_bp,proto3"`
+}
+
+type MandateTerms struct {
+	Schedule   string           `protobuf:"bytes,1,opt,name=schedule,proto3"`
+	EndTime    *types.Timestamp `protobuf:"bytes,2,opt,name=end_time,proto3"`
+	MaxRetries int32            `protobuf:"varint,3,opt,name=max_retries,proto3"`
+}
+
+func (m *Envelope) Reset()         { *m = Envelope{} }
+func (m *Envelope) String() string { return proto.CompactTextString(m) }
+func (*Envelope) ProtoMessage()    {}
+
+func (m *Command) Reset()         { *m = Command{} }
+func (m *Command) String() string { return proto.CompactTextString(m) }
+func (*Command) ProtoMessage()    {}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+
+func (m *OperationFailed) Reset()         { *m = OperationFailed{} }
+func (m *OperationFailed) String() string { return proto.CompactTextString(m) }
+func (*OperationFailed) ProtoMessage()    {}
+
+func (m *EventLeg) Reset()         { *m = EventLeg{} }
+func (m *EventLeg) String() string { return proto.CompactTextString(m) }
+func (*EventLeg) ProtoMessage()    {}
+
+func (m *Limits) Reset()         { *m = Limits{} }
+func (m *Limits) String() string { return proto.CompactTextString(m) }
+func (*Limits) ProtoMessage()    {}
+
+func (m *LimitUsage) Reset()         { *m = LimitUsage{} }
+func (m *LimitUsage) String() string { return proto.CompactTextString(m) }
+func (*LimitUsage) ProtoMessage()    {}
+
+func (m *FeeRule) Reset()         { *m = FeeRule{} }
+func (m *FeeRule) String() string { return proto.CompactTextString(m) }
+func (*FeeRule) ProtoMessage()    {}
+
+func (m *FeeTier) Reset()         { *m = FeeTier{} }
+func (m *FeeTier) String() string { return proto.CompactTextString(m) }
+func (*FeeTier) ProtoMessage()    {}
+
+func (m *MandateTerms) Reset()         { *m = MandateTerms{} }
+func (m *MandateTerms) String() string { return proto.CompactTextString(m) }
+func (*MandateTerms) ProtoMessage()    {}
@@ -0,0 +1,263 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/itimofeev/simple-billing/internal/app/queue/billingpb"
+)
+
+// CurrentSchemaVersion is the envelope version written by default.
+//
+// Version 0 is the bare JSON of the payload without envelope, it is still read and may be written
+// while consumers that don't know envelopes are running. Optional fields are added to payloads
+// without changing the version, consumers ignore fields they don't know. Version is increased only
+// for incompatible changes, together with a decoder of the new version in decoders.
+const CurrentSchemaVersion = 1
+
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// ContentTypeJSON is the default encoding of envelope and payload. With ContentTypeProtobuf both envelope and payload
+// are billingpb messages, it is supported since schema version 1 for commands, events and failures.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+type MessageType string
+
+const (
+	MessageTypeCommand         MessageType = "command"
+	MessageTypeEvent           MessageType = "event"
+	MessageTypeOperationFailed MessageType = "operation_failed"
+)
+
+// Envelope wraps every message with metadata.
+type Envelope struct {
+	SchemaVersion int         `json:"schema_version"`
+	MessageID     string      `json:"message_id"`
+	Type          MessageType `json:"type"`
+	// Producer is the client id of the publisher.
+	Producer    string    `json:"producer"`
+	CreatedTime time.Time `json:"created_time"`
+	// TraceParent is W3C trace context, it is passed from the command to all messages caused by it.
+	TraceParent string `json:"traceparent,omitempty"`
+	// CausationID is the MessageID of the message that caused this one.
+	CausationID string `json:"causation_id,omitempty"`
+	// ContentType is the encoding of the message, empty means ContentTypeJSON.
+	ContentType string `json:"content_type,omitempty"`
+	// KeyID is the key of Producer the message is signed with, see Keyring.
+	KeyID     string `json:"key_id,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Payload is encoded according to ContentType.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// decoders read envelope of every supported version, payload of the result has the current schema.
+// nolint:gochecknoglobals
+var decoders = map[int]func(data []byte) (Envelope, error){
+	0: func(data []byte) (Envelope, error) {
+		return Envelope{Payload: data}, nil
+	},
+	1: func(data []byte) (env Envelope, err error) {
+		return env, json.Unmarshal(data, &env)
+	},
+}
+
+// Encoding is how a producer writes messages.
+type Encoding struct {
+	// SchemaVersion of the envelope, 0 writes bare payload.
+	SchemaVersion int
+	// ContentType of envelope and payload, empty means ContentTypeJSON.
+	ContentType string
+	// Producer is the client id of the publisher.
+	Producer string
+	// Key signs messages if set.
+	Key *Key
+}
+
+// Encode wraps payload into envelope. Trace context is taken from the envelope of the message being handled in ctx,
+// if there is none new trace is started.
+func Encode(ctx context.Context, enc Encoding, msgType MessageType, payload interface{}) ([]byte, error) {
+	version, contentType := enc.SchemaVersion, enc.ContentType
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	if contentType != ContentTypeJSON && contentType != ContentTypeProtobuf {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+	if version == 0 {
+		if contentType != ContentTypeJSON {
+			return nil, fmt.Errorf("%w: %s requires envelope", ErrUnsupportedContentType, contentType)
+		}
+		if enc.Key != nil {
+			return nil, errors.New("message without envelope can't be signed")
+		}
+		return json.Marshal(payload)
+	}
+	if version != CurrentSchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSchemaVersion, version)
+	}
+
+	env := Envelope{
+		SchemaVersion: version,
+		MessageID:     randomHex(16),
+		Type:          msgType,
+		Producer:      enc.Producer,
+		CreatedTime:   time.Now(),
+		TraceParent:   "00-" + randomHex(16) + "-" + randomHex(8) + "-01",
+	}
+	if cause, ok := EnvelopeFromContext(ctx); ok {
+		env.CausationID = cause.MessageID
+		if cause.TraceParent != "" {
+			env.TraceParent = cause.TraceParent
+		}
+	}
+
+	var err error
+	if contentType == ContentTypeProtobuf {
+		env.ContentType = contentType
+		env.Payload, err = marshalProto(payload)
+	} else {
+		env.Payload, err = json.Marshal(payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enc.Key != nil {
+		env.KeyID = enc.Key.ID
+		env.Signature = sign(env, enc.Key.Secret)
+	}
+
+	if contentType == ContentTypeProtobuf {
+		return proto.Marshal(envelopeToProto(env))
+	}
+	return json.Marshal(env)
+}
+
+// Decode reads message of any supported version and content type into payload and returns its envelope.
+func Decode(data []byte, payload interface{}) (Envelope, error) {
+	env, err := decodeEnvelope(data)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	switch env.ContentType {
+	case "", ContentTypeJSON:
+		return env, json.Unmarshal(env.Payload, payload)
+	case ContentTypeProtobuf:
+		return env, unmarshalProto(env.Payload, payload)
+	default:
+		return Envelope{}, fmt.Errorf("%w: %s", ErrUnsupportedContentType, env.ContentType)
+	}
+}
+
+func decodeEnvelope(data []byte) (Envelope, error) {
+	// JSON message is always an object, while protobuf envelope starts with the tag of one of its fields
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '{' {
+		msg := &billingpb.Envelope{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return Envelope{}, err
+		}
+		if msg.SchemaVersion != CurrentSchemaVersion {
+			return Envelope{}, fmt.Errorf("%w: %d", ErrUnsupportedSchemaVersion, msg.SchemaVersion)
+		}
+		return envelopeFromProto(msg), nil
+	}
+
+	var head struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return Envelope{}, err
+	}
+	version := 0
+	if head.SchemaVersion != nil {
+		version = *head.SchemaVersion
+	}
+
+	decode, ok := decoders[version]
+	if !ok {
+		return Envelope{}, fmt.Errorf("%w: %d", ErrUnsupportedSchemaVersion, version)
+	}
+	return decode(data)
+}
+
+func envelopeToProto(env Envelope) *billingpb.Envelope {
+	return &billingpb.Envelope{
+		SchemaVersion: int32(env.SchemaVersion),
+		MessageId:     env.MessageID,
+		Type:          string(env.Type),
+		Producer:      env.Producer,
+		CreatedTime:   timeToProto(env.CreatedTime),
+		Traceparent:   env.TraceParent,
+		CausationId:   env.CausationID,
+		ContentType:   env.ContentType,
+		KeyId:         env.KeyID,
+		Signature:     env.Signature,
+		Payload:       env.Payload,
+	}
+}
+
+func envelopeFromProto(msg *billingpb.Envelope) Envelope {
+	return Envelope{
+		SchemaVersion: int(msg.SchemaVersion),
+		MessageID:     msg.MessageId,
+		Type:          MessageType(msg.Type),
+		Producer:      msg.Producer,
+		CreatedTime:   timeFromProto(msg.CreatedTime),
+		TraceParent:   msg.Traceparent,
+		CausationID:   msg.CausationId,
+		ContentType:   msg.ContentType,
+		KeyID:         msg.KeyId,
+		Signature:     msg.Signature,
+		Payload:       msg.Payload,
+	}
+}
+
+type envelopeKey struct{}
+
+// ContextWithEnvelope returns ctx of handling the message, messages published with it continue its trace.
+func ContextWithEnvelope(ctx context.Context, env Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, env)
+}
+
+func EnvelopeFromContext(ctx context.Context) (Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey{}).(Envelope)
+	return env, ok
+}
+
+// Delivery identifies the delivered message. Copies of a message redelivered after AckWait have the same
+// Subject and Sequence.
+type Delivery struct {
+	Subject     string
+	Sequence    uint64
+	Redelivered bool
+}
+
+type deliveryKey struct{}
+
+// ContextWithDelivery returns ctx of handling the delivered message.
+func ContextWithDelivery(ctx context.Context, d Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, d)
+}
+
+func DeliveryFromContext(ctx context.Context) (Delivery, bool) {
+	d, ok := ctx.Value(deliveryKey{}).(Delivery)
+	return d, ok
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
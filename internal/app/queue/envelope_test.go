@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+func TestDecodeSupportsAllVersions(t *testing.T) {
+	amount := int64(10)
+	command := model.Command{ID: 1, Type: model.CommandTypeDeposit, FromUserID: 2, Amount: &amount}
+
+	legacy, err := Encode(context.Background(), Encoding{SchemaVersion: 0, Producer: "test"}, MessageTypeCommand, command)
+	require.NoError(t, err)
+	current, err := Encode(context.Background(), Encoding{SchemaVersion: CurrentSchemaVersion, Producer: "test"}, MessageTypeCommand, command)
+	require.NoError(t, err)
+
+	for _, data := range [][]byte{legacy, current} {
+		decoded := model.Command{}
+		_, err := Decode(data, &decoded)
+		require.NoError(t, err)
+		require.Equal(t, command, decoded)
+	}
+
+	env, err := Decode(current, &model.Command{})
+	require.NoError(t, err)
+	require.Equal(t, CurrentSchemaVersion, env.SchemaVersion)
+	require.Equal(t, "test", env.Producer)
+	require.Equal(t, MessageTypeCommand, env.Type)
+	require.NotEmpty(t, env.MessageID)
+	require.Regexp(t, "^00-[0-9a-f]{32}-[0-9a-f]{16}-01$", env.TraceParent)
+}
+
+func TestDecodeIgnoresUnknownFieldsAndRejectsUnknownVersion(t *testing.T) {
+	data := []byte(`{"schema_version": 1, "message_id": "1", "new_header": "x", "payload": {"id": 5, "new_field": true}}`)
+	command := model.Command{}
+	_, err := Decode(data, &command)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, command.ID)
+
+	_, err = Decode([]byte(`{"schema_version": 100, "payload": {}}`), &command)
+	require.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+}
+
+func TestEncodeContinuesTraceOfHandledMessage(t *testing.T) {
+	data, err := Encode(context.Background(), Encoding{SchemaVersion: CurrentSchemaVersion, Producer: "client"}, MessageTypeCommand, model.Command{})
+	require.NoError(t, err)
+	cause, err := Decode(data, &model.Command{})
+	require.NoError(t, err)
+
+	data, err = Encode(ContextWithEnvelope(context.Background(), cause), Encoding{SchemaVersion: CurrentSchemaVersion, Producer: "worker"}, MessageTypeEvent, model.Event{})
+	require.NoError(t, err)
+	env := Envelope{}
+	require.NoError(t, json.Unmarshal(data, &env))
+	require.Equal(t, cause.TraceParent, env.TraceParent)
+	require.Equal(t, cause.MessageID, env.CausationID)
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	created := time.Date(2021, time.March, 1, 10, 0, 0, 123, time.UTC)
+	amount, toUserID, code := int64(10), int64(3), "negative_balance"
+	command := model.Command{
+		ID: 1, TenantID: "brand", Type: model.CommandTypeCreateMandate, FromUserID: 2, ToUserID: &toUserID, Amount: &amount,
+		Currency: "USD",
+		Legs:     []*model.EventLeg{{UserID: 2, Amount: -10}, {UserID: 3, Amount: 10}},
+		FeeRule:  &model.FeeRule{Operation: model.EventTypeTransfer, Kind: model.FeeKindTiered, Tiers: []model.FeeTier{{UpTo: 100, Flat: 1}}},
+		Mandate:  &model.MandateTerms{Schedule: "@daily", EndTime: &created, MaxRetries: 2},
+	}
+	event := model.Event{
+		ID: 7, TenantID: "brand", CommandID: &command.ID, Type: model.EventTypeMandateFailed, FromUserID: 2, ToUserID: &toUserID,
+		Amount: &amount, Currency: "USD", ErrorCode: &code, CreatedTime: created, QueueID: "42",
+	}
+	failed := model.OperationFailed{
+		CommandID: 1, Command: command, Error: "limit_exceeded", Message: "limit exceeded",
+		LimitUsage: &model.LimitUsage{Limit: model.LimitMaxAmount, Max: 5, Requested: 10}, CreatedTime: created,
+	}
+
+	for _, c := range []struct {
+		payload interface{}
+		decoded interface{}
+	}{
+		{command, &model.Command{}},
+		{&event, &model.Event{}},
+		{failed, &model.OperationFailed{}},
+	} {
+		data, err := Encode(context.Background(), Encoding{SchemaVersion: CurrentSchemaVersion, ContentType: ContentTypeProtobuf, Producer: "test"}, MessageTypeEvent, c.payload)
+		require.NoError(t, err)
+		env, err := Decode(data, c.decoded)
+		require.NoError(t, err)
+		require.Equal(t, ContentTypeProtobuf, env.ContentType)
+		require.Equal(t, "test", env.Producer)
+
+		expected, err := json.Marshal(c.payload)
+		require.NoError(t, err)
+		actual, err := json.Marshal(c.decoded)
+		require.NoError(t, err)
+		require.JSONEq(t, string(expected), string(actual))
+	}
+
+	_, err := Encode(context.Background(), Encoding{SchemaVersion: 0, ContentType: ContentTypeProtobuf, Producer: "test"}, MessageTypeCommand, command)
+	require.ErrorIs(t, err, ErrUnsupportedContentType)
+}
+
+func TestKeyringVerify(t *testing.T) {
+	oldKey, newKey := Key{ID: "old", Secret: []byte("old secret")}, Key{ID: "new", Secret: []byte("new secret")}
+	keyring := Keyring{"shop": {newKey, oldKey}}
+	amount := int64(10)
+	command := model.Command{ID: 1, Type: model.CommandTypeDeposit, FromUserID: 2, Amount: &amount}
+
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeProtobuf} {
+		for _, key := range []Key{oldKey, newKey} {
+			key := key
+			data, err := Encode(context.Background(),
+				Encoding{SchemaVersion: CurrentSchemaVersion, ContentType: contentType, Producer: "shop", Key: &key}, MessageTypeCommand, command)
+			require.NoError(t, err)
+			env, err := Decode(data, &model.Command{})
+			require.NoError(t, err)
+			require.NoError(t, keyring.Verify(env), contentType)
+
+			env.Producer = "other"
+			require.ErrorIs(t, keyring.Verify(env), model.ErrInvalidSignature)
+		}
+	}
+
+	unknownKey := Key{ID: "old", Secret: []byte("guessed")}
+	data, err := Encode(context.Background(), Encoding{SchemaVersion: CurrentSchemaVersion, Producer: "shop", Key: &unknownKey}, MessageTypeCommand, command)
+	require.NoError(t, err)
+	env, err := Decode(data, &model.Command{})
+	require.NoError(t, err)
+	require.ErrorIs(t, keyring.Verify(env), model.ErrInvalidSignature)
+
+	// payload is changed after signing
+	env, err = Decode(bytes.Replace(data, []byte(`"amount":10`), []byte(`"amount":1000`), 1), &model.Command{})
+	require.NoError(t, err)
+	require.ErrorIs(t, keyring.Verify(env), model.ErrInvalidSignature)
+
+	data, err = Encode(context.Background(), Encoding{SchemaVersion: CurrentSchemaVersion, Producer: "shop"}, MessageTypeCommand, command)
+	require.NoError(t, err)
+	env, err = Decode(data, &model.Command{})
+	require.NoError(t, err)
+	require.ErrorIs(t, keyring.Verify(env), model.ErrInvalidSignature)
+}
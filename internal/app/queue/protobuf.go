@@ -0,0 +1,329 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/internal/app/queue/billingpb"
+)
+
+// marshalProto writes payload as its billingpb message.
+func marshalProto(payload interface{}) ([]byte, error) {
+	var msg proto.Message
+	switch p := payload.(type) {
+	case model.Command:
+		msg = commandToProto(&p)
+	case *model.Command:
+		msg = commandToProto(p)
+	case model.Event:
+		msg = eventToProto(&p)
+	case *model.Event:
+		msg = eventToProto(p)
+	case model.OperationFailed:
+		msg = failedToProto(&p)
+	case *model.OperationFailed:
+		msg = failedToProto(p)
+	default:
+		return nil, fmt.Errorf("%w: %s for %T", ErrUnsupportedContentType, ContentTypeProtobuf, payload)
+	}
+	return proto.Marshal(msg)
+}
+
+// unmarshalProto reads billingpb message into payload that must be a pointer to the model type.
+func unmarshalProto(data []byte, payload interface{}) error {
+	switch p := payload.(type) {
+	case *model.Command:
+		msg := &billingpb.Command{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return err
+		}
+		*p = commandFromProto(msg)
+	case *model.Event:
+		msg := &billingpb.Event{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return err
+		}
+		*p = eventFromProto(msg)
+	case *model.OperationFailed:
+		msg := &billingpb.OperationFailed{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return err
+		}
+		*p = failedFromProto(msg)
+	default:
+		return fmt.Errorf("%w: %s for %T", ErrUnsupportedContentType, ContentTypeProtobuf, payload)
+	}
+	return nil
+}
+
+func commandToProto(c *model.Command) *billingpb.Command {
+	msg := &billingpb.Command{
+		Id:          c.ID,
+		Type:        string(c.Type),
+		FromUserId:  c.FromUserID,
+		ToUserId:    int64ToProto(c.ToUserID),
+		Amount:      int64ToProto(c.Amount),
+		FeeRule:     feeRuleToProto(c.FeeRule),
+		EventId:     int64ToProto(c.EventID),
+		Legs:        legsToProto(c.Legs),
+		ExecuteAt:   optionalTimeToProto(c.ExecuteAt),
+		ScheduledId: int64ToProto(c.ScheduledID),
+		MandateId:   int64ToProto(c.MandateID),
+		ReviewId:    int64ToProto(c.ReviewID),
+		TenantId:    c.TenantID,
+		Currency:    c.Currency,
+	}
+	if c.Limits != nil {
+		msg.Limits = &billingpb.Limits{
+			MaxAmount:           c.Limits.MaxAmount,
+			MaxDailyOutgoing:    c.Limits.MaxDailyOutgoing,
+			MaxMonthlyOutgoing:  c.Limits.MaxMonthlyOutgoing,
+			MaxHourlyOperations: c.Limits.MaxHourlyOperations,
+		}
+	}
+	if c.Mandate != nil {
+		msg.Mandate = &billingpb.MandateTerms{
+			Schedule:   c.Mandate.Schedule,
+			EndTime:    optionalTimeToProto(c.Mandate.EndTime),
+			MaxRetries: int32(c.Mandate.MaxRetries),
+		}
+	}
+	return msg
+}
+
+func commandFromProto(msg *billingpb.Command) model.Command {
+	c := model.Command{
+		ID:          msg.Id,
+		Type:        model.CommandType(msg.Type),
+		FromUserID:  msg.FromUserId,
+		ToUserID:    int64FromProto(msg.ToUserId),
+		Amount:      int64FromProto(msg.Amount),
+		FeeRule:     feeRuleFromProto(msg.FeeRule),
+		EventID:     int64FromProto(msg.EventId),
+		Legs:        legsFromProto(msg.Legs),
+		ExecuteAt:   optionalTimeFromProto(msg.ExecuteAt),
+		ScheduledID: int64FromProto(msg.ScheduledId),
+		MandateID:   int64FromProto(msg.MandateId),
+		ReviewID:    int64FromProto(msg.ReviewId),
+		TenantID:    msg.TenantId,
+		Currency:    msg.Currency,
+	}
+	if msg.Limits != nil {
+		c.Limits = &model.Limits{
+			MaxAmount:           msg.Limits.MaxAmount,
+			MaxDailyOutgoing:    msg.Limits.MaxDailyOutgoing,
+			MaxMonthlyOutgoing:  msg.Limits.MaxMonthlyOutgoing,
+			MaxHourlyOperations: msg.Limits.MaxHourlyOperations,
+		}
+	}
+	if msg.Mandate != nil {
+		c.Mandate = &model.MandateTerms{
+			Schedule:   msg.Mandate.Schedule,
+			EndTime:    optionalTimeFromProto(msg.Mandate.EndTime),
+			MaxRetries: int(msg.Mandate.MaxRetries),
+		}
+	}
+	return c
+}
+
+func eventToProto(e *model.Event) *billingpb.Event {
+	msg := &billingpb.Event{
+		Id:                 e.ID,
+		CommandId:          int64ToProto(e.CommandID),
+		Type:               string(e.Type),
+		FromUserId:         e.FromUserID,
+		ToUserId:           int64ToProto(e.ToUserID),
+		Amount:             int64ToProto(e.Amount),
+		Fee:                int64ToProto(e.Fee),
+		FeeRuleId:          int64ToProto(e.FeeRuleID),
+		FeeAccountId:       int64ToProto(e.FeeAccountID),
+		ReversedEventId:    int64ToProto(e.ReversedEventID),
+		Legs:               legsToProto(e.Legs),
+		ScheduledCommandId: int64ToProto(e.ScheduledCommandID),
+		MandateId:          int64ToProto(e.MandateID),
+		CreatedTime:        timeToProto(e.CreatedTime),
+		QueueId:            e.QueueID,
+		QueueSentTime:      optionalTimeToProto(e.QueueSentTime),
+		ReviewId:           int64ToProto(e.ReviewID),
+		TenantId:           e.TenantID,
+		Currency:           e.Currency,
+	}
+	if e.ErrorCode != nil {
+		msg.ErrorCode = &types.StringValue{Value: *e.ErrorCode}
+	}
+	return msg
+}
+
+func eventFromProto(msg *billingpb.Event) model.Event {
+	e := model.Event{
+		ID:                 msg.Id,
+		CommandID:          int64FromProto(msg.CommandId),
+		Type:               model.EventType(msg.Type),
+		FromUserID:         msg.FromUserId,
+		ToUserID:           int64FromProto(msg.ToUserId),
+		Amount:             int64FromProto(msg.Amount),
+		Fee:                int64FromProto(msg.Fee),
+		FeeRuleID:          int64FromProto(msg.FeeRuleId),
+		FeeAccountID:       int64FromProto(msg.FeeAccountId),
+		ReversedEventID:    int64FromProto(msg.ReversedEventId),
+		Legs:               legsFromProto(msg.Legs),
+		ScheduledCommandID: int64FromProto(msg.ScheduledCommandId),
+		MandateID:          int64FromProto(msg.MandateId),
+		CreatedTime:        timeFromProto(msg.CreatedTime),
+		QueueID:            msg.QueueId,
+		QueueSentTime:      optionalTimeFromProto(msg.QueueSentTime),
+		ReviewID:           int64FromProto(msg.ReviewId),
+		TenantID:           msg.TenantId,
+		Currency:           msg.Currency,
+	}
+	if msg.ErrorCode != nil {
+		code := msg.ErrorCode.Value
+		e.ErrorCode = &code
+	}
+	return e
+}
+
+func failedToProto(f *model.OperationFailed) *billingpb.OperationFailed {
+	msg := &billingpb.OperationFailed{
+		CommandId:   f.CommandID,
+		Command:     commandToProto(&f.Command),
+		Error:       f.Error,
+		Message:     f.Message,
+		CreatedTime: timeToProto(f.CreatedTime),
+	}
+	if f.LimitUsage != nil {
+		msg.LimitUsage = &billingpb.LimitUsage{
+			Limit:     string(f.LimitUsage.Limit),
+			Max:       f.LimitUsage.Max,
+			Used:      f.LimitUsage.Used,
+			Requested: f.LimitUsage.Requested,
+		}
+	}
+	return msg
+}
+
+func failedFromProto(msg *billingpb.OperationFailed) model.OperationFailed {
+	f := model.OperationFailed{
+		CommandID:   msg.CommandId,
+		Error:       msg.Error,
+		Message:     msg.Message,
+		CreatedTime: timeFromProto(msg.CreatedTime),
+	}
+	if msg.Command != nil {
+		f.Command = commandFromProto(msg.Command)
+	}
+	if msg.LimitUsage != nil {
+		f.LimitUsage = &model.LimitUsage{
+			Limit:     model.LimitName(msg.LimitUsage.Limit),
+			Max:       msg.LimitUsage.Max,
+			Used:      msg.LimitUsage.Used,
+			Requested: msg.LimitUsage.Requested,
+		}
+	}
+	return f
+}
+
+func feeRuleToProto(r *model.FeeRule) *billingpb.FeeRule {
+	if r == nil {
+		return nil
+	}
+	msg := &billingpb.FeeRule{
+		Id:          r.ID,
+		Operation:   string(r.Operation),
+		Kind:        string(r.Kind),
+		Flat:        r.Flat,
+		PercentBp:   r.PercentBP,
+		MinFee:      r.MinFee,
+		MaxFee:      r.MaxFee,
+		CreatedTime: timeToProto(r.CreatedTime),
+	}
+	for _, tier := range r.Tiers {
+		msg.Tiers = append(msg.Tiers, &billingpb.FeeTier{UpTo: tier.UpTo, Flat: tier.Flat, PercentBp: tier.PercentBP})
+	}
+	return msg
+}
+
+func feeRuleFromProto(msg *billingpb.FeeRule) *model.FeeRule {
+	if msg == nil {
+		return nil
+	}
+	r := &model.FeeRule{
+		ID:          msg.Id,
+		Operation:   model.EventType(msg.Operation),
+		Kind:        model.FeeKind(msg.Kind),
+		Flat:        msg.Flat,
+		PercentBP:   msg.PercentBp,
+		MinFee:      msg.MinFee,
+		MaxFee:      msg.MaxFee,
+		CreatedTime: timeFromProto(msg.CreatedTime),
+	}
+	for _, tier := range msg.Tiers {
+		r.Tiers = append(r.Tiers, model.FeeTier{UpTo: tier.UpTo, Flat: tier.Flat, PercentBP: tier.PercentBp})
+	}
+	return r
+}
+
+func legsToProto(legs []*model.EventLeg) []*billingpb.EventLeg {
+	var msgs []*billingpb.EventLeg
+	for _, leg := range legs {
+		msgs = append(msgs, &billingpb.EventLeg{UserId: leg.UserID, Amount: leg.Amount})
+	}
+	return msgs
+}
+
+func legsFromProto(msgs []*billingpb.EventLeg) []*model.EventLeg {
+	var legs []*model.EventLeg
+	for _, msg := range msgs {
+		legs = append(legs, &model.EventLeg{UserID: msg.UserId, Amount: msg.Amount})
+	}
+	return legs
+}
+
+func int64ToProto(v *int64) *types.Int64Value {
+	if v == nil {
+		return nil
+	}
+	return &types.Int64Value{Value: *v}
+}
+
+func int64FromProto(v *types.Int64Value) *int64 {
+	if v == nil {
+		return nil
+	}
+	value := v.Value
+	return &value
+}
+
+// timeToProto writes zero time as nil, so that it is read back as zero time.
+func timeToProto(t time.Time) *types.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return &types.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+func timeFromProto(ts *types.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+}
+
+func optionalTimeToProto(t *time.Time) *types.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return &types.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+func optionalTimeFromProto(ts *types.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := timeFromProto(ts)
+	return &t
+}
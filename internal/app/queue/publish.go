@@ -2,33 +2,60 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
 
 	"github.com/nats-io/stan.go"
 
 	"github.com/itimofeev/simple-billing/internal/app/model"
 )
 
-func (q *Queue) PublishOperationCompleted(_ context.Context, event *model.Event, handler stan.AckHandler) (string, error) {
-	msgData, err := marshalObject(event)
+func (q *Queue) PublishOperationCompleted(ctx context.Context, event *model.Event, handler stan.AckHandler) (string, error) {
+	msgData, err := q.encode(ctx, MessageTypeEvent, event)
 	if err != nil {
 		return "", err
 	}
-	return q.sc.PublishAsync(operationCompletedSubject, msgData, handler)
+
+	q.publishAcks.Add(1)
+	messageID, err := q.sc.PublishAsync(TenantSubject(OperationCompletedSubject, event.TenantID), msgData, func(messageID string, err error) {
+		defer q.publishAcks.Done()
+		handler(messageID, err)
+	})
+	if err != nil {
+		q.publishAcks.Done()
+	}
+	return messageID, err
+}
+
+// RepublishOperationCompleted publishes event and waits until NATS acks it.
+func (q *Queue) RepublishOperationCompleted(ctx context.Context, event *model.Event) error {
+	msgData, err := q.encode(ctx, MessageTypeEvent, event)
+	if err != nil {
+		return err
+	}
+	return q.sc.Publish(TenantSubject(OperationCompletedSubject, event.TenantID), msgData)
 }
 
-func (q *Queue) PublishCommand(_ context.Context, command model.Command) error {
-	msgData, err := marshalObject(command)
+func (q *Queue) PublishOperationFailed(ctx context.Context, failed model.OperationFailed) error {
+	tenantID := failed.Command.Tenant()
+	if err := model.ValidateTenantID(tenantID); err != nil {
+		// subject can't be made of invalid tenant id, and failure must not be seen by subscribers of another tenant
+		q.log.WithError(err).WithField("command_id", failed.Command.ID).Warn("failure of command with invalid tenant is not published")
+		return nil
+	}
+	msgData, err := q.encode(ctx, MessageTypeOperationFailed, failed)
 	if err != nil {
 		return err
 	}
-	return q.sc.Publish(inputCommandSubject, msgData)
+	return q.sc.Publish(TenantSubject(OperationFailedSubject, tenantID), msgData)
 }
 
-func marshalObject(object interface{}) ([]byte, error) {
-	return json.Marshal(object)
+func (q *Queue) PublishCommand(ctx context.Context, command model.Command) error {
+	msgData, err := q.encode(ctx, MessageTypeCommand, command)
+	if err != nil {
+		return err
+	}
+	return q.sc.Publish(CommandSubject(CommandShard(command.FromUserID)), msgData)
 }
 
-func unmarshalObject(data []byte, object interface{}) error {
-	return json.Unmarshal(data, object)
+func (q *Queue) encode(ctx context.Context, msgType MessageType, payload interface{}) ([]byte, error) {
+	return Encode(ctx, q.encoding, msgType, payload)
 }
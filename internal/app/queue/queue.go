@@ -1,26 +1,134 @@
 package queue
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/nats-io/stan.go"
 	"github.com/sirupsen/logrus"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/pkg/shutdown"
 )
 
-const operationCompletedSubject = "operation.completed"
+// OperationCompletedSubject has model.Event of every applied command, OperationFailedSubject has model.OperationFailed of rejected ones.
+// Every tenant has its own subjects, see TenantSubject.
+const OperationCompletedSubject = "operation.completed"
+const OperationFailedSubject = "operation.failed"
+
+// TenantSubject returns subject of the tenant, e.g. "operation.completed.brand". Default tenant uses
+// the subject itself, so that subscribers that existed before tenants keep getting its messages.
+func TenantSubject(subject, tenantID string) string {
+	if tenantID == "" || tenantID == model.DefaultTenant {
+		return subject
+	}
+	return subject + "." + tenantID
+}
+
+// ClusterID is the id of NATS Streaming cluster.
+const ClusterID = "test-cluster"
 
 type Queue struct {
 	sc  stan.Conn
 	log *logrus.Logger
+
+	commandSubsMu sync.Mutex
+	commandSubs   map[int]stan.Subscription
+
+	commandsInFlight sync.WaitGroup
+	publishAcks      sync.WaitGroup
+
+	encoding Encoding
+	// keyring verifies commands if set.
+	keyring Keyring
+	// messages remembers received commands to reject copies, commands older than maxMessageAge are rejected.
+	messages      MessageLog
+	maxMessageAge time.Duration
+}
+
+type Option func(q *Queue)
+
+// WithSchemaVersion sets envelope version of published messages, CurrentSchemaVersion by default.
+// Version 0 is needed while some consumers don't read envelopes yet.
+func WithSchemaVersion(version int) Option {
+	return func(q *Queue) {
+		q.encoding.SchemaVersion = version
+	}
+}
+
+// WithContentType sets encoding of published messages, ContentTypeJSON by default.
+// Messages of any content type are read regardless of it.
+func WithContentType(contentType string) Option {
+	return func(q *Queue) {
+		q.encoding.ContentType = contentType
+	}
+}
+
+// WithSigningKey signs published messages with the key of this client.
+func WithSigningKey(key Key) Option {
+	return func(q *Queue) {
+		q.encoding.Key = &key
+	}
+}
+
+// WithKeyring makes SubscribeCommand accept only commands signed by keys of the keyring.
+// Commands with invalid signatures are rejected with model.OperationFailed.
+func WithKeyring(keyring Keyring) Option {
+	return func(q *Queue) {
+		q.keyring = keyring
+	}
+}
+
+// MessageLog remembers received commands, see WithReplayProtection.
+type MessageLog interface {
+	// RememberMessage saves message until it expires, it returns false if the message was received
+	// before as another NATS message.
+	RememberMessage(ctx context.Context, msg model.ReceivedMessage) (bool, error)
+}
+
+// WithReplayProtection makes SubscribeCommand reject commands created more than maxAge ago or ahead
+// and copies of commands received before. Signature covers message id and created time, so with
+// WithKeyring a captured command can't be published again.
+func WithReplayProtection(messages MessageLog, maxAge time.Duration) Option {
+	return func(q *Queue) {
+		q.messages = messages
+		q.maxMessageAge = maxAge
+	}
 }
 
 // nats://localhost:4222
-func New(log *logrus.Logger, url, clientID string) (*Queue, error) {
+func New(log *logrus.Logger, url, clientID string, opts ...Option) (*Queue, error) {
 	// Connect to a server
-	sc, err := stan.Connect("test-cluster", clientID, stan.NatsURL(url))
+	sc, err := stan.Connect(ClusterID, clientID, stan.NatsURL(url))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Queue{sc: sc, log: log}, nil
+	q := &Queue{
+		sc:          sc,
+		log:         log,
+		commandSubs: make(map[int]stan.Subscription),
+		encoding: Encoding{
+			SchemaVersion: CurrentSchemaVersion,
+			ContentType:   ContentTypeJSON,
+			Producer:      clientID,
+		},
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q, nil
+}
+
+// WaitCommandsInFlight blocks until all command handlers that already started are finished.
+func (q *Queue) WaitCommandsInFlight(ctx context.Context) error {
+	return shutdown.WaitGroup(ctx, &q.commandsInFlight)
+}
+
+// WaitPublishAcks blocks until NATS acknowledged (or rejected) all messages published asynchronously.
+func (q *Queue) WaitPublishAcks(ctx context.Context) error {
+	return shutdown.WaitGroup(ctx, &q.publishAcks)
 }
 
 func (q *Queue) Close() error {
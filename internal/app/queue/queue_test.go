@@ -1,13 +1,86 @@
 package queue
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/nats-io/stan.go"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
 )
 
+func TestTenantSubject(t *testing.T) {
+	require.Equal(t, OperationCompletedSubject, TenantSubject(OperationCompletedSubject, model.DefaultTenant))
+	require.Equal(t, OperationFailedSubject, TenantSubject(OperationFailedSubject, ""))
+	require.Equal(t, "operation.completed.brand", TenantSubject(OperationCompletedSubject, "brand"))
+}
+
+func TestFailureOfInvalidTenantIsDropped(t *testing.T) {
+	// nil connection panics if anything is published
+	q := &Queue{log: logrus.New()}
+	command := model.Command{TenantID: "Not Valid!", Type: model.CommandTypeOpen, FromUserID: 1}
+
+	require.NoError(t, q.PublishOperationFailed(context.Background(), model.NewOperationFailed(command, model.ErrInvalidTenant)))
+}
+
+func TestDispatchCommandReleasesInFlightOnEarlyReturn(t *testing.T) {
+	q := &Queue{log: logrus.New()}
+	called := false
+	f := func(ctx context.Context, command model.Command, done func(err error)) { called = true }
+
+	// message that can't be decoded is not passed to handler, so in-flight counter is released by caller
+	msg := &stan.Msg{}
+	msg.Data = []byte("not a command")
+	require.False(t, q.dispatchCommand(msg, f))
+	require.False(t, called)
+
+	data, err := Encode(context.Background(), Encoding{SchemaVersion: CurrentSchemaVersion, Producer: "shop"}, MessageTypeCommand,
+		model.Command{ID: 1, Type: model.CommandTypeOpen, FromUserID: 1})
+	require.NoError(t, err)
+	msg.Data = data
+	require.True(t, q.dispatchCommand(msg, f))
+	require.True(t, called)
+}
+
+// memoryMessageLog is MessageLog keeping messages in memory.
+type memoryMessageLog map[string]model.ReceivedMessage
+
+func (l memoryMessageLog) RememberMessage(_ context.Context, msg model.ReceivedMessage) (bool, error) {
+	key := msg.TenantID + "/" + msg.Producer + "/" + msg.MessageID
+	saved, ok := l[key]
+	if !ok {
+		l[key] = msg
+		return true, nil
+	}
+	return saved.Subject == msg.Subject && saved.Sequence == msg.Sequence, nil
+}
+
+func TestCheckReplay(t *testing.T) {
+	q := &Queue{messages: memoryMessageLog{}, maxMessageAge: time.Minute}
+	ctx, now := context.Background(), time.Now()
+	env := Envelope{MessageID: "m1", Producer: "shop", CreatedTime: now.Add(-30 * time.Second)}
+
+	require.NoError(t, q.checkReplay(ctx, env, model.DefaultTenant, "input.command.1", 5, now))
+	// redelivery of the same message
+	require.NoError(t, q.checkReplay(ctx, env, model.DefaultTenant, "input.command.1", 5, now))
+	// the same message published again
+	require.ErrorIs(t, q.checkReplay(ctx, env, model.DefaultTenant, "input.command.1", 6, now), model.ErrReplayedMessage)
+
+	other := Envelope{MessageID: "m1", Producer: "other", CreatedTime: now}
+	require.NoError(t, q.checkReplay(ctx, other, model.DefaultTenant, "input.command.1", 7, now))
+	// message ids of other tenants are not mixed up
+	require.NoError(t, q.checkReplay(ctx, env, "brand", "input.command.1", 10, now))
+
+	for _, createdTime := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		stale := Envelope{MessageID: "m2", Producer: "shop", CreatedTime: createdTime}
+		require.ErrorIs(t, q.checkReplay(ctx, stale, model.DefaultTenant, "input.command.1", 8, now), model.ErrStaleMessage)
+	}
+	require.ErrorIs(t, q.checkReplay(ctx, Envelope{}, model.DefaultTenant, "input.command.1", 9, now), model.ErrStaleMessage)
+}
+
 func TestPublishSubscribeToNats(t *testing.T) {
 	// Connect to a server
 	sc, err := stan.Connect("test-cluster", "client-worker-test", stan.NatsURL("nats://localhost:4222"))
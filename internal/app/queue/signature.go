@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// Key is a shared HMAC key of a producer, Secret is base64 in JSON.
+type Key struct {
+	ID     string `json:"id"`
+	Secret []byte `json:"secret"`
+}
+
+// Keyring has keys of every producer allowed to send commands. Producer may have several keys at once,
+// so keys are rotated by adding the new key, switching producer to it and removing the old one.
+type Keyring map[string][]Key
+
+// LoadKey reads Key from JSON file.
+func LoadKey(path string) (Key, error) {
+	key := Key{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf("error on reading key %s: %w", path, err)
+	}
+	if key.ID == "" || len(key.Secret) == 0 {
+		return key, fmt.Errorf("key %s must have id and secret", path)
+	}
+	return key, nil
+}
+
+// LoadKeyring reads Keyring from JSON file: {"producer": [{"id": "...", "secret": "..."}]}.
+func LoadKeyring(path string) (Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyring := Keyring{}
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return nil, fmt.Errorf("error on reading keyring %s: %w", path, err)
+	}
+	for producer, keys := range keyring {
+		for _, key := range keys {
+			if key.ID == "" || len(key.Secret) == 0 {
+				return nil, fmt.Errorf("key of %q in keyring %s must have id and secret", producer, path)
+			}
+		}
+	}
+	return keyring, nil
+}
+
+// Verify checks that envelope is signed by one of the keys of its producer.
+func (k Keyring) Verify(env Envelope) error {
+	if env.Signature == "" {
+		return fmt.Errorf("%w: message of %q is not signed", model.ErrInvalidSignature, env.Producer)
+	}
+	for _, key := range k[env.Producer] {
+		if key.ID != env.KeyID {
+			continue
+		}
+		if !hmac.Equal([]byte(sign(env, key.Secret)), []byte(env.Signature)) {
+			return fmt.Errorf("%w: signature of %q with key %q doesn't match", model.ErrInvalidSignature, env.Producer, env.KeyID)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown key %q of %q", model.ErrInvalidSignature, env.KeyID, env.Producer)
+}
+
+// sign returns HMAC-SHA256 of the payload and envelope fields that identify the message and its producer.
+// Trace fields are not signed, they don't affect how the message is handled.
+func sign(env Envelope, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, field := range []string{
+		strconv.Itoa(env.SchemaVersion),
+		env.MessageID,
+		string(env.Type),
+		env.Producer,
+		strconv.FormatInt(env.CreatedTime.UnixNano(), 10),
+		env.ContentType,
+		env.KeyID,
+	} {
+		_, _ = mac.Write([]byte(field))
+		_, _ = mac.Write([]byte{0})
+	}
+	_, _ = mac.Write(env.Payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
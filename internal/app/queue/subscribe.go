@@ -2,6 +2,8 @@ package queue
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/nats-io/stan.go"
 
@@ -10,22 +12,43 @@ import (
 
 const inputCommandSubject = "input.command"
 
-func (q *Queue) SubscribeCommand(ctx context.Context, f func(ctx context.Context, command model.Command) error) error {
-	cb := func(m *stan.Msg) {
-		command := model.Command{}
-		err := unmarshalObject(m.Data, &command)
-		if err != nil {
-			q.log.WithError(err).Error("error on unmarshalling command")
-			return
-		}
-		ctx := context.Background()
-		if err := f(ctx, command); err != nil {
-			q.log.WithError(err).Error("error on calling callback")
-			return
-		}
+// CommandShards is the number of subjects commands are partitioned to by user.
+// Every shard is handled by one worker at a time, so commands of a user are applied in publish order.
+// Changing it while commands are in the queue breaks ordering.
+const CommandShards = 16
 
-		if err := m.Ack(); err != nil {
-			q.log.WithError(err).Error("error on acking message")
+// CommandShard returns shard all commands of the user are published to.
+func CommandShard(userID int64) int {
+	return int(uint64(userID) % CommandShards)
+}
+
+// CommandSubject returns subject commands of the shard are published to.
+func CommandSubject(shard int) string {
+	return fmt.Sprintf("%s.%d", inputCommandSubject, shard)
+}
+
+// SubscribeOptions tune command subscription, zero values mean stan defaults.
+type SubscribeOptions struct {
+	// MaxInflight is how many unacknowledged commands the server sends to this worker.
+	MaxInflight int
+	// AckWait is how long the server waits for ack before redelivering command.
+	AckWait time.Duration
+}
+
+// CommandHandler is called from the stan goroutine and must not block for long.
+// done has to be called exactly once when command is processed: message is acked
+// if err is nil, otherwise it is redelivered after AckWait.
+type CommandHandler func(ctx context.Context, command model.Command, done func(err error))
+
+// SubscribeCommand subscribes to commands of one shard. Caller is responsible for making sure
+// that no other worker is subscribed to the same shard. If queue has keyring, commands with invalid
+// signatures are rejected before f is called, the same for stale and replayed commands with WithReplayProtection.
+func (q *Queue) SubscribeCommand(ctx context.Context, shard int, subOpts SubscribeOptions, f CommandHandler) error {
+	cb := func(m *stan.Msg) {
+		// counted before verification, so that WaitCommandsInFlight also waits for commands being checked
+		q.commandsInFlight.Add(1)
+		if !q.dispatchCommand(m, f) {
+			q.commandsInFlight.Done()
 		}
 	}
 
@@ -33,29 +56,121 @@ func (q *Queue) SubscribeCommand(ctx context.Context, f func(ctx context.Context
 		stan.DurableName("durableCommand"),
 		stan.SetManualAckMode(),
 	}
-	subscription, err := q.sc.QueueSubscribe(inputCommandSubject, "billing-worker", cb, opts...)
+	if subOpts.MaxInflight > 0 {
+		opts = append(opts, stan.MaxInflight(subOpts.MaxInflight))
+	}
+	if subOpts.AckWait > 0 {
+		opts = append(opts, stan.AckWait(subOpts.AckWait))
+	}
+	subscription, err := q.sc.QueueSubscribe(CommandSubject(shard), "billing-worker", cb, opts...)
 	if err != nil {
 		return err
 	}
 
-	go unsubscribeIfContextClosed(ctx, subscription)
+	q.commandSubsMu.Lock()
+	q.commandSubs[shard] = subscription
+	q.commandSubsMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		_ = q.StopCommands()
+	}()
 	return nil
 }
 
+// dispatchCommand decodes and checks the command and passes it to f. It returns false if f was not called,
+// otherwise commandsInFlight is released by done of f.
+func (q *Queue) dispatchCommand(m *stan.Msg, f CommandHandler) bool {
+	command := model.Command{}
+	env, err := Decode(m.Data, &command)
+	if err != nil {
+		// not acked, so the command is redelivered e.g. to a worker that knows its schema version
+		q.log.WithError(err).Error("error on decoding command")
+		return false
+	}
+	ctx := ContextWithEnvelope(context.Background(), env)
+	if q.keyring != nil {
+		if err := q.keyring.Verify(env); err != nil {
+			q.rejectCommand(ctx, m, command, err)
+			return false
+		}
+	}
+	if err := model.ValidateTenantID(command.Tenant()); err != nil {
+		q.rejectCommand(ctx, m, command, err)
+		return false
+	}
+	if q.messages != nil {
+		err := q.checkReplay(ctx, env, command.Tenant(), m.Subject, m.Sequence, time.Now())
+		if _, ok := model.ErrorCode(err); ok {
+			q.rejectCommand(ctx, m, command, err)
+			return false
+		}
+		if err != nil {
+			// not acked, so the command is checked again when redelivered
+			q.log.WithError(err).Error("error on checking command replay")
+			return false
+		}
+	}
+
+	ctx = ContextWithDelivery(ctx, Delivery{Subject: m.Subject, Sequence: m.Sequence, Redelivered: m.Redelivered})
+	f(ctx, command, func(err error) {
+		defer q.commandsInFlight.Done()
+
+		if err != nil {
+			q.log.WithError(err).Error("error on calling callback")
+			return
+		}
+		if err := m.Ack(); err != nil {
+			q.log.WithError(err).Error("error on acking message")
+		}
+	})
+	return true
+}
+
+// rejectCommand publishes failure of the command and acks it, so that it is not redelivered.
+func (q *Queue) rejectCommand(ctx context.Context, m *stan.Msg, command model.Command, err error) {
+	env, _ := EnvelopeFromContext(ctx)
+	q.log.WithError(err).WithField("producer", env.Producer).WithField("command_id", command.ID).Warn("command rejected")
+	if err := q.PublishOperationFailed(ctx, model.NewOperationFailed(command, err)); err != nil {
+		q.log.WithError(err).Error("error on publishing rejection")
+		return
+	}
+	if err := m.Ack(); err != nil {
+		q.log.WithError(err).Error("error on acking message")
+	}
+}
+
+// StopCommands closes all command subscriptions so no new commands are delivered to this worker.
+// Durable queue group stays on the server, undelivered commands go to the next shard owner. Safe to call several times.
+func (q *Queue) StopCommands() error {
+	q.commandSubsMu.Lock()
+	defer q.commandSubsMu.Unlock()
+
+	var firstErr error
+	for shard, sub := range q.commandSubs {
+		if err := sub.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(q.commandSubs, shard)
+	}
+	return firstErr
+}
+
 func unsubscribeIfContextClosed(ctx context.Context, sub stan.Subscription) {
 	<-ctx.Done()
 	_ = sub.Close()
 }
 
-func (q *Queue) SubscribeOperationCompleted(ctx context.Context, f func(ctx context.Context, event model.Event) error) error {
+// SubscribeOperationCompleted subscribes to events of the tenant.
+func (q *Queue) SubscribeOperationCompleted(ctx context.Context, tenantID string, f func(ctx context.Context, event model.Event) error) error {
 	cb := func(m *stan.Msg) {
 		event := model.Event{}
-		err := unmarshalObject(m.Data, &event)
+		env, err := Decode(m.Data, &event)
 		if err != nil {
-			q.log.WithError(err).Error("error on unmarshalling event")
+			q.log.WithError(err).Error("error on decoding event")
 			return
 		}
-		ctx := context.Background()
+		ctx := ContextWithEnvelope(context.Background(), env)
 		if err := f(ctx, event); err != nil {
 			q.log.WithError(err).Error("error on calling callback")
 			return
@@ -65,7 +180,7 @@ func (q *Queue) SubscribeOperationCompleted(ctx context.Context, f func(ctx cont
 	opts := []stan.SubscriptionOption{
 		stan.DurableName("durableEvent"),
 	}
-	subscription, err := q.sc.Subscribe(operationCompletedSubject, cb, opts...)
+	subscription, err := q.sc.Subscribe(TenantSubject(OperationCompletedSubject, tenantID), cb, opts...)
 	if err != nil {
 		return err
 	}
@@ -73,3 +188,100 @@ func (q *Queue) SubscribeOperationCompleted(ctx context.Context, f func(ctx cont
 	go unsubscribeIfContextClosed(ctx, subscription)
 	return nil
 }
+
+// SubscribeOperationFailed subscribes to failures of commands of the tenant.
+func (q *Queue) SubscribeOperationFailed(ctx context.Context, tenantID string, f func(ctx context.Context, failed model.OperationFailed) error) error {
+	cb := func(m *stan.Msg) {
+		failed := model.OperationFailed{}
+		env, err := Decode(m.Data, &failed)
+		if err != nil {
+			q.log.WithError(err).Error("error on decoding failure")
+			return
+		}
+		ctx := ContextWithEnvelope(context.Background(), env)
+		if err := f(ctx, failed); err != nil {
+			q.log.WithError(err).Error("error on calling callback")
+			return
+		}
+	}
+
+	opts := []stan.SubscriptionOption{
+		stan.DurableName("durableFailure"),
+	}
+	subscription, err := q.sc.Subscribe(TenantSubject(OperationFailedSubject, tenantID), cb, opts...)
+	if err != nil {
+		return err
+	}
+
+	go unsubscribeIfContextClosed(ctx, subscription)
+	return nil
+}
+
+// ReadOperationFailed returns failures of the tenant published since the time that are still stored by the server.
+// Reading stops when no failure was received for idle or ctx is done. It does not affect durable subscriptions.
+func (q *Queue) ReadOperationFailed(ctx context.Context, tenantID string, since time.Time, idle time.Duration) ([]model.OperationFailed, error) {
+	msgs, done := make(chan *stan.Msg), make(chan struct{})
+	subscription, err := q.sc.Subscribe(TenantSubject(OperationFailedSubject, tenantID), func(m *stan.Msg) {
+		select {
+		case msgs <- m:
+		case <-done:
+		}
+	}, stan.StartAtTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = subscription.Close() }()
+	defer close(done)
+
+	var failures []model.OperationFailed
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case m := <-msgs:
+			failed := model.OperationFailed{}
+			if _, err := Decode(m.Data, &failed); err != nil {
+				q.log.WithError(err).Error("error on decoding failure")
+				continue
+			}
+			failures = append(failures, failed)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(idle)
+		case <-timer.C:
+			return failures, nil
+		case <-ctx.Done():
+			return failures, ctx.Err()
+		}
+	}
+}
+
+// checkReplay rejects command created more than maxMessageAge before or after now and copy of command
+// of the tenant received before. Redelivery of the same NATS message is not a copy.
+func (q *Queue) checkReplay(
+	ctx context.Context, env Envelope, tenantID, subject string, sequence uint64, now time.Time,
+) error {
+	if env.MessageID == "" {
+		return fmt.Errorf("%w: message has no envelope", model.ErrStaleMessage)
+	}
+	if age := now.Sub(env.CreatedTime); age > q.maxMessageAge || age < -q.maxMessageAge {
+		return fmt.Errorf("%w: created at %s", model.ErrStaleMessage, env.CreatedTime.Format(time.RFC3339))
+	}
+
+	first, err := q.messages.RememberMessage(ctx, model.ReceivedMessage{
+		TenantID:    tenantID,
+		Producer:    env.Producer,
+		MessageID:   env.MessageID,
+		Subject:     subject,
+		Sequence:    int64(sequence),
+		ExpiresTime: env.CreatedTime.Add(q.maxMessageAge),
+	})
+	if err != nil {
+		return err
+	}
+	if !first {
+		return fmt.Errorf("%w: %s of %q", model.ErrReplayedMessage, env.MessageID, env.Producer)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+// Package replay rebuilds balances from the event log with the same checks service.Service makes
+// before writing events, so that rows changed outside of the service and history that breaks
+// current rules can be found.
+package replay
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// Violation is an event that would be rejected by current rules. It is applied anyway,
+// because it has already happened.
+type Violation struct {
+	EventID   int64           `json:"event_id"`
+	EventType model.EventType `json:"event_type"`
+	UserID    int64           `json:"user_id"`
+	Error     string          `json:"error"`
+}
+
+// Diff is an account that differs in live and replayed balances, one of them is nil if account is missing there.
+type Diff struct {
+	UserID   int64          `json:"user_id"`
+	Live     *model.Balance `json:"live"`
+	Replayed *model.Balance `json:"replayed"`
+}
+
+type Report struct {
+	Events     int         `json:"events"`
+	Accounts   int         `json:"accounts"`
+	Violations []Violation `json:"violations"`
+	Diffs      []Diff      `json:"diffs"`
+}
+
+// Replayer applies events in the order of their ids.
+type Replayer struct {
+	feeAccountID int64
+	balances     map[int64]*model.Balance
+	// depositTypes keeps type of deposits and withdrawals, reversal money direction depends on it.
+	depositTypes map[int64]model.EventType
+
+	events     int
+	violations []Violation
+}
+
+// New returns Replayer crediting fees to the fee account of the event or to feeAccountID
+// for events made before fee account was recorded on them.
+func New(feeAccountID int64) *Replayer {
+	return &Replayer{
+		feeAccountID: feeAccountID,
+		balances:     make(map[int64]*model.Balance),
+		depositTypes: make(map[int64]model.EventType),
+	}
+}
+
+// Apply changes balances according to event. Events that don't move money or change account are skipped.
+func (r *Replayer) Apply(event model.Event) {
+	r.events++
+
+	switch event.Type {
+	case model.EventTypeOpen:
+		if _, ok := r.balances[event.FromUserID]; ok {
+			r.violate(event, event.FromUserID, model.ErrAlreadyExists)
+			return
+		}
+		r.balances[event.FromUserID] = &model.Balance{
+			TenantID: event.TenantID, UserID: event.FromUserID, Currency: event.AmountCurrency(), Status: model.AccountStatusActive,
+		}
+	case model.EventTypeDeposit:
+		r.depositTypes[event.ID] = event.Type
+		r.credit(event, event.FromUserID, *event.Amount)
+	case model.EventTypeWithdraw:
+		r.depositTypes[event.ID] = event.Type
+		r.debitWithFee(event)
+	case model.EventTypeTransfer:
+		r.debitWithFee(event)
+		r.credit(event, *event.ToUserID, *event.Amount)
+	case model.EventTypeBatchTransfer:
+		r.applyLegs(event)
+	case model.EventTypeReversal:
+		r.applyReversal(event)
+	case model.EventTypeFreeze:
+		r.changeStatus(event, model.AccountStatusActive, model.AccountStatusFrozen)
+	case model.EventTypeUnfreeze:
+		r.changeStatus(event, model.AccountStatusFrozen, model.AccountStatusActive)
+	case model.EventTypeClose:
+		if b := r.account(event, event.FromUserID); b.Balance != 0 {
+			r.violate(event, b.UserID, model.ErrNonZeroBalance)
+		}
+		r.changeStatus(event, model.AccountStatusActive, model.AccountStatusClosed)
+	case model.EventTypeCreditLimit:
+		b := r.account(event, event.FromUserID)
+		if err := b.CheckCreditLimit(*event.Amount); err != nil {
+			r.violate(event, b.UserID, err)
+		}
+		b.CreditLimit = *event.Amount
+	}
+}
+
+// Balances returns replayed balances ordered by user id.
+func (r *Replayer) Balances() []model.Balance {
+	balances := make([]model.Balance, 0, len(r.balances))
+	for _, b := range r.balances {
+		balances = append(balances, *b)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].UserID < balances[j].UserID })
+	return balances
+}
+
+// Report compares replayed balances with live ones.
+func (r *Replayer) Report(live []model.Balance) Report {
+	return Report{
+		Events:     r.events,
+		Accounts:   len(r.balances),
+		Violations: r.violations,
+		Diffs:      Compare(live, r.Balances()),
+	}
+}
+
+// Compare returns accounts that differ in live and replayed balances ordered by user id.
+func Compare(live, replayed []model.Balance) []Diff {
+	byUser := make(map[int64]*Diff)
+	for i := range live {
+		byUser[live[i].UserID] = &Diff{UserID: live[i].UserID, Live: &live[i]}
+	}
+	for i := range replayed {
+		d, ok := byUser[replayed[i].UserID]
+		if !ok {
+			d = &Diff{UserID: replayed[i].UserID}
+			byUser[d.UserID] = d
+		}
+		d.Replayed = &replayed[i]
+	}
+
+	var diffs []Diff
+	for _, d := range byUser {
+		if d.Live == nil || d.Replayed == nil || *d.Live != *d.Replayed {
+			diffs = append(diffs, *d)
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].UserID < diffs[j].UserID })
+	return diffs
+}
+
+// account returns balance of the user, missing account is created so that the rest of history can be applied.
+func (r *Replayer) account(event model.Event, userID int64) *model.Balance {
+	b, ok := r.balances[userID]
+	if !ok {
+		r.violate(event, userID, model.ErrUserNotFound)
+		b = &model.Balance{TenantID: event.TenantID, UserID: userID, Currency: event.AmountCurrency(), Status: model.AccountStatusActive}
+		r.balances[userID] = b
+	}
+	return b
+}
+
+// debitWithFee applies withdrawal or transfer debit of the payer and credits fee to the fee account.
+func (r *Replayer) debitWithFee(event model.Event) {
+	feeAccountID, fee := event.FeeCredit(r.feeAccountID)
+	debit, err := model.DebitWithFee(*event.Amount, fee)
+	if err != nil {
+		r.violate(event, event.FromUserID, err)
+		return
+	}
+	r.debit(event, event.FromUserID, debit)
+	if fee != 0 {
+		b := r.account(event, feeAccountID)
+		balance, err := b.AfterCredit(fee)
+		r.update(event, b, balance, err)
+	}
+}
+
+func (r *Replayer) debit(event model.Event, userID, amount int64) {
+	b := r.account(event, userID)
+	if err := b.CheckDebit(amount); err != nil {
+		r.violate(event, userID, err)
+	}
+	balance, err := b.AfterDebit(amount)
+	r.update(event, b, balance, err)
+}
+
+func (r *Replayer) credit(event model.Event, userID, amount int64) {
+	b := r.account(event, userID)
+	if err := b.CheckCredit(); err != nil {
+		r.violate(event, userID, err)
+	}
+	balance, err := b.AfterCredit(amount)
+	r.update(event, b, balance, err)
+}
+
+// update sets new balance, change that overflows or is in another currency is recorded as violation and skipped.
+func (r *Replayer) update(event model.Event, b *model.Balance, balance int64, err error) {
+	if err == nil {
+		err = model.CheckCurrency(event.AmountCurrency(), *b)
+	}
+	if err != nil {
+		r.violate(event, b.UserID, err)
+		return
+	}
+	b.Balance = balance
+}
+
+// applyLegs checks every account of batch transfer the same way service does and applies its net change.
+func (r *Replayer) applyLegs(event model.Event) {
+	var userIDs []int64
+	seen := make(map[int64]bool)
+	for _, leg := range event.Legs {
+		if !seen[leg.UserID] {
+			userIDs = append(userIDs, leg.UserID)
+			seen[leg.UserID] = true
+		}
+	}
+
+	for _, userID := range userIDs {
+		b := r.account(event, userID)
+		debit, credit := model.LegTotals(userID, event.Legs)
+		if err := b.CheckLegs(debit, credit); err != nil {
+			r.violate(event, userID, err)
+		}
+		// net of legs is bounded by the batch total
+		balance, err := b.AfterCredit(credit - debit)
+		r.update(event, b, balance, err)
+	}
+}
+
+func (r *Replayer) applyReversal(event model.Event) {
+	amount := *event.Amount
+	switch {
+	case event.ToUserID != nil:
+		r.credit(event, event.FromUserID, amount)
+		r.reverseDebit(event, *event.ToUserID, amount)
+	case r.depositTypes[*event.ReversedEventID] == model.EventTypeDeposit:
+		r.reverseDebit(event, event.FromUserID, amount)
+	case r.depositTypes[*event.ReversedEventID] == model.EventTypeWithdraw:
+		r.credit(event, event.FromUserID, amount)
+	default:
+		r.violate(event, event.FromUserID, fmt.Errorf("%w: event %d", model.ErrEventNotFound, *event.ReversedEventID))
+	}
+}
+
+func (r *Replayer) reverseDebit(event model.Event, userID, amount int64) {
+	b := r.account(event, userID)
+	if err := b.CheckReverseDebit(amount); err != nil {
+		r.violate(event, userID, err)
+	}
+	balance, err := b.AfterDebit(amount)
+	r.update(event, b, balance, err)
+}
+
+func (r *Replayer) changeStatus(event model.Event, from, to model.AccountStatus) {
+	b := r.account(event, event.FromUserID)
+	if b.Status != from {
+		r.violate(event, b.UserID, fmt.Errorf("%w: account is %s", model.ErrWrongAccountStatus, b.Status))
+	}
+	b.Status = to
+}
+
+func (r *Replayer) violate(event model.Event, userID int64, err error) {
+	r.violations = append(r.violations, Violation{
+		EventID:   event.ID,
+		EventType: event.Type,
+		UserID:    userID,
+		Error:     err.Error(),
+	})
+}
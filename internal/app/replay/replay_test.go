@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func TestReplayer(t *testing.T) {
+	const feeAccountID = 100
+	r := New(feeAccountID)
+
+	events := []model.Event{
+		{ID: 1, Type: model.EventTypeOpen, FromUserID: feeAccountID},
+		{ID: 2, Type: model.EventTypeOpen, FromUserID: 1},
+		{ID: 3, Type: model.EventTypeOpen, FromUserID: 2},
+		{ID: 4, Type: model.EventTypeDeposit, FromUserID: 1, Amount: int64Ptr(100)},
+		{ID: 5, Type: model.EventTypeTransfer, FromUserID: 1, ToUserID: int64Ptr(2), Amount: int64Ptr(40), Fee: int64Ptr(2)},
+		{ID: 6, Type: model.EventTypeReversal, FromUserID: 1, ToUserID: int64Ptr(2), Amount: int64Ptr(10), ReversedEventID: int64Ptr(5)},
+		{ID: 7, Type: model.EventTypeWithdraw, FromUserID: 2, Amount: int64Ptr(50)},
+		{ID: 8, Type: model.EventTypeBatchTransfer, FromUserID: 1, Amount: int64Ptr(20), Legs: []*model.EventLeg{
+			{UserID: 1, Amount: -20},
+			{UserID: 2, Amount: 20},
+		}},
+		{ID: 9, Type: model.EventTypeReversal, FromUserID: 1, Amount: int64Ptr(8), ReversedEventID: int64Ptr(4)},
+		{ID: 10, Type: model.EventTypeFreeze, FromUserID: 2},
+		{ID: 11, Type: model.EventTypeCreditLimit, FromUserID: 2, Amount: int64Ptr(30)},
+		{ID: 12, Type: model.EventTypeClose, FromUserID: 1},
+	}
+	for _, event := range events {
+		r.Apply(event)
+	}
+
+	active, frozen, closed := model.AccountStatusActive, model.AccountStatusFrozen, model.AccountStatusClosed
+	rub := model.DefaultCurrency.Code
+	require.Equal(t, []model.Balance{
+		{UserID: 1, Balance: 40, Currency: rub, Status: closed},
+		{UserID: 2, Balance: 0, Currency: rub, Status: frozen, CreditLimit: 30},
+		{UserID: feeAccountID, Balance: 2, Currency: rub, Status: active},
+	}, r.Balances())
+
+	report := r.Report([]model.Balance{
+		{UserID: 1, Balance: 40, Currency: rub, Status: closed},
+		{UserID: 2, Balance: 10, Currency: rub, Status: frozen, CreditLimit: 30},
+		{UserID: 3, Balance: 0, Currency: rub, Status: active},
+	})
+	require.Equal(t, 12, report.Events)
+	require.Equal(t, []Violation{
+		{EventID: 7, EventType: model.EventTypeWithdraw, UserID: 2, Error: model.ErrNegativeBalance.Error()},
+		{EventID: 12, EventType: model.EventTypeClose, UserID: 1, Error: model.ErrNonZeroBalance.Error()},
+	}, report.Violations)
+
+	require.Len(t, report.Diffs, 3)
+	require.EqualValues(t, 2, report.Diffs[0].UserID)
+	require.EqualValues(t, 3, report.Diffs[1].UserID)
+	require.Nil(t, report.Diffs[1].Replayed)
+	require.EqualValues(t, feeAccountID, report.Diffs[2].UserID)
+	require.Nil(t, report.Diffs[2].Live)
+}
+
+func TestReplayerCreditsFeeToFeeAccountOfEvent(t *testing.T) {
+	const oldFeeAccountID, feeAccountID = 100, 200
+	r := New(feeAccountID)
+
+	for _, event := range []model.Event{
+		{ID: 1, Type: model.EventTypeOpen, FromUserID: oldFeeAccountID},
+		{ID: 2, Type: model.EventTypeOpen, FromUserID: feeAccountID},
+		{ID: 3, Type: model.EventTypeOpen, FromUserID: 1},
+		{ID: 4, Type: model.EventTypeDeposit, FromUserID: 1, Amount: int64Ptr(100)},
+		{ID: 5, Type: model.EventTypeWithdraw, FromUserID: 1, Amount: int64Ptr(10), Fee: int64Ptr(1), FeeAccountID: int64Ptr(oldFeeAccountID)},
+		{ID: 6, Type: model.EventTypeWithdraw, FromUserID: 1, Amount: int64Ptr(10), Fee: int64Ptr(2), FeeAccountID: int64Ptr(feeAccountID)},
+	} {
+		r.Apply(event)
+	}
+
+	active, rub := model.AccountStatusActive, model.DefaultCurrency.Code
+	require.Equal(t, []model.Balance{
+		{UserID: 1, Balance: 77, Currency: rub, Status: active},
+		{UserID: oldFeeAccountID, Balance: 1, Currency: rub, Status: active},
+		{UserID: feeAccountID, Balance: 2, Currency: rub, Status: active},
+	}, r.Balances())
+}
+
+func TestReplayerSkipsOverflow(t *testing.T) {
+	r := New(0)
+	r.Apply(model.Event{ID: 1, Type: model.EventTypeOpen, FromUserID: 1})
+	r.Apply(model.Event{ID: 2, Type: model.EventTypeDeposit, FromUserID: 1, Amount: int64Ptr(math.MaxInt64)})
+	r.Apply(model.Event{ID: 3, Type: model.EventTypeDeposit, FromUserID: 1, Amount: int64Ptr(1)})
+
+	require.Equal(t, []model.Balance{
+		{UserID: 1, Balance: math.MaxInt64, Currency: model.DefaultCurrency.Code, Status: model.AccountStatusActive},
+	}, r.Balances())
+	report := r.Report(nil)
+	require.Len(t, report.Violations, 1)
+	require.EqualValues(t, 3, report.Violations[0].EventID)
+	require.Contains(t, report.Violations[0].Error, money.ErrOverflow.Error())
+}
+
+func TestReplayerSkipsOtherCurrency(t *testing.T) {
+	r := New(0)
+	usd := money.USD.Code
+	r.Apply(model.Event{ID: 1, Type: model.EventTypeOpen, FromUserID: 1, Currency: usd})
+	r.Apply(model.Event{ID: 2, Type: model.EventTypeOpen, FromUserID: 2})
+	r.Apply(model.Event{ID: 3, Type: model.EventTypeDeposit, FromUserID: 1, Amount: int64Ptr(100), Currency: usd})
+	// transfer between accounts of different currencies, and deposit made before currencies
+	r.Apply(model.Event{ID: 4, Type: model.EventTypeTransfer, FromUserID: 1, ToUserID: int64Ptr(2), Amount: int64Ptr(10), Currency: usd})
+	r.Apply(model.Event{ID: 5, Type: model.EventTypeDeposit, FromUserID: 1, Amount: int64Ptr(5)})
+
+	balances := r.Balances()
+	require.EqualValues(t, 90, balances[0].Balance)
+	require.Equal(t, usd, balances[0].Currency)
+	require.EqualValues(t, 0, balances[1].Balance)
+	require.Equal(t, model.DefaultCurrency.Code, balances[1].Currency)
+
+	report := r.Report(nil)
+	require.Len(t, report.Violations, 2)
+	require.EqualValues(t, 4, report.Violations[0].EventID)
+	require.EqualValues(t, 2, report.Violations[0].UserID)
+	require.EqualValues(t, 5, report.Violations[1].EventID)
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-pg/pg/v10"
@@ -8,13 +10,57 @@ import (
 	"github.com/itimofeev/simple-billing/internal/app/model"
 )
 
-func (r *Repository) AddEvent(tx pg.DBI, event *model.Event) (*model.Event, error) {
+// AddEvent saves event of the tenant, if tx is started with context of a command the event gets its id,
+// and id of the scheduled command if it is executed.
+func (r *Repository) AddEvent(tx pg.DBI, tenantID string, event *model.Event) (*model.Event, error) {
+	if tx, ok := tx.(*pg.Tx); ok {
+		if event.CommandID == nil {
+			event.CommandID = model.CommandIDFromContext(tx.Context())
+		}
+		if event.ScheduledCommandID == nil {
+			event.ScheduledCommandID = model.ScheduledCommandIDFromContext(tx.Context())
+		}
+	}
+	event.TenantID = tenantID
 	_, err := tx.Model(event).Returning("*").Insert()
 	return event, err
 }
 
-func (r *Repository) ListEventsByFromUserID(tx pg.DBI, userID int64) (events []model.Event, err error) {
-	return events, tx.Model(&events).Where("from_user_id = ?", userID).Select() // nolint:gocritic
+// AddEventLegs saves legs of batch transfer event, legs are numbered in the given order.
+func (r *Repository) AddEventLegs(tx pg.DBI, tenantID string, eventID int64, legs []*model.EventLeg) error {
+	for i, leg := range legs {
+		leg.EventID = eventID
+		leg.Number = i
+		leg.TenantID = tenantID
+	}
+	_, err := tx.Model(&legs).Insert()
+	return err
+}
+
+func (r *Repository) GetEvent(tx pg.DBI, tenantID string, eventID int64, withLock bool) (event model.Event, err error) {
+	query := tx.Model(&event).Where("tenant_id = ?", tenantID).Where("id = ?", eventID)
+	if withLock {
+		query = query.For("UPDATE")
+	}
+	if err := query.Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			err = model.ErrEventNotFound
+		}
+		return model.Event{}, fmt.Errorf("[postgres] error on getting event: %w", err)
+	}
+	return event, nil
+}
+
+// GetReversedAmount returns how much of the event was already reversed.
+func (r *Repository) GetReversedAmount(tx pg.DBI, tenantID string, eventID int64) (amount int64, err error) {
+	_, err = tx.QueryOne(pg.Scan(&amount), `
+SELECT coalesce(sum(amount), 0) FROM events WHERE tenant_id = ? AND reversed_event_id = ? AND type = ?`,
+		tenantID, eventID, model.EventTypeReversal)
+	return amount, err
+}
+
+func (r *Repository) ListEventsByFromUserID(tx pg.DBI, tenantID string, userID int64) (events []model.Event, err error) {
+	return events, tx.Model(&events).Where("tenant_id = ?", tenantID).Where("from_user_id = ?", userID).Order("id").Select() // nolint:gocritic
 }
 
 func (r *Repository) SetMessageID(tx pg.DBI, event *model.Event, messageID string) error {
@@ -29,3 +75,61 @@ func (r *Repository) SetMessageSent(tx pg.DBI, messageID string, now time.Time)
 		Update()
 	return err
 }
+
+// ListUnsentEvents returns events of all tenants, every event is published to the subjects of its tenant.
+func (r *Repository) ListUnsentEvents(tx pg.DBI, afterID int64, createdBefore time.Time, limit int) (events []model.Event, err error) {
+	return events, tx.Model(&events).
+		Relation("Legs").
+		Where("queue_sent_time IS NULL").
+		Where("id > ?", afterID).
+		Where("created_time < ?", createdBefore).
+		Order("id").
+		Limit(limit).
+		Select()
+}
+
+// ClaimUnsentEvents is ListUnsentEvents that locks returned events till the end of tx and skips events
+// locked by others, so workers flushing outbox at the same time don't publish the same events.
+func (r *Repository) ClaimUnsentEvents(tx pg.DBI, afterID int64, createdBefore time.Time, limit int) (events []model.Event, err error) {
+	err = tx.Model(&events).
+		Relation("Legs").
+		Where("queue_sent_time IS NULL").
+		Where("id > ?", afterID).
+		Where("created_time < ?", createdBefore).
+		Order("id").
+		Limit(limit).
+		For("UPDATE SKIP LOCKED").
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on claiming unsent events: %w", err)
+	}
+	return events, nil
+}
+
+// SetEventSent marks event as acked by NATS.
+func (r *Repository) SetEventSent(tx pg.DBI, event *model.Event, now time.Time) error {
+	_, err := tx.Model(event).Set("queue_sent_time = ?", now).WherePK().Update()
+	if err != nil {
+		return fmt.Errorf("[postgres] error on marking event sent: %w", err)
+	}
+	return nil
+}
+
+// ListUserEvents returns up to limit latest events the user took part in as sender, recipient or batch transfer leg.
+func (r *Repository) ListUserEvents(tx pg.DBI, tenantID string, userID int64, limit int) (events []model.Event, err error) {
+	err = tx.Model(&events).
+		Relation("Legs").
+		Where("tenant_id = ?", tenantID).
+		WhereGroup(func(q *pg.Query) (*pg.Query, error) {
+			return q.WhereOr("from_user_id = ?", userID).
+				WhereOr("to_user_id = ?", userID).
+				WhereOr("id IN (SELECT event_id FROM event_legs WHERE tenant_id = ? AND user_id = ?)", tenantID, userID), nil
+		}).
+		Order("id DESC").
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on listing user events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// GetCurrentFeeRule returns the latest version of fee rule for the operation, nil if there is none.
+func (r *Repository) GetCurrentFeeRule(tx pg.DBI, tenantID string, operation model.EventType) (*model.FeeRule, error) {
+	rule := &model.FeeRule{}
+	err := tx.Model(rule).Where("tenant_id = ?", tenantID).Where("operation = ?", operation).Order("id DESC").Limit(1).Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("[postgres] error on getting fee rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (r *Repository) AddFeeRule(tx pg.DBI, tenantID string, rule *model.FeeRule) (*model.FeeRule, error) {
+	rule.TenantID = tenantID
+	_, err := tx.Model(rule).Returning("*").Insert()
+	return rule, err
+}
+
+// AddFeeEntry credits fee to the fee account without locking the account row.
+func (r *Repository) AddFeeEntry(tx pg.DBI, tenantID string, entry *model.FeeEntry) error {
+	entry.TenantID = tenantID
+	if _, err := tx.Model(entry).Insert(); err != nil {
+		return fmt.Errorf("[postgres] error on adding fee entry: %w", err)
+	}
+	return nil
+}
+
+// balanceWithFeesExpr is the balance including fee entries that were not settled into it yet.
+const balanceWithFeesExpr = `?TableAlias.balance + (
+    SELECT coalesce(sum(f.amount), 0)
+    FROM fee_entries f
+    WHERE f.tenant_id = ?TableAlias.tenant_id AND f.account_id = ?TableAlias.id) AS balance`
+
+// settleFeeEntries adds fee entries of the account to its balance and deletes them. It is done in one
+// statement, so entries committed meanwhile stay unsettled and are not counted twice.
+func settleFeeEntries(tx pg.DBI, tenantID string, userID int64) error {
+	_, err := tx.Exec(`
+WITH settled AS (
+    DELETE FROM fee_entries WHERE tenant_id = ?0 AND account_id = ?1 RETURNING amount
+), total AS (
+    SELECT sum(amount) AS amount FROM settled
+)
+UPDATE balances b
+SET balance = b.balance + total.amount
+FROM total
+WHERE total.amount IS NOT NULL
+  AND b.tenant_id = ?0
+  AND b.id = ?1`, tenantID, userID)
+	if err != nil {
+		return fmt.Errorf("[postgres] error on settling fee entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// GetAccountLimits returns zero limits if they were never set for the account.
+func (r *Repository) GetAccountLimits(tx pg.DBI, tenantID string, userID int64) (model.Limits, error) {
+	limits := model.AccountLimits{TenantID: tenantID, UserID: userID}
+	if err := tx.Model(&limits).WherePK().Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return model.Limits{}, nil
+		}
+		return model.Limits{}, fmt.Errorf("[postgres] error on getting account limits: %w", err)
+	}
+	return limits.Limits, nil
+}
+
+func (r *Repository) SetAccountLimits(tx pg.DBI, tenantID string, userID int64, limits model.Limits) error {
+	_, err := tx.Model(&model.AccountLimits{TenantID: tenantID, UserID: userID, Limits: limits}).
+		OnConflict("(tenant_id, user_id) DO UPDATE").
+		Set("max_amount = EXCLUDED.max_amount").
+		Set("max_daily_outgoing = EXCLUDED.max_daily_outgoing").
+		Set("max_monthly_outgoing = EXCLUDED.max_monthly_outgoing").
+		Set("max_hourly_operations = EXCLUDED.max_hourly_operations").
+		Insert()
+	return err
+}
+
+// GetOutgoingUsage sums withdrawals, transfers and batch transfer debits of the account in limit windows ending at now.
+// Balance row of the account must be locked by tx, so that concurrent operations are counted.
+func (r *Repository) GetOutgoingUsage(tx pg.DBI, tenantID string, userID int64, now time.Time) (usage model.OutgoingUsage, err error) {
+	dayAgo, hourAgo, monthAgo := now.Add(-24*time.Hour), now.Add(-time.Hour), now.AddDate(0, 0, -30)
+	_, err = tx.QueryOne(pg.Scan(&usage.Daily, &usage.Monthly, &usage.HourlyOperations), `
+SELECT coalesce(sum(amount) FILTER (WHERE created_time >= ?), 0),
+       coalesce(sum(amount), 0),
+       count(*) FILTER (WHERE created_time >= ?)
+FROM (SELECT amount, created_time
+      FROM events
+      WHERE tenant_id = ?
+        AND from_user_id = ?
+        AND type IN (?, ?)
+        AND created_time >= ?
+      UNION ALL
+      SELECT -l.amount, e.created_time
+      FROM event_legs l
+               JOIN events e ON e.id = l.event_id
+      WHERE l.tenant_id = ?
+        AND l.user_id = ?
+        AND l.amount < 0
+        AND e.created_time >= ?) outgoing`,
+		dayAgo, hourAgo,
+		tenantID, userID, model.EventTypeWithdraw, model.EventTypeTransfer, monthAgo,
+		tenantID, userID, monthAgo)
+	if err != nil {
+		return usage, fmt.Errorf("[postgres] error on getting outgoing usage: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// commandShardLockSpace is the first key of two-key advisory locks used for command shards,
+// so they can't clash with other advisory locks in the same database.
+const commandShardLockSpace = 1001
+
+// ShardLocks holds session level advisory locks on a dedicated connection.
+// Locks are released when connection is closed, also when the worker dies.
+type ShardLocks struct {
+	conn *pg.Conn
+}
+
+func (r *Repository) NewShardLocks() *ShardLocks {
+	return &ShardLocks{conn: r.db.Conn()}
+}
+
+// TryLock returns true if lock for the shard is taken by this connection.
+func (l *ShardLocks) TryLock(ctx context.Context, shard int) (locked bool, err error) {
+	_, err = l.conn.QueryOneContext(ctx, pg.Scan(&locked), "SELECT pg_try_advisory_lock(?, ?)", commandShardLockSpace, shard)
+	return locked, err
+}
+
+// Held returns number of shard locks held by the connection. If connection was re-established
+// the locks are lost and may be already taken by someone else.
+func (l *ShardLocks) Held(ctx context.Context) (held int, err error) {
+	_, err = l.conn.QueryOneContext(ctx, pg.Scan(&held), `
+SELECT count(*)
+FROM pg_locks
+WHERE locktype = 'advisory' AND classid = ? AND pid = pg_backend_pid() AND granted`, commandShardLockSpace)
+	return held, err
+}
+
+func (l *ShardLocks) Close() error {
+	return l.conn.Close()
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// AddMandate saves mandate of the tenant, if tx is started with context of a command the mandate gets its producer.
+func (r *Repository) AddMandate(tx pg.DBI, tenantID string, mandate *model.Mandate) (*model.Mandate, error) {
+	if tx, ok := tx.(*pg.Tx); ok && mandate.Producer == nil {
+		mandate.Producer = model.ProducerFromContext(tx.Context())
+	}
+	mandate.TenantID = tenantID
+	_, err := tx.Model(mandate).Returning("*").Insert()
+	return mandate, err
+}
+
+func (r *Repository) GetMandate(tx pg.DBI, tenantID string, id int64, withLock bool) (mandate model.Mandate, err error) {
+	query := tx.Model(&mandate).Where("tenant_id = ?", tenantID).Where("id = ?", id)
+	if withLock {
+		query = query.For("UPDATE")
+	}
+	if err := query.Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			err = model.ErrMandateNotFound
+		}
+		return model.Mandate{}, fmt.Errorf("[postgres] error on getting mandate: %w", err)
+	}
+	return mandate, nil
+}
+
+func (r *Repository) UpdateMandate(tx pg.DBI, mandate *model.Mandate) error {
+	_, err := tx.Model(mandate).Column("status", "next_run_time", "failures", "last_error").WherePK().Where("tenant_id = ?tenant_id").Update()
+	return err
+}
+
+// LockDueMandate locks one active mandate of any tenant with next_run_time before now, nil is returned if there is none.
+// Only mandates of payers whose command shard is one of shards are locked, mandates locked by other workers are skipped.
+func (r *Repository) LockDueMandate(tx pg.DBI, now time.Time, shards []int, shardsCount int) (*model.Mandate, error) {
+	mandate := &model.Mandate{}
+	// shard is uint64(from_user_id) % shardsCount like in queue.CommandShard
+	err := tx.Model(mandate).
+		Where("status = ?", model.MandateStatusActive).
+		Where("next_run_time <= ?", now).
+		Where("mod(from_user_id::NUMERIC + CASE WHEN from_user_id < 0 THEN 18446744073709551616 ELSE 0 END, ?) = ANY (?)",
+			shardsCount, pg.Array(shards)).
+		Order("next_run_time", "id").
+		Limit(1).
+		For("UPDATE SKIP LOCKED").
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on locking due mandate: %w", err)
+	}
+	return mandate, nil
+}
+
+// Savepoint and RollbackToSavepoint allow to continue transaction after part of it failed.
+func (r *Repository) Savepoint(tx pg.DBI, name string) error {
+	_, err := tx.Exec("SAVEPOINT ?", pg.Ident(name))
+	return err
+}
+
+func (r *Repository) RollbackToSavepoint(tx pg.DBI, name string) error {
+	_, err := tx.Exec("ROLLBACK TO SAVEPOINT ?", pg.Ident(name))
+	return err
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// RememberMessage saves received command, it returns false if a message of the tenant with the same producer
+// and id was received before as another NATS message.
+func (r *Repository) RememberMessage(ctx context.Context, msg model.ReceivedMessage) (bool, error) {
+	saved := model.ReceivedMessage{}
+	// conflicting row is updated to itself, so that it is returned
+	_, err := r.GetDB(ctx).QueryOne(&saved, `
+INSERT INTO received_messages (tenant_id, producer, message_id, subject, sequence, expires_time)
+VALUES (?tenant_id, ?producer, ?message_id, ?subject, ?sequence, ?expires_time)
+ON CONFLICT (tenant_id, producer, message_id) DO UPDATE
+    SET expires_time = received_messages.expires_time
+RETURNING *`, &msg)
+	if err != nil {
+		return false, fmt.Errorf("[postgres] error on remembering received message: %w", err)
+	}
+	return saved.Subject == msg.Subject && saved.Sequence == msg.Sequence, nil
+}
+
+// DeleteExpiredMessages forgets received commands that expired before now.
+func (r *Repository) DeleteExpiredMessages(ctx context.Context, now time.Time) (int, error) {
+	res, err := r.GetDB(ctx).Model((*model.ReceivedMessage)(nil)).Where("expires_time < ?", now).Delete()
+	if err != nil {
+		return 0, fmt.Errorf("[postgres] error on deleting expired messages: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
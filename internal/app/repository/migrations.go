@@ -1,75 +1,155 @@
 package repository
 
 import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"net/http"
+
 	"github.com/go-pg/migrations/v8"
 	"github.com/go-pg/pg/v10"
 )
 
-func doMigrationIfNeeded(db *pg.DB) error {
-	col := getMigrations()
-	_, _, _ = col.Run(db, "init") // nolint:dogsled
-	_, _, err := col.Run(db, "up")
-	return err
-}
+//go:embed migrations/*.sql
+var migrationFiles embed.FS // nolint:gochecknoglobals
 
-func getMigrations() *migrations.Collection {
-	sqls := getSQLs()
+// migrationsTable stores applied migrations.
+const migrationsTable = "gopg_migrations"
 
-	migs := make([]*migrations.Migration, 0, len(sqls))
+// migrationsLockKey is the advisory lock taken while migrations run, so that concurrent runs wait for each other.
+const migrationsLockKey = 1000
 
-	for i, sql := range sqls {
-		sql := sql
-		migs = append(migs, &migrations.Migration{
-			Version: int64(i + 1),
-			UpTx:    true,
-			Up: func(db migrations.DB) error {
-				_, err := db.Exec(sql)
-				return err
-			},
-		})
-	}
+var ErrMigrationsPending = errors.New("database schema is not up to date, run cmd/migrate up")
 
-	return migrations.NewCollection(migs...)
+// MigrationStatus is a migration known to this binary and whether it is applied to the database.
+type MigrationStatus struct {
+	Version int64
+	Applied bool
 }
 
-func getSQLs() []string {
-	return []string{
-		`
-DROP TABLE IF EXISTS balances;
+// Migrate runs go-pg/migrations command ("up", "down", "version", ...) holding migrations lock.
+func (r *Repository) Migrate(ctx context.Context, args ...string) (oldVersion, newVersion int64, err error) {
+	err = r.withMigrationsLock(ctx, true, func(conn *pg.Conn, col *migrations.Collection) error {
+		oldVersion, newVersion, err = col.Run(conn, args...)
+		return err
+	})
+	return oldVersion, newVersion, err
+}
 
-CREATE TABLE balances
-(
-    id      BIGSERIAL NOT NULL PRIMARY KEY,
-    balance BIGINT NOT NULL,
-    CHECK ( balance >= 0 )
-);
-`,
+// MigrateTo applies or rolls back migrations one by one until schema has the given version.
+func (r *Repository) MigrateTo(ctx context.Context, version int64) (oldVersion, newVersion int64, err error) {
+	err = r.withMigrationsLock(ctx, true, func(conn *pg.Conn, col *migrations.Collection) error {
+		if oldVersion, err = col.Version(conn); err != nil {
+			return err
+		}
+		newVersion = oldVersion
+		if version >= oldVersion {
+			_, newVersion, err = col.Run(conn, "up", fmt.Sprint(version))
+			return err
+		}
+		for newVersion > version {
+			prev := newVersion
+			if _, newVersion, err = col.Run(conn, "down"); err != nil {
+				return err
+			}
+			if newVersion == prev {
+				return fmt.Errorf("can't roll back migration %d", prev)
+			}
+		}
+		return nil
+	})
+	return oldVersion, newVersion, err
+}
+
+// MigrationsStatus returns all migrations known to this binary. It doesn't change the database,
+// without migrations table no migration is applied.
+func (r *Repository) MigrationsStatus(ctx context.Context) (version int64, statuses []MigrationStatus, err error) {
+	err = r.withMigrationsLock(ctx, false, func(conn *pg.Conn, col *migrations.Collection) error {
+		exists, err := migrationsTableExists(conn)
+		if err != nil || !exists {
+			statuses = migrationsStatus(col, 0)
+			return err
+		}
+		if version, err = col.Version(conn); err != nil {
+			return err
+		}
+		statuses = migrationsStatus(col, version)
+		return nil
+	})
+	return version, statuses, err
+}
 
-		`DROP TABLE IF EXISTS events;
+// CheckMigrations returns ErrMigrationsPending if migrations table is missing or some migration
+// known to this binary is not applied. It doesn't change the database.
+func (r *Repository) CheckMigrations(ctx context.Context) error {
+	return r.withMigrationsLock(ctx, false, func(conn *pg.Conn, col *migrations.Collection) error {
+		exists, err := migrationsTableExists(conn)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: table %s is missing", ErrMigrationsPending, migrationsTable)
+		}
+		version, err := col.Version(conn)
+		if err != nil {
+			return err
+		}
+		for _, status := range migrationsStatus(col, version) {
+			if !status.Applied {
+				return fmt.Errorf("%w: migration %d", ErrMigrationsPending, status.Version)
+			}
+		}
+		return nil
+	})
+}
 
-CREATE TABLE events
-(
-    id              BIGSERIAL PRIMARY KEY      NOT NULL,
+func migrationsStatus(col *migrations.Collection, version int64) []MigrationStatus {
+	statuses := make([]MigrationStatus, 0, len(col.Migrations()))
+	for _, m := range col.Migrations() {
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Applied: m.Version <= version})
+	}
+	return statuses
+}
 
-    type            VARCHAR(32)                NOT NULL,
+func migrationsTableExists(conn *pg.Conn) (bool, error) {
+	var exists bool
+	if _, err := conn.QueryOne(pg.Scan(&exists), "SELECT to_regclass(?) IS NOT NULL", migrationsTable); err != nil {
+		return false, fmt.Errorf("[postgres] error on looking up migrations table: %w", err)
+	}
+	return exists, nil
+}
 
-    from_user_id    BIGINT REFERENCES balances NOT NULL,
-    to_user_id      BIGINT REFERENCES balances,
-    amount          BIGINT,
+// withMigrationsLock calls f holding migrations lock. With create set migrations table is created if missing,
+// read-only callers must not set it.
+func (r *Repository) withMigrationsLock(
+	ctx context.Context, create bool, f func(conn *pg.Conn, col *migrations.Collection) error,
+) error {
+	col, err := getMigrations()
+	if err != nil {
+		return err
+	}
 
-    created_time    timestamptz                NOT NULL,
+	conn := r.db.Conn().WithContext(ctx)
+	defer conn.Close()
 
-    queue_id        VARCHAR(128) UNIQUE        NOT NULL,
-    queue_sent_time timestamptz,
+	if _, err := conn.Exec("SELECT pg_advisory_lock(?)", migrationsLockKey); err != nil {
+		return fmt.Errorf("[postgres] error on taking migrations lock: %w", err)
+	}
+	defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationsLockKey) // nolint:errcheck
 
-    CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer')),
-    CHECK (type = 'transfer' OR to_user_id IS NULL),
-    CHECK (type <> 'transfer' OR to_user_id IS NOT NULL),
-    CHECK (type = 'open' OR amount IS NOT NULL),
-    CHECK (type <> 'open' OR amount IS NULL)
-);
+	if create {
+		if _, _, err := col.Run(conn, "init"); err != nil {
+			return fmt.Errorf("[postgres] error on creating migrations table: %w", err)
+		}
+	}
+	return f(conn, col)
+}
 
-CREATE UNIQUE INDEX events__queue_id__idx ON events (queue_id);
-`,
+func getMigrations() (*migrations.Collection, error) {
+	col := migrations.NewCollection().SetTableName(migrationsTable).DisableSQLAutodiscover(true)
+	if err := col.DiscoverSQLMigrationsFromFilesystem(http.FS(migrationFiles), "migrations"); err != nil {
+		return nil, err
 	}
+	return col, nil
 }
@@ -0,0 +1,21 @@
+DELETE FROM events
+WHERE type IN ('mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed', 'mandate_cancelled');
+
+ALTER TABLE events
+    DROP CONSTRAINT events__mandate_id__check,
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    DROP CONSTRAINT events__to_user_id__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft', 'reversal',
+                         'batch_transfer')) = (amount IS NOT NULL)),
+    ADD CONSTRAINT events__to_user_id__check
+        CHECK (type = 'reversal' OR (type = 'transfer') = (to_user_id IS NOT NULL)),
+    DROP COLUMN mandate_id,
+    DROP COLUMN error_code;
+
+DROP TABLE mandates;
@@ -0,0 +1,43 @@
+CREATE TABLE mandates
+(
+    id            BIGSERIAL PRIMARY KEY      NOT NULL,
+    from_user_id  BIGINT REFERENCES balances NOT NULL,
+    to_user_id    BIGINT REFERENCES balances NOT NULL,
+    amount        BIGINT                     NOT NULL,
+    schedule      VARCHAR(128)               NOT NULL,
+    end_time      timestamptz,
+    max_retries   INT                        NOT NULL,
+    status        VARCHAR(16)                NOT NULL,
+    next_run_time timestamptz                NOT NULL,
+    failures      INT                        NOT NULL,
+    last_error    TEXT,
+    created_time  timestamptz                NOT NULL,
+
+    CHECK (amount > 0),
+    CHECK (max_retries >= 0),
+    CHECK (status IN ('active', 'suspended', 'cancelled', 'finished'))
+);
+
+CREATE INDEX mandates__next_run_time__idx ON mandates (next_run_time) WHERE status = 'active';
+
+ALTER TABLE events
+    ADD COLUMN mandate_id BIGINT REFERENCES mandates,
+    ADD COLUMN error_code VARCHAR(64),
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    DROP CONSTRAINT events__to_user_id__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled',
+                        'mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed',
+                        'mandate_cancelled')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft', 'reversal',
+                         'batch_transfer', 'mandate_created', 'mandate_failed')) = (amount IS NOT NULL)),
+    ADD CONSTRAINT events__to_user_id__check
+        CHECK (type = 'reversal' OR (type IN ('transfer', 'mandate_created')) = (to_user_id IS NOT NULL)),
+    -- transfers made by mandate have mandate_id too
+    ADD CONSTRAINT events__mandate_id__check
+        CHECK (type NOT IN ('mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed',
+                            'mandate_cancelled') OR mandate_id IS NOT NULL);
@@ -0,0 +1,14 @@
+DELETE FROM events WHERE type = 'balance_mismatch';
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled',
+                        'mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed',
+                        'mandate_cancelled')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft', 'reversal',
+                         'batch_transfer', 'mandate_created', 'mandate_failed')) = (amount IS NOT NULL));
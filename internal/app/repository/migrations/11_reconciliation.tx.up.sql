@@ -0,0 +1,14 @@
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled',
+                        'mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed',
+                        'mandate_cancelled', 'balance_mismatch')),
+    -- balance_mismatch has difference between balance and sum of events in amount
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft', 'reversal',
+                         'batch_transfer', 'mandate_created', 'mandate_failed', 'balance_mismatch')) =
+               (amount IS NOT NULL));
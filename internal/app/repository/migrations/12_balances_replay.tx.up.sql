@@ -0,0 +1,8 @@
+-- balances rebuilt from events by cmd/replay, has no checks so that broken history can be saved and compared
+CREATE TABLE balances_replay
+(
+    id           BIGINT      NOT NULL PRIMARY KEY,
+    balance      BIGINT      NOT NULL,
+    status       VARCHAR(16) NOT NULL,
+    credit_limit BIGINT      NOT NULL
+);
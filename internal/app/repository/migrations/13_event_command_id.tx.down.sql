@@ -0,0 +1,2 @@
+ALTER TABLE events
+    DROP COLUMN command_id;
@@ -0,0 +1,7 @@
+-- token buckets of rate limits shared by all workers, see Repository.TakeToken
+CREATE TABLE rate_limit_buckets
+(
+    key          TEXT             NOT NULL PRIMARY KEY,
+    tokens       DOUBLE PRECISION NOT NULL,
+    updated_time TIMESTAMPTZ      NOT NULL
+);
@@ -0,0 +1,16 @@
+DELETE FROM events WHERE type IN ('review_held', 'review_approved', 'review_rejected');
+
+ALTER TABLE events
+    DROP CONSTRAINT events__review_id__check,
+    DROP COLUMN review_id,
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled',
+                        'mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed',
+                        'mandate_cancelled', 'balance_mismatch'));
+
+DROP INDEX events__to_user_id__created_time__idx;
+
+DROP TABLE reviews;
@@ -0,0 +1,31 @@
+CREATE TABLE reviews
+(
+    id           BIGSERIAL PRIMARY KEY      NOT NULL,
+    user_id      BIGINT REFERENCES balances NOT NULL,
+    command      JSONB                      NOT NULL,
+    rule         VARCHAR(64)                NOT NULL,
+    reason       TEXT                       NOT NULL,
+    status       VARCHAR(16)                NOT NULL,
+    created_time timestamptz                NOT NULL,
+    decided_time timestamptz,
+
+    CHECK (status IN ('pending', 'approved', 'rejected'))
+);
+
+CREATE INDEX reviews__created_time__idx ON reviews (created_time) WHERE status = 'pending';
+
+-- incoming transfers for screening rules
+CREATE INDEX events__to_user_id__created_time__idx ON events (to_user_id, created_time) WHERE to_user_id IS NOT NULL;
+
+ALTER TABLE events
+    ADD COLUMN review_id BIGINT REFERENCES reviews,
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled',
+                        'mandate_created', 'mandate_failed', 'mandate_suspended', 'mandate_resumed',
+                        'mandate_cancelled', 'balance_mismatch',
+                        'review_held', 'review_approved', 'review_rejected')),
+    ADD CONSTRAINT events__review_id__check
+        CHECK ((type IN ('review_held', 'review_approved', 'review_rejected')) = (review_id IS NOT NULL));
@@ -0,0 +1,52 @@
+-- fails if different tenants have accounts with the same user id
+DROP INDEX events__tenant_id__from_user_id__created_time__idx;
+DROP INDEX events__tenant_id__to_user_id__created_time__idx;
+DROP INDEX event_legs__tenant_id__user_id__idx;
+DROP INDEX fee_rules__tenant_id__operation__id__idx;
+
+CREATE INDEX events__from_user_id__created_time__idx ON events (from_user_id, created_time);
+CREATE INDEX events__to_user_id__created_time__idx ON events (to_user_id, created_time) WHERE to_user_id IS NOT NULL;
+CREATE INDEX event_legs__user_id__idx ON event_legs (user_id);
+CREATE INDEX fee_rules__operation__id__idx ON fee_rules (operation, id);
+
+ALTER TABLE events
+    DROP CONSTRAINT events__from_user_id__fkey,
+    DROP CONSTRAINT events__to_user_id__fkey;
+ALTER TABLE event_legs DROP CONSTRAINT event_legs__user_id__fkey;
+ALTER TABLE account_limits DROP CONSTRAINT account_limits__user_id__fkey;
+ALTER TABLE scheduled_commands DROP CONSTRAINT scheduled_commands__user_id__fkey;
+ALTER TABLE mandates
+    DROP CONSTRAINT mandates__from_user_id__fkey,
+    DROP CONSTRAINT mandates__to_user_id__fkey;
+ALTER TABLE reviews DROP CONSTRAINT reviews__user_id__fkey;
+
+ALTER TABLE balances
+    DROP CONSTRAINT balances_pkey,
+    ADD PRIMARY KEY (id);
+ALTER TABLE balances_replay
+    DROP CONSTRAINT balances_replay_pkey,
+    ADD PRIMARY KEY (id);
+ALTER TABLE account_limits
+    DROP CONSTRAINT account_limits_pkey,
+    ADD PRIMARY KEY (user_id);
+
+ALTER TABLE events
+    ADD CONSTRAINT events_from_user_id_fkey FOREIGN KEY (from_user_id) REFERENCES balances,
+    ADD CONSTRAINT events_to_user_id_fkey FOREIGN KEY (to_user_id) REFERENCES balances;
+ALTER TABLE event_legs ADD CONSTRAINT event_legs_user_id_fkey FOREIGN KEY (user_id) REFERENCES balances;
+ALTER TABLE account_limits ADD CONSTRAINT account_limits_user_id_fkey FOREIGN KEY (user_id) REFERENCES balances;
+ALTER TABLE scheduled_commands ADD CONSTRAINT scheduled_commands_user_id_fkey FOREIGN KEY (user_id) REFERENCES balances;
+ALTER TABLE mandates
+    ADD CONSTRAINT mandates_from_user_id_fkey FOREIGN KEY (from_user_id) REFERENCES balances,
+    ADD CONSTRAINT mandates_to_user_id_fkey FOREIGN KEY (to_user_id) REFERENCES balances;
+ALTER TABLE reviews ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES balances;
+
+ALTER TABLE balances DROP COLUMN tenant_id;
+ALTER TABLE balances_replay DROP COLUMN tenant_id;
+ALTER TABLE events DROP COLUMN tenant_id;
+ALTER TABLE event_legs DROP COLUMN tenant_id;
+ALTER TABLE account_limits DROP COLUMN tenant_id;
+ALTER TABLE fee_rules DROP COLUMN tenant_id;
+ALTER TABLE scheduled_commands DROP COLUMN tenant_id;
+ALTER TABLE mandates DROP COLUMN tenant_id;
+ALTER TABLE reviews DROP COLUMN tenant_id;
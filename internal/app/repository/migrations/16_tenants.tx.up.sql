@@ -0,0 +1,66 @@
+-- every row belongs to a tenant, user ids are unique only inside of their tenant.
+-- Existing rows go to the default tenant, defaults are dropped so that rows without tenant can't be inserted.
+ALTER TABLE balances ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE balances_replay ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE events ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE event_legs ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE account_limits ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE fee_rules ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE scheduled_commands ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE mandates ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE reviews ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+
+ALTER TABLE events
+    DROP CONSTRAINT events_from_user_id_fkey,
+    DROP CONSTRAINT events_to_user_id_fkey;
+ALTER TABLE event_legs DROP CONSTRAINT event_legs_user_id_fkey;
+ALTER TABLE account_limits DROP CONSTRAINT account_limits_user_id_fkey;
+ALTER TABLE scheduled_commands DROP CONSTRAINT scheduled_commands_user_id_fkey;
+ALTER TABLE mandates
+    DROP CONSTRAINT mandates_from_user_id_fkey,
+    DROP CONSTRAINT mandates_to_user_id_fkey;
+ALTER TABLE reviews DROP CONSTRAINT reviews_user_id_fkey;
+
+ALTER TABLE balances
+    DROP CONSTRAINT balances_pkey,
+    ADD PRIMARY KEY (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE balances_replay
+    DROP CONSTRAINT balances_replay_pkey,
+    ADD PRIMARY KEY (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE account_limits
+    DROP CONSTRAINT account_limits_pkey,
+    ADD PRIMARY KEY (tenant_id, user_id),
+    ADD CONSTRAINT account_limits__user_id__fkey FOREIGN KEY (tenant_id, user_id) REFERENCES balances (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE events
+    ADD CONSTRAINT events__from_user_id__fkey FOREIGN KEY (tenant_id, from_user_id) REFERENCES balances (tenant_id, id),
+    ADD CONSTRAINT events__to_user_id__fkey FOREIGN KEY (tenant_id, to_user_id) REFERENCES balances (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE event_legs
+    ADD CONSTRAINT event_legs__user_id__fkey FOREIGN KEY (tenant_id, user_id) REFERENCES balances (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE scheduled_commands
+    ADD CONSTRAINT scheduled_commands__user_id__fkey FOREIGN KEY (tenant_id, user_id) REFERENCES balances (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE mandates
+    ADD CONSTRAINT mandates__from_user_id__fkey FOREIGN KEY (tenant_id, from_user_id) REFERENCES balances (tenant_id, id),
+    ADD CONSTRAINT mandates__to_user_id__fkey FOREIGN KEY (tenant_id, to_user_id) REFERENCES balances (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE reviews
+    ADD CONSTRAINT reviews__user_id__fkey FOREIGN KEY (tenant_id, user_id) REFERENCES balances (tenant_id, id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE fee_rules ALTER COLUMN tenant_id DROP DEFAULT;
+
+DROP INDEX events__from_user_id__created_time__idx;
+DROP INDEX events__to_user_id__created_time__idx;
+DROP INDEX event_legs__user_id__idx;
+DROP INDEX fee_rules__operation__id__idx;
+
+CREATE INDEX events__tenant_id__from_user_id__created_time__idx ON events (tenant_id, from_user_id, created_time);
+CREATE INDEX events__tenant_id__to_user_id__created_time__idx ON events (tenant_id, to_user_id, created_time)
+    WHERE to_user_id IS NOT NULL;
+CREATE INDEX event_legs__tenant_id__user_id__idx ON event_legs (tenant_id, user_id);
+CREATE INDEX fee_rules__tenant_id__operation__id__idx ON fee_rules (tenant_id, operation, id);
@@ -0,0 +1,7 @@
+UPDATE balances b
+SET balance = b.balance + f.amount
+FROM (SELECT tenant_id, account_id, sum(amount) AS amount FROM fee_entries GROUP BY tenant_id, account_id) f
+WHERE b.tenant_id = f.tenant_id
+  AND b.id = f.account_id;
+
+DROP TABLE fee_entries;
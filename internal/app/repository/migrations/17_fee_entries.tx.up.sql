@@ -0,0 +1,15 @@
+-- fees are appended here instead of being added to the fee account row, so that operations paying fees
+-- don't wait for each other on that row. Entries are moved to the balance when the fee account is locked.
+CREATE TABLE fee_entries
+(
+    id           BIGSERIAL PRIMARY KEY        NOT NULL,
+    tenant_id    VARCHAR(32)                  NOT NULL,
+    account_id   BIGINT                       NOT NULL,
+    event_id     BIGINT REFERENCES events     NOT NULL,
+    amount       BIGINT                       NOT NULL,
+    created_time timestamptz                  NOT NULL,
+
+    CHECK (amount > 0)
+);
+
+CREATE INDEX fee_entries__tenant_id__account_id__idx ON fee_entries (tenant_id, account_id);
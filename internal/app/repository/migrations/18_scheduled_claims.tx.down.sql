@@ -0,0 +1,5 @@
+DROP INDEX scheduled_commands__claimed_time__idx;
+
+ALTER TABLE scheduled_commands
+    DROP COLUMN claimed_time,
+    DROP COLUMN attempts;
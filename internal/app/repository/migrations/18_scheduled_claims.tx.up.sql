@@ -0,0 +1,12 @@
+-- commands left in executing status by a crashed worker are claimed again after a timeout,
+-- attempts tell the worker that the command may have been executed already
+ALTER TABLE scheduled_commands
+    ADD COLUMN claimed_time timestamptz,
+    ADD COLUMN attempts     INT NOT NULL DEFAULT 0;
+
+UPDATE scheduled_commands
+SET claimed_time = now(),
+    attempts     = 1
+WHERE status = 'executing';
+
+CREATE INDEX scheduled_commands__claimed_time__idx ON scheduled_commands (claimed_time) WHERE status = 'executing';
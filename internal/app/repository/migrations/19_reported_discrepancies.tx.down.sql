@@ -0,0 +1,5 @@
+DROP TABLE reported_discrepancies;
+
+ALTER TABLE events
+    DROP CONSTRAINT events__fee_account_id__check,
+    DROP COLUMN fee_account_id;
@@ -0,0 +1,22 @@
+-- account the fee of the event was credited to, so that changing the fee account doesn't break reconciliation
+-- of older events. Events before this column are reconciled against the current fee account.
+ALTER TABLE events ADD COLUMN fee_account_id BIGINT;
+
+UPDATE events e
+SET fee_account_id = f.account_id
+FROM fee_entries f
+WHERE f.event_id = e.id;
+
+ALTER TABLE events
+    ADD CONSTRAINT events__fee_account_id__check CHECK (fee IS NULL OR fee_account_id IS NOT NULL) NOT VALID;
+
+-- discrepancies balance_mismatch was sent for, it is sent again only when the difference changes
+CREATE TABLE reported_discrepancies
+(
+    tenant_id     VARCHAR(32) NOT NULL,
+    user_id       BIGINT      NOT NULL,
+    difference    BIGINT      NOT NULL,
+    reported_time timestamptz NOT NULL,
+
+    PRIMARY KEY (tenant_id, user_id)
+);
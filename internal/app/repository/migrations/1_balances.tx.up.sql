@@ -0,0 +1,6 @@
+CREATE TABLE balances
+(
+    id      BIGSERIAL NOT NULL PRIMARY KEY,
+    balance BIGINT NOT NULL,
+    CHECK ( balance >= 0 )
+);
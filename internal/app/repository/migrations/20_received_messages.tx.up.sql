@@ -0,0 +1,14 @@
+-- commands received from producers until they are too old to be accepted, so that a copy of a signed command
+-- published again is rejected. NATS subject and sequence tell redelivery of the same message from a copy.
+CREATE TABLE received_messages
+(
+    producer     VARCHAR(128) NOT NULL,
+    message_id   VARCHAR(64)  NOT NULL,
+    subject      VARCHAR(128) NOT NULL,
+    sequence     BIGINT       NOT NULL,
+    expires_time timestamptz  NOT NULL,
+
+    PRIMARY KEY (producer, message_id)
+);
+
+CREATE INDEX received_messages__expires_time__idx ON received_messages (expires_time);
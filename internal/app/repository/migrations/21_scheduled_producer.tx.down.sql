@@ -0,0 +1 @@
+ALTER TABLE scheduled_commands DROP COLUMN producer;
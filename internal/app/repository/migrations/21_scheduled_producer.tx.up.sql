@@ -0,0 +1,3 @@
+-- producer of the scheduled command, its policies are checked again when the command is executed.
+-- Commands scheduled before have NULL, they were authorized when scheduled.
+ALTER TABLE scheduled_commands ADD COLUMN producer VARCHAR(128);
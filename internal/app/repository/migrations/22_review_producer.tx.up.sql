@@ -0,0 +1,3 @@
+-- producer of the held command, it can't decide its own review.
+-- Reviews held before have NULL.
+ALTER TABLE reviews ADD COLUMN producer VARCHAR(128);
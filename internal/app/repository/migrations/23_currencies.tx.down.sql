@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN currency;
+ALTER TABLE balances DROP COLUMN currency;
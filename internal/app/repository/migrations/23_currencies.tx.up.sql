@@ -0,0 +1,5 @@
+-- currency of the account, amounts of its balance, commands and events are minor units of it.
+-- All accounts opened before are in roubles.
+ALTER TABLE balances ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+-- currency of amounts of the event, NULL for events without amounts and events made before (roubles).
+ALTER TABLE events ADD COLUMN currency VARCHAR(3);
@@ -0,0 +1,7 @@
+DROP INDEX events__scheduled_command_id__idx;
+
+UPDATE events SET scheduled_command_id = NULL WHERE type NOT IN ('scheduled', 'schedule_cancelled');
+ALTER TABLE events
+    DROP CONSTRAINT events__scheduled_command_id__check,
+    ADD CONSTRAINT events__scheduled_command_id__check
+        CHECK ((type IN ('scheduled', 'schedule_cancelled')) = (scheduled_command_id IS NOT NULL));
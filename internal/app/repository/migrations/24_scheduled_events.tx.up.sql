@@ -0,0 +1,8 @@
+-- events made by executing a scheduled command reference it too, so that a command claimed again
+-- after its worker died is not executed twice. Events made before have NULL.
+ALTER TABLE events
+    DROP CONSTRAINT events__scheduled_command_id__check,
+    ADD CONSTRAINT events__scheduled_command_id__check
+        CHECK (type NOT IN ('scheduled', 'schedule_cancelled') OR scheduled_command_id IS NOT NULL);
+
+CREATE INDEX events__scheduled_command_id__idx ON events (scheduled_command_id) WHERE scheduled_command_id IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE mandates DROP COLUMN producer;
@@ -0,0 +1,3 @@
+-- producer of create_mandate command, its policies are checked again before every run.
+-- Mandates created before have NULL and are not checked.
+ALTER TABLE mandates ADD COLUMN producer VARCHAR(128);
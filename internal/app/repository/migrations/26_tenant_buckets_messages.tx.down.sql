@@ -0,0 +1,12 @@
+DELETE FROM rate_limit_buckets;
+-- keys of different tenants may be the same
+DELETE FROM received_messages WHERE tenant_id <> 'default';
+
+ALTER TABLE rate_limit_buckets
+    DROP CONSTRAINT rate_limit_buckets_pkey,
+    ADD PRIMARY KEY (key),
+    DROP COLUMN tenant_id;
+ALTER TABLE received_messages
+    DROP CONSTRAINT received_messages_pkey,
+    ADD PRIMARY KEY (producer, message_id),
+    DROP COLUMN tenant_id;
@@ -0,0 +1,13 @@
+-- rate limit buckets and received messages belong to a tenant like other tables since migration 16.
+-- Buckets are refilled anyway, so old ones are dropped; received messages go to the default tenant.
+DELETE FROM rate_limit_buckets;
+ALTER TABLE rate_limit_buckets ADD COLUMN tenant_id VARCHAR(32) NOT NULL;
+ALTER TABLE received_messages ADD COLUMN tenant_id VARCHAR(32) NOT NULL DEFAULT 'default';
+
+ALTER TABLE rate_limit_buckets
+    DROP CONSTRAINT rate_limit_buckets_pkey,
+    ADD PRIMARY KEY (tenant_id, key);
+ALTER TABLE received_messages
+    DROP CONSTRAINT received_messages_pkey,
+    ADD PRIMARY KEY (tenant_id, producer, message_id),
+    ALTER COLUMN tenant_id DROP DEFAULT;
@@ -0,0 +1,16 @@
+DELETE FROM events WHERE type IN ('freeze', 'unfreeze', 'close');
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__to_user_id__check,
+    DROP CONSTRAINT events__amount__check;
+
+ALTER TABLE events
+    ADD CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer')),
+    ADD CHECK (type = 'transfer' OR to_user_id IS NULL),
+    ADD CHECK (type <> 'transfer' OR to_user_id IS NOT NULL),
+    ADD CHECK (type = 'open' OR amount IS NOT NULL),
+    ADD CHECK (type <> 'open' OR amount IS NULL);
+
+ALTER TABLE balances
+    DROP COLUMN status;
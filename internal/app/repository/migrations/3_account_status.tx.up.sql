@@ -0,0 +1,24 @@
+ALTER TABLE balances
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD CONSTRAINT balances__status__check CHECK (status IN ('active', 'frozen', 'closed'));
+
+-- checks of events table were unnamed, replace them with named ones so next migrations can change them
+DO
+$$
+    DECLARE
+        c RECORD;
+    BEGIN
+        FOR c IN SELECT conname FROM pg_constraint WHERE conrelid = 'events'::regclass AND contype = 'c'
+            LOOP
+                EXECUTE format('ALTER TABLE events DROP CONSTRAINT %I', c.conname);
+            END LOOP;
+    END
+$$;
+
+ALTER TABLE events
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close')),
+    ADD CONSTRAINT events__to_user_id__check
+        CHECK ((type = 'transfer') = (to_user_id IS NOT NULL)),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer')) = (amount IS NOT NULL));
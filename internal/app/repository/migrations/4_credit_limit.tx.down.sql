@@ -0,0 +1,14 @@
+DELETE FROM events WHERE type IN ('credit_limit', 'overdraft');
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer')) = (amount IS NOT NULL));
+
+ALTER TABLE balances
+    DROP CONSTRAINT balances__balance__check,
+    DROP COLUMN credit_limit,
+    ADD CONSTRAINT balances_balance_check CHECK (balance >= 0);
@@ -0,0 +1,14 @@
+ALTER TABLE balances
+    ADD COLUMN credit_limit BIGINT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT balances__credit_limit__check CHECK (credit_limit >= 0),
+    DROP CONSTRAINT balances_balance_check,
+    ADD CONSTRAINT balances__balance__check CHECK (balance >= -credit_limit);
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft')) = (amount IS NOT NULL));
@@ -0,0 +1,11 @@
+DELETE FROM events WHERE type = 'limits';
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft'));
+
+DROP INDEX events__from_user_id__created_time__idx;
+
+DROP TABLE account_limits;
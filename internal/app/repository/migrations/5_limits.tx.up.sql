@@ -0,0 +1,17 @@
+CREATE TABLE account_limits
+(
+    user_id               BIGINT REFERENCES balances NOT NULL PRIMARY KEY,
+    max_amount            BIGINT                     NOT NULL,
+    max_daily_outgoing    BIGINT                     NOT NULL,
+    max_monthly_outgoing  BIGINT                     NOT NULL,
+    max_hourly_operations BIGINT                     NOT NULL
+);
+
+-- outgoing usage of an account is computed from events
+CREATE INDEX events__from_user_id__created_time__idx ON events (from_user_id, created_time);
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits'));
@@ -0,0 +1,11 @@
+DELETE FROM events WHERE type = 'fee_rule';
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits')),
+    DROP COLUMN fee_rule_id,
+    DROP COLUMN fee;
+
+DROP TABLE fee_rules;
@@ -0,0 +1,28 @@
+-- fee rules are never updated, new version of a rule is a new row
+CREATE TABLE fee_rules
+(
+    id           BIGSERIAL PRIMARY KEY NOT NULL,
+    operation    VARCHAR(32)           NOT NULL,
+    kind         VARCHAR(16)           NOT NULL,
+    flat         BIGINT                NOT NULL,
+    percent_bp   BIGINT                NOT NULL,
+    min_fee      BIGINT                NOT NULL,
+    max_fee      BIGINT                NOT NULL,
+    tiers        JSONB,
+    created_time timestamptz           NOT NULL,
+
+    CHECK (operation IN ('withdraw', 'transfer')),
+    CHECK (kind IN ('none', 'flat', 'percent', 'tiered')),
+    CHECK (flat >= 0 AND percent_bp >= 0 AND min_fee >= 0 AND max_fee >= 0)
+);
+
+CREATE INDEX fee_rules__operation__id__idx ON fee_rules (operation, id);
+
+ALTER TABLE events
+    ADD COLUMN fee         BIGINT,
+    ADD COLUMN fee_rule_id BIGINT REFERENCES fee_rules,
+    ADD CONSTRAINT events__fee__check CHECK (fee IS NULL OR (fee >= 0 AND fee_rule_id IS NOT NULL)),
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule'));
@@ -0,0 +1,16 @@
+DELETE FROM events WHERE type = 'reversal';
+
+DROP INDEX events__reversed_event_id__idx;
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    DROP CONSTRAINT events__to_user_id__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft')) = (amount IS NOT NULL)),
+    ADD CONSTRAINT events__to_user_id__check
+        CHECK ((type = 'transfer') = (to_user_id IS NOT NULL)),
+    DROP COLUMN reversed_event_id;
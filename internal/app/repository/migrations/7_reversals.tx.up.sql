@@ -0,0 +1,17 @@
+ALTER TABLE events
+    ADD COLUMN reversed_event_id BIGINT REFERENCES events,
+    ADD CONSTRAINT events__reversed_event_id__check CHECK ((type = 'reversal') = (reversed_event_id IS NOT NULL)),
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    DROP CONSTRAINT events__to_user_id__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft', 'reversal')) =
+               (amount IS NOT NULL)),
+    -- reversal has to_user_id if the reversed event is a transfer
+    ADD CONSTRAINT events__to_user_id__check
+        CHECK (type = 'reversal' OR (type = 'transfer') = (to_user_id IS NOT NULL));
+
+CREATE INDEX events__reversed_event_id__idx ON events (reversed_event_id) WHERE reversed_event_id IS NOT NULL;
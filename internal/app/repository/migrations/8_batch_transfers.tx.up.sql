@@ -0,0 +1,23 @@
+-- legs of batch transfer, negative amount is debited from the user, positive is credited
+CREATE TABLE event_legs
+(
+    event_id BIGINT REFERENCES events   NOT NULL,
+    number   INT                        NOT NULL,
+    user_id  BIGINT REFERENCES balances NOT NULL,
+    amount   BIGINT                     NOT NULL,
+
+    PRIMARY KEY (event_id, number),
+    CHECK (amount <> 0)
+);
+
+CREATE INDEX event_legs__user_id__idx ON event_legs (user_id);
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    DROP CONSTRAINT events__amount__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer')),
+    ADD CONSTRAINT events__amount__check
+        CHECK ((type IN ('deposit', 'withdraw', 'transfer', 'credit_limit', 'overdraft', 'reversal',
+                         'batch_transfer')) = (amount IS NOT NULL));
@@ -0,0 +1,10 @@
+DELETE FROM events WHERE type IN ('scheduled', 'schedule_cancelled');
+
+ALTER TABLE events
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer')),
+    DROP COLUMN scheduled_command_id;
+
+DROP TABLE scheduled_commands;
@@ -0,0 +1,25 @@
+CREATE TABLE scheduled_commands
+(
+    id            BIGSERIAL PRIMARY KEY      NOT NULL,
+    user_id       BIGINT REFERENCES balances NOT NULL,
+    command       JSONB                      NOT NULL,
+    execute_at    timestamptz                NOT NULL,
+    status        VARCHAR(16)                NOT NULL,
+    error         TEXT,
+    created_time  timestamptz                NOT NULL,
+    executed_time timestamptz,
+
+    CHECK (status IN ('pending', 'executing', 'done', 'failed', 'cancelled'))
+);
+
+CREATE INDEX scheduled_commands__execute_at__idx ON scheduled_commands (execute_at) WHERE status = 'pending';
+
+ALTER TABLE events
+    ADD COLUMN scheduled_command_id BIGINT REFERENCES scheduled_commands,
+    DROP CONSTRAINT events__type__check,
+    ADD CONSTRAINT events__type__check
+        CHECK (type IN ('open', 'deposit', 'withdraw', 'transfer', 'freeze', 'unfreeze', 'close',
+                        'credit_limit', 'overdraft', 'limits', 'fee_rule', 'reversal', 'batch_transfer',
+                        'scheduled', 'schedule_cancelled')),
+    ADD CONSTRAINT events__scheduled_command_id__check
+        CHECK ((type IN ('scheduled', 'schedule_cancelled')) = (scheduled_command_id IS NOT NULL));
@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsHaveUpAndDown(t *testing.T) {
+	col, err := getMigrations()
+	require.NoError(t, err)
+
+	migs := col.Migrations()
+	require.NotEmpty(t, migs)
+	for i, m := range migs {
+		require.EqualValues(t, i+1, m.Version, "migration versions must go one by one")
+		require.NotNil(t, m.Up, "migration %d has no up step", m.Version)
+		require.NotNil(t, m.Down, "migration %d has no down step", m.Version)
+		require.True(t, m.UpTx && m.DownTx, "migration %d must run in transaction", m.Version)
+	}
+}
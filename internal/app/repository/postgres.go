@@ -25,27 +25,33 @@ func New(url string) *Repository {
 		panic(err)
 	}
 
-	if err := doMigrationIfNeeded(db); err != nil {
-		panic(err)
-	}
-
 	return &Repository{
 		db: db,
 	}
 }
 
-func (r *Repository) CreateAccount(tx pg.DBI, userID int64) error {
+func (r *Repository) CreateAccount(tx pg.DBI, tenantID string, userID int64, currency string) error {
 	_, err := tx.Model(&model.Balance{
-		UserID:  userID,
-		Balance: 0,
+		TenantID: tenantID,
+		UserID:   userID,
+		Balance:  0,
+		Currency: currency,
+		Status:   model.AccountStatusActive,
 	}).Insert()
 	return err
 }
 
-func (r *Repository) GetBalance(tx pg.DBI, userID int64, withLock bool) (balance model.Balance, err error) {
-	query := tx.Model(&balance).Where("id = ?", userID)
+// GetBalance returns balance including fee entries. Locked balance has them settled, so it can be
+// changed with UpdateBalance; fees credited after that are settled next time.
+func (r *Repository) GetBalance(tx pg.DBI, tenantID string, userID int64, withLock bool) (balance model.Balance, err error) {
+	query := tx.Model(&balance).Where("tenant_id = ?", tenantID).Where("id = ?", userID)
 	if withLock {
+		if err := settleFeeEntries(tx, tenantID, userID); err != nil {
+			return model.Balance{}, err
+		}
 		query = query.For("UPDATE")
+	} else {
+		query = query.Column("tenant_id", "id", "currency", "status", "credit_limit").ColumnExpr(balanceWithFeesExpr)
 	}
 	if err := query.Select(); err != nil {
 		if errors.Is(err, pg.ErrNoRows) {
@@ -56,14 +62,33 @@ func (r *Repository) GetBalance(tx pg.DBI, userID int64, withLock bool) (balance
 	return balance, nil
 }
 
-func (r *Repository) UpdateBalance(tx pg.DBI, userID, newBalance int64) error {
+func (r *Repository) UpdateBalance(tx pg.DBI, tenantID string, userID, newBalance int64) error {
 	balance := model.Balance{
-		UserID: userID,
+		TenantID: tenantID,
+		UserID:   userID,
 	}
 	_, err := tx.Model(&balance).WherePK().Set("balance = ?", newBalance).Update()
 	return err
 }
 
+func (r *Repository) UpdateStatus(tx pg.DBI, tenantID string, userID int64, status model.AccountStatus) error {
+	balance := model.Balance{
+		TenantID: tenantID,
+		UserID:   userID,
+	}
+	_, err := tx.Model(&balance).WherePK().Set("status = ?", status).Update()
+	return err
+}
+
+func (r *Repository) UpdateCreditLimit(tx pg.DBI, tenantID string, userID, creditLimit int64) error {
+	balance := model.Balance{
+		TenantID: tenantID,
+		UserID:   userID,
+	}
+	_, err := tx.Model(&balance).WherePK().Set("credit_limit = ?", creditLimit).Update()
+	return err
+}
+
 func (r *Repository) DoInTX(ctx context.Context, f func(tx pg.DBI) error) error {
 	return r.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
 		return f(tx)
@@ -74,6 +99,10 @@ func (r *Repository) GetDB(ctx context.Context) pg.DBI {
 	return r.db.WithContext(ctx)
 }
 
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
 type dbLogger struct {
 	enabled bool
 }
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// TakeToken takes one token from the bucket of key of the tenant, ok is false if bucket is empty. Bucket is refilled with
+// limit.Rate tokens per second up to limit.Burst. Row lock of the bucket makes it safe for concurrent workers,
+// so it should be called outside of long transactions.
+func (r *Repository) TakeToken(tx pg.DBI, tenantID, key string, limit model.RateLimit) (ok bool, err error) {
+	res, err := tx.Exec(`
+INSERT INTO rate_limit_buckets AS b (tenant_id, key, tokens, updated_time)
+VALUES (?3, ?0, ?2 - 1, clock_timestamp())
+ON CONFLICT (tenant_id, key) DO UPDATE
+    SET tokens       = least(?2, b.tokens + extract(EPOCH FROM clock_timestamp() - b.updated_time) * ?1) - 1,
+        updated_time = clock_timestamp()
+    WHERE least(?2, b.tokens + extract(EPOCH FROM clock_timestamp() - b.updated_time) * ?1) >= 1`,
+		key, limit.Rate, limit.Burst, tenantID)
+	if err != nil {
+		return false, fmt.Errorf("[postgres] error on taking rate limit token: %w", err)
+	}
+	return res.RowsAffected() == 1, nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// reconciliationLockSpace is the first key of the advisory lock taken while reconciliation is running.
+const reconciliationLockSpace = 1002
+
+// TryReconciliationLock returns true if no other reconciliation is running, the lock is released with tx.
+func (r *Repository) TryReconciliationLock(tx pg.DBI) (locked bool, err error) {
+	_, err = tx.QueryOne(pg.Scan(&locked), "SELECT pg_try_advisory_xact_lock(?, 0)", reconciliationLockSpace)
+	if err != nil {
+		return false, fmt.Errorf("[postgres] error on taking reconciliation lock: %w", err)
+	}
+	return locked, nil
+}
+
+// SetSnapshotIsolation makes all following queries of tx see the same snapshot of the database.
+// It must be called before any other query in tx.
+func (r *Repository) SetSnapshotIsolation(tx pg.DBI) error {
+	_, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	return err
+}
+
+// CountBalances returns number of accounts of all tenants.
+func (r *Repository) CountBalances(tx pg.DBI) (int, error) {
+	return tx.Model((*model.Balance)(nil)).Count()
+}
+
+// ListBalanceDiscrepancies returns accounts of all tenants which balance is not equal to the sum of money movements
+// of their events. Fees are counted to the fee account of the event, or to feeAccountID of the tenant of the event
+// for events made before fee account was recorded on them.
+func (r *Repository) ListBalanceDiscrepancies(tx pg.DBI, feeAccountID int64) (discrepancies []model.Discrepancy, err error) {
+	_, err = tx.Query(&discrepancies, `
+WITH movements (tenant_id, user_id, amount) AS (
+    SELECT tenant_id, from_user_id, amount
+    FROM events
+    WHERE type = ?0
+    UNION ALL
+    SELECT tenant_id, from_user_id, -amount - coalesce(fee, 0)
+    FROM events
+    WHERE type IN (?1, ?2)
+    UNION ALL
+    SELECT tenant_id, to_user_id, amount
+    FROM events
+    WHERE type = ?2
+    UNION ALL
+    SELECT tenant_id, coalesce(fee_account_id, ?3), fee
+    FROM events
+    WHERE type IN (?1, ?2)
+      AND fee IS NOT NULL
+      AND (fee_account_id IS NOT NULL OR ?3 <> 0)
+    UNION ALL
+    SELECT tenant_id, user_id, amount
+    FROM event_legs
+    UNION ALL
+    SELECT r.tenant_id, r.from_user_id, CASE WHEN o.type = ?0 THEN -r.amount ELSE r.amount END
+    FROM events r
+             JOIN events o ON o.id = r.reversed_event_id
+    WHERE r.type = ?4
+    UNION ALL
+    SELECT tenant_id, to_user_id, -amount
+    FROM events
+    WHERE type = ?4
+      AND to_user_id IS NOT NULL
+)
+SELECT b.tenant_id, b.id AS user_id, b.balance + coalesce(f.amount, 0) AS balance, coalesce(m.expected, 0) AS expected
+FROM balances b
+         LEFT JOIN (SELECT tenant_id, user_id, sum(amount) AS expected FROM movements GROUP BY tenant_id, user_id) m
+                   ON m.tenant_id = b.tenant_id AND m.user_id = b.id
+         LEFT JOIN (SELECT tenant_id, account_id, sum(amount) AS amount FROM fee_entries GROUP BY tenant_id, account_id) f
+                   ON f.tenant_id = b.tenant_id AND f.account_id = b.id
+WHERE b.balance + coalesce(f.amount, 0) <> coalesce(m.expected, 0)
+ORDER BY b.tenant_id, b.id`,
+		model.EventTypeDeposit, model.EventTypeWithdraw, model.EventTypeTransfer, feeAccountID, model.EventTypeReversal)
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on listing balance discrepancies: %w", err)
+	}
+	return discrepancies, nil
+}
+
+// ListReportedDiscrepancies returns discrepancies of all tenants balance_mismatch was sent for.
+func (r *Repository) ListReportedDiscrepancies(tx pg.DBI) (reported []model.ReportedDiscrepancy, err error) {
+	if err := tx.Model(&reported).Select(); err != nil {
+		return nil, fmt.Errorf("[postgres] error on listing reported discrepancies: %w", err)
+	}
+	return reported, nil
+}
+
+// SetReportedDiscrepancies replaces reported discrepancies of all tenants, so that fixed ones are forgotten.
+func (r *Repository) SetReportedDiscrepancies(tx pg.DBI, reported []model.ReportedDiscrepancy) error {
+	if _, err := tx.Exec("DELETE FROM reported_discrepancies"); err != nil {
+		return fmt.Errorf("[postgres] error on deleting reported discrepancies: %w", err)
+	}
+	if len(reported) == 0 {
+		return nil
+	}
+	if _, err := tx.Model(&reported).Insert(); err != nil {
+		return fmt.Errorf("[postgres] error on adding reported discrepancies: %w", err)
+	}
+	return nil
+}
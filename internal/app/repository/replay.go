@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+const replayTable = "balances_replay"
+
+// ListEvents returns up to limit events of the tenant with id greater than afterID in the order of ids.
+func (r *Repository) ListEvents(tx pg.DBI, tenantID string, afterID int64, limit int) (events []model.Event, err error) {
+	err = tx.Model(&events).
+		Relation("Legs").
+		Where("tenant_id = ?", tenantID).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on listing events: %w", err)
+	}
+	return events, nil
+}
+
+func (r *Repository) ListBalances(tx pg.DBI, tenantID string) (balances []model.Balance, err error) {
+	err = tx.Model(&balances).
+		Column("tenant_id", "id", "currency", "status", "credit_limit").
+		ColumnExpr(balanceWithFeesExpr).
+		Where("tenant_id = ?", tenantID).
+		Order("id").
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on listing balances: %w", err)
+	}
+	return balances, nil
+}
+
+// SaveReplayedBalances replaces replayed balances of the tenant with balances.
+func (r *Repository) SaveReplayedBalances(tx pg.DBI, tenantID string, balances []model.Balance) error {
+	const batchSize = 1000
+
+	if _, err := tx.Exec("DELETE FROM ? WHERE tenant_id = ?", pg.Ident(replayTable), tenantID); err != nil {
+		return fmt.Errorf("[postgres] error on truncating replayed balances: %w", err)
+	}
+	for from := 0; from < len(balances); from += batchSize {
+		to := from + batchSize
+		if to > len(balances) {
+			to = len(balances)
+		}
+		var ids, amounts, creditLimits []int64
+		var statuses []string
+		for _, b := range balances[from:to] {
+			ids = append(ids, b.UserID)
+			amounts = append(amounts, b.Balance)
+			statuses = append(statuses, string(b.Status))
+			creditLimits = append(creditLimits, b.CreditLimit)
+		}
+		_, err := tx.Exec(`
+INSERT INTO ? (tenant_id, id, balance, status, credit_limit)
+SELECT ?, * FROM unnest(?::BIGINT[], ?::BIGINT[], ?::VARCHAR[], ?::BIGINT[])`,
+			pg.Ident(replayTable), tenantID, pg.Array(ids), pg.Array(amounts), pg.Array(statuses), pg.Array(creditLimits))
+		if err != nil {
+			return fmt.Errorf("[postgres] error on saving replayed balances: %w", err)
+		}
+	}
+	return nil
+}
+
+// RestoreReplayedBalances copies replayed balances of the tenant to accounts that exist in both tables,
+// it returns number of changed accounts. Accounts missing in one of the tables are left as they are.
+// Fee entries are settled first, replayed balances already include them.
+func (r *Repository) RestoreReplayedBalances(tx pg.DBI, tenantID string) (int, error) {
+	_, err := tx.Exec(`
+WITH settled AS (
+    DELETE FROM fee_entries WHERE tenant_id = ?0 RETURNING account_id, amount
+)
+UPDATE balances b
+SET balance = b.balance + f.amount
+FROM (SELECT account_id, sum(amount) AS amount FROM settled GROUP BY account_id) f
+WHERE b.tenant_id = ?0
+  AND b.id = f.account_id`, tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("[postgres] error on settling fee entries: %w", err)
+	}
+
+	res, err := tx.Exec(`
+UPDATE balances b
+SET balance      = r.balance,
+    status       = r.status,
+    credit_limit = r.credit_limit
+FROM ? r
+WHERE r.tenant_id = ?
+  AND r.tenant_id = b.tenant_id
+  AND r.id = b.id
+  AND (b.balance, b.status, b.credit_limit) IS DISTINCT FROM (r.balance, r.status, r.credit_limit)`,
+		pg.Ident(replayTable), tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("[postgres] error on restoring replayed balances: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// AddReview saves review of the tenant, if tx is started with context of a command the review gets its producer.
+func (r *Repository) AddReview(tx pg.DBI, tenantID string, review *model.Review) (*model.Review, error) {
+	if tx, ok := tx.(*pg.Tx); ok && review.Producer == nil {
+		review.Producer = model.ProducerFromContext(tx.Context())
+	}
+	review.TenantID = tenantID
+	_, err := tx.Model(review).Returning("*").Insert()
+	return review, err
+}
+
+func (r *Repository) GetReview(tx pg.DBI, tenantID string, id int64, withLock bool) (review model.Review, err error) {
+	query := tx.Model(&review).Where("tenant_id = ?", tenantID).Where("id = ?", id)
+	if withLock {
+		query = query.For("UPDATE")
+	}
+	if err := query.Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			err = model.ErrReviewNotFound
+		}
+		return model.Review{}, fmt.Errorf("[postgres] error on getting review: %w", err)
+	}
+	return review, nil
+}
+
+func (r *Repository) UpdateReview(tx pg.DBI, review *model.Review) error {
+	_, err := tx.Model(review).Column("status", "decided_time").WherePK().Where("tenant_id = ?tenant_id").Update()
+	return err
+}
+
+// ListPendingReviews returns the oldest reviews of the tenant waiting for decision.
+func (r *Repository) ListPendingReviews(tx pg.DBI, tenantID string, limit int) (reviews []model.Review, err error) {
+	err = tx.Model(&reviews).
+		Where("tenant_id = ?", tenantID).
+		Where("status = ?", model.ReviewStatusPending).
+		Order("created_time", "id").
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on listing reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// GetAccountActivity returns when the account was opened and how much it received since the time:
+// deposits, transfers and credited legs of batch transfers.
+func (r *Repository) GetAccountActivity(tx pg.DBI, tenantID string, userID int64, since time.Time) (activity model.AccountActivity, err error) {
+	_, err = tx.QueryOne(pg.Scan(&activity.OpenedTime, &activity.RecentIncoming), `
+SELECT (SELECT created_time FROM events WHERE tenant_id = ?5 AND from_user_id = ?0 AND type = ?1),
+       (SELECT coalesce(sum(amount), 0)
+        FROM (SELECT amount
+              FROM events
+              WHERE tenant_id = ?5
+                AND from_user_id = ?0
+                AND type = ?2
+                AND created_time >= ?4
+              UNION ALL
+              SELECT amount
+              FROM events
+              WHERE tenant_id = ?5
+                AND to_user_id = ?0
+                AND type = ?3
+                AND created_time >= ?4
+              UNION ALL
+              SELECT l.amount
+              FROM event_legs l
+                       JOIN events e ON e.id = l.event_id
+              WHERE l.tenant_id = ?5
+                AND l.user_id = ?0
+                AND l.amount > 0
+                AND e.created_time >= ?4) incoming)`,
+		userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeTransfer, since, tenantID)
+	if err != nil {
+		return activity, fmt.Errorf("[postgres] error on getting account activity: %w", err)
+	}
+	return activity, nil
+}
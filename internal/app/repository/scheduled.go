@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+func (r *Repository) AddScheduledCommand(tx pg.DBI, tenantID string, command *model.ScheduledCommand) (*model.ScheduledCommand, error) {
+	command.TenantID = tenantID
+	_, err := tx.Model(command).Returning("*").Insert()
+	return command, err
+}
+
+func (r *Repository) GetScheduledCommand(tx pg.DBI, tenantID string, id int64, withLock bool) (command model.ScheduledCommand, err error) {
+	query := tx.Model(&command).Where("tenant_id = ?", tenantID).Where("id = ?", id)
+	if withLock {
+		query = query.For("UPDATE")
+	}
+	if err := query.Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			err = model.ErrScheduledNotFound
+		}
+		return model.ScheduledCommand{}, fmt.Errorf("[postgres] error on getting scheduled command: %w", err)
+	}
+	return command, nil
+}
+
+func (r *Repository) UpdateScheduledCommand(tx pg.DBI, command *model.ScheduledCommand) error {
+	_, err := tx.Model(command).Column("status", "error", "executed_time", "claimed_time").WherePK().Where("tenant_id = ?tenant_id").Update()
+	return err
+}
+
+// ClaimDueScheduledCommands moves up to limit commands of all tenants to executing status: pending ones with
+// execute_at before now and executing ones claimed before claimedBefore. Only commands of users whose command
+// shard is one of shards are claimed. Rows locked by other workers are skipped, so every command is claimed
+// by one worker only.
+func (r *Repository) ClaimDueScheduledCommands(
+	tx pg.DBI, now, claimedBefore time.Time, shards []int, shardsCount, limit int,
+) (commands []model.ScheduledCommand, err error) {
+	// shard is uint64(user_id) % shardsCount like in queue.CommandShard
+	_, err = tx.Query(&commands, `
+UPDATE scheduled_commands
+SET status       = ?0,
+    claimed_time = ?2,
+    attempts     = attempts + 1
+WHERE id IN (SELECT id
+             FROM scheduled_commands
+             WHERE ((status = ?1 AND execute_at <= ?2) OR (status = ?0 AND claimed_time < ?3))
+               AND mod(user_id::NUMERIC + CASE WHEN user_id < 0 THEN 18446744073709551616 ELSE 0 END, ?4) = ANY (?5)
+             ORDER BY execute_at, id
+             LIMIT ?6 FOR UPDATE SKIP LOCKED)
+RETURNING *`,
+		model.ScheduledStatusExecuting, model.ScheduledStatusPending, now, claimedBefore, shardsCount, pg.Array(shards), limit)
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] error on claiming scheduled commands: %w", err)
+	}
+	return commands, nil
+}
+
+// HasScheduledEvents returns true if executing the scheduled command made any events, i.e. it was executed.
+func (r *Repository) HasScheduledEvents(tx pg.DBI, tenantID string, scheduledID int64) (bool, error) {
+	exists, err := tx.Model((*model.Event)(nil)).
+		Where("tenant_id = ?", tenantID).
+		Where("scheduled_command_id = ?", scheduledID).
+		Where("type NOT IN (?, ?)", model.EventTypeScheduled, model.EventTypeScheduleCancelled).
+		Exists()
+	if err != nil {
+		return false, fmt.Errorf("[postgres] error on checking scheduled command events: %w", err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// BatchTransfer moves money from payerID to several accounts all-or-nothing. Legs must net to zero,
+// payerID must be the only debited user. Limits are checked for every debited user, no fee is charged.
+// Batch is rejected if Config.Screener rejects any of its accounts. All accounts must be in one currency.
+func (s *Service) BatchTransfer(ctx context.Context, payerID int64, legs []*model.EventLeg) error {
+	total, err := model.ValidateLegs(payerID, legs)
+	if err != nil {
+		return err
+	}
+	tenantID := model.TenantIDFromContext(ctx)
+
+	net := make(map[int64]int64)
+	userIDs := make([]int64, 0, len(legs))
+	for _, leg := range legs {
+		if _, ok := net[leg.UserID]; !ok {
+			userIDs = append(userIDs, leg.UserID)
+		}
+		// net of every user is bounded by total checked by ValidateLegs
+		net[leg.UserID] += leg.Amount
+	}
+
+	var events []*model.Event
+	err = s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		balances, err := s.lockBalances(tx, tenantID, userIDs...)
+		if err != nil {
+			return err
+		}
+		if err := model.CheckCurrency(model.CurrencyFromContext(ctx), balancesOf(balances, userIDs)...); err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			if err := s.checkLeg(tx, balances[userID], legs); err != nil {
+				return err
+			}
+		}
+		if err := s.screenAccounts(tx, tenantID, userIDs...); err != nil {
+			return err
+		}
+
+		event, err := s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:        model.EventTypeBatchTransfer,
+			FromUserID:  payerID,
+			Amount:      &total,
+			Currency:    balances[payerID].Currency,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.r.AddEventLegs(tx, tenantID, event.ID, legs); err != nil {
+			return err
+		}
+		event.Legs = legs
+		events = append(events, event)
+
+		for _, userID := range userIDs {
+			balance := balances[userID]
+			newBalance, err := balance.AfterCredit(net[userID])
+			if err != nil {
+				return err
+			}
+			if err := s.r.UpdateBalance(tx, tenantID, userID, newBalance); err != nil {
+				return err
+			}
+			if err := s.addOverdraftEvent(tx, &events, balance, newBalance); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvents(ctx, events)
+}
+
+// checkLeg checks account may take part in the batch with its debits and credits.
+func (s *Service) checkLeg(tx pg.DBI, balance model.Balance, legs []*model.EventLeg) error {
+	debit, credit := model.LegTotals(balance.UserID, legs)
+	if err := balance.CheckLegs(debit, credit); err != nil || debit == 0 {
+		return err
+	}
+	return s.checkLimits(tx, balance.TenantID, balance.UserID, debit)
+}
+
+// balancesOf returns balances of userIDs in their order.
+func balancesOf(balances map[int64]model.Balance, userIDs []int64) []model.Balance {
+	list := make([]model.Balance, 0, len(userIDs))
+	for _, userID := range userIDs {
+		list = append(list, balances[userID])
+	}
+	return list
+}
+
+// lockBalances locks balances in ascending order of user ids, so that concurrent operations
+// on the same accounts can't deadlock.
+func (s *Service) lockBalances(tx pg.DBI, tenantID string, userIDs ...int64) (map[int64]model.Balance, error) {
+	sorted := append([]int64(nil), userIDs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	balances := make(map[int64]model.Balance, len(sorted))
+	for _, userID := range sorted {
+		if _, ok := balances[userID]; ok {
+			continue
+		}
+		balance, err := s.r.GetBalance(tx, tenantID, userID, true)
+		if err != nil {
+			return nil, err
+		}
+		balances[userID] = balance
+	}
+	return balances, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// SetCreditLimit sets how far below zero balance of the account may go.
+// Limit can't be set lower than the current debt.
+func (s *Service) SetCreditLimit(ctx context.Context, userID, creditLimit int64) error {
+	if creditLimit < 0 {
+		return model.ErrNegativeAmount
+	}
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		balance, err := s.r.GetBalance(tx, tenantID, userID, true)
+		if err != nil {
+			return err
+		}
+		if err := model.CheckCurrency(model.CurrencyFromContext(ctx), balance); err != nil {
+			return err
+		}
+		if err := balance.CheckCreditLimit(creditLimit); err != nil {
+			return err
+		}
+
+		if err := s.r.UpdateCreditLimit(tx, tenantID, userID, creditLimit); err != nil {
+			return err
+		}
+
+		event = &model.Event{
+			Type:        model.EventTypeCreditLimit,
+			FromUserID:  userID,
+			Amount:      &creditLimit,
+			Currency:    balance.Currency,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		}
+		event, err = s.r.AddEvent(tx, tenantID, event)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// addOverdraftEvent adds overdraft event to events if balance went below zero with this operation.
+func (s *Service) addOverdraftEvent(tx pg.DBI, events *[]*model.Event, before model.Balance, newBalance int64) error {
+	if before.Balance < 0 || newBalance >= 0 {
+		return nil
+	}
+	event, err := s.r.AddEvent(tx, before.TenantID, &model.Event{
+		Type:        model.EventTypeOverdraft,
+		FromUserID:  before.UserID,
+		Amount:      &newBalance,
+		Currency:    before.Currency,
+		CreatedTime: time.Now(),
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	})
+	if err != nil {
+		return err
+	}
+	*events = append(*events, event)
+	return nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// SetFeeRule adds new version of fee rule, it is applied to operations starting from the next one.
+func (s *Service) SetFeeRule(ctx context.Context, rule model.FeeRule) error {
+	if s.cfg.FeeAccountID == 0 {
+		return model.ErrFeesDisabled
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		if _, err := s.r.GetBalance(tx, tenantID, s.cfg.FeeAccountID, false); err != nil {
+			return err
+		}
+
+		rule.ID = 0
+		rule.CreatedTime = time.Now()
+		added, err := s.r.AddFeeRule(tx, tenantID, &rule)
+		if err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:        model.EventTypeFeeRule,
+			FromUserID:  s.cfg.FeeAccountID,
+			FeeRuleID:   &added.ID,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// calculateFee returns fee the payer pays for the operation on top of amount and rule that was used,
+// rule is nil if there is no fee. Fee account must be in the currency of the payer.
+func (s *Service) calculateFee(tx pg.DBI, operation model.EventType, payer model.Balance, amount int64) (int64, *model.FeeRule, error) {
+	if s.cfg.FeeAccountID == 0 || payer.UserID == s.cfg.FeeAccountID {
+		return 0, nil, nil
+	}
+	rule, err := s.r.GetCurrentFeeRule(tx, payer.TenantID, operation)
+	if err != nil || rule == nil || rule.Kind == model.FeeKindNone {
+		return 0, nil, err
+	}
+	feeAccount, err := s.r.GetBalance(tx, payer.TenantID, s.cfg.FeeAccountID, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := model.CheckCurrency(payer.Currency, feeAccount); err != nil {
+		return 0, nil, fmt.Errorf("fee account: %w", err)
+	}
+	fee, err := rule.Calculate(amount)
+	if err != nil {
+		return 0, nil, err
+	}
+	return fee, rule, nil
+}
+
+// setFee records fee, the rule it was calculated with and the account it is credited to
+// on the event before it is added.
+func (s *Service) setFee(event *model.Event, fee int64, rule *model.FeeRule) {
+	if rule == nil {
+		return
+	}
+	feeAccountID := s.cfg.FeeAccountID
+	event.Fee = &fee
+	event.FeeRuleID = &rule.ID
+	event.FeeAccountID = &feeAccountID
+}
+
+// chargeFee credits fee of the added event to the fee account. It appends fee entry instead of
+// changing the fee account row, so operations paying fees are not serialized on that row.
+func (s *Service) chargeFee(tx pg.DBI, tenantID string, event *model.Event) error {
+	feeAccountID, fee := event.FeeCredit(s.cfg.FeeAccountID)
+	if fee == 0 {
+		return nil
+	}
+	return s.r.AddFeeEntry(tx, tenantID, &model.FeeEntry{
+		AccountID:   feeAccountID,
+		EventID:     event.ID,
+		Amount:      fee,
+		CreatedTime: event.CreatedTime,
+	})
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// SetLimits replaces limits of outgoing operations of the account, amounts are in its currency.
+func (s *Service) SetLimits(ctx context.Context, userID int64, limits model.Limits) error {
+	if limits.MaxAmount < 0 || limits.MaxDailyOutgoing < 0 || limits.MaxMonthlyOutgoing < 0 || limits.MaxHourlyOperations < 0 {
+		return model.ErrNegativeAmount
+	}
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		balance, err := s.r.GetBalance(tx, tenantID, userID, true)
+		if err != nil {
+			return err
+		}
+		if err := model.CheckCurrency(model.CurrencyFromContext(ctx), balance); err != nil {
+			return err
+		}
+
+		if err := s.r.SetAccountLimits(tx, tenantID, userID, limits); err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:        model.EventTypeLimits,
+			FromUserID:  userID,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// checkLimits must be called with balance row of the account locked, otherwise concurrent
+// operations on other workers are not counted.
+func (s *Service) checkLimits(tx pg.DBI, tenantID string, userID, amount int64) error {
+	accountLimits, err := s.r.GetAccountLimits(tx, tenantID, userID)
+	if err != nil {
+		return err
+	}
+	if accountLimits == (model.Limits{}) && s.cfg.GlobalLimits == (model.Limits{}) {
+		return nil
+	}
+
+	usage, err := s.r.GetOutgoingUsage(tx, tenantID, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := accountLimits.Check(usage, amount); err != nil {
+		return err
+	}
+	return s.cfg.GlobalLimits.Check(usage, amount)
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+const defaultMandateRetryDelay = time.Hour
+
+// CreateMandate saves recurring transfer from fromUserID to toUserID, the first one is made at the next run of the schedule.
+// Both accounts must be active and in the currency of ctx if it is set.
+func (s *Service) CreateMandate(ctx context.Context, fromUserID, toUserID, amount int64, terms model.MandateTerms) error {
+	if amount <= 0 {
+		return model.ErrNegativeAmount
+	}
+	if fromUserID == toUserID {
+		return model.ErrSameAccount
+	}
+	if err := terms.Validate(); err != nil {
+		return err
+	}
+	tenantID := model.TenantIDFromContext(ctx)
+
+	mandate := &model.Mandate{
+		FromUserID:   fromUserID,
+		ToUserID:     toUserID,
+		Amount:       amount,
+		MandateTerms: terms,
+		Status:       model.MandateStatusActive,
+		CreatedTime:  time.Now(),
+	}
+	next, finished := mandate.NextRun(mandate.CreatedTime)
+	if finished {
+		return fmt.Errorf("%w: schedule has no runs before end time", model.ErrInvalidSchedule)
+	}
+	mandate.NextRunTime = next
+
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		balances, err := s.lockBalances(tx, tenantID, fromUserID, toUserID)
+		if err != nil {
+			return err
+		}
+		if err := model.CheckCurrency(model.CurrencyFromContext(ctx), balances[fromUserID], balances[toUserID]); err != nil {
+			return err
+		}
+		if err := checkMandateAccounts(balances[fromUserID], balances[toUserID]); err != nil {
+			return err
+		}
+
+		mandate, err := s.r.AddMandate(tx, tenantID, mandate)
+		if err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:        model.EventTypeMandateCreated,
+			FromUserID:  fromUserID,
+			ToUserID:    &toUserID,
+			Amount:      &amount,
+			Currency:    balances[fromUserID].Currency,
+			MandateID:   &mandate.ID,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// CancelMandate stops active or suspended mandate of the user.
+func (s *Service) CancelMandate(ctx context.Context, userID, mandateID int64) error {
+	return s.changeMandateStatus(ctx, userID, mandateID, model.EventTypeMandateCancelled, func(mandate *model.Mandate) error {
+		if mandate.Status != model.MandateStatusActive && mandate.Status != model.MandateStatusSuspended {
+			return fmt.Errorf("%w: mandate is %s", model.ErrWrongMandateStatus, mandate.Status)
+		}
+		mandate.Status = model.MandateStatusCancelled
+		return nil
+	})
+}
+
+// ResumeMandate activates suspended mandate, the next transfer is made at once.
+func (s *Service) ResumeMandate(ctx context.Context, userID, mandateID int64) error {
+	return s.changeMandateStatus(ctx, userID, mandateID, model.EventTypeMandateResumed, func(mandate *model.Mandate) error {
+		if mandate.Status != model.MandateStatusSuspended {
+			return fmt.Errorf("%w: mandate is %s", model.ErrWrongMandateStatus, mandate.Status)
+		}
+		mandate.Status = model.MandateStatusActive
+		mandate.NextRunTime = time.Now()
+		mandate.Failures = 0
+		return nil
+	})
+}
+
+func (s *Service) changeMandateStatus(ctx context.Context, userID, mandateID int64, eventType model.EventType, change func(mandate *model.Mandate) error) error {
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		mandate, err := s.r.GetMandate(tx, tenantID, mandateID, true)
+		if err != nil {
+			return err
+		}
+		if mandate.FromUserID != userID {
+			return fmt.Errorf("%w: mandate %d was not created by user %d", model.ErrMandateNotFound, mandateID, userID)
+		}
+		if err := change(&mandate); err != nil {
+			return err
+		}
+		if err := s.r.UpdateMandate(tx, &mandate); err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:        eventType,
+			FromUserID:  userID,
+			MandateID:   &mandate.ID,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// MandateAuthorizer returns error if the producer of the mandate may not create it anymore,
+// e.g. its policies have changed since. Error must be one of the business errors.
+type MandateAuthorizer func(mandate model.Mandate) error
+
+// RunDueMandate makes transfer of one due mandate of payers in shards of shardsCount, ok is false
+// if there are no due mandates. Transfer and the next run time are saved in one transaction, so every run
+// is made exactly once. Before every run mandate is authorized again with authorize if it is set and both
+// accounts must be active. Transfer is screened, and mandate is suspended if screening rejects or holds it.
+func (s *Service) RunDueMandate(
+	ctx context.Context, shards []int, shardsCount int, authorize MandateAuthorizer,
+) (ok bool, err error) {
+	var events []*model.Event
+	err = s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		now := time.Now()
+		mandate, err := s.r.LockDueMandate(tx, now, shards, shardsCount)
+		if err != nil || mandate == nil {
+			return err
+		}
+		ok = true
+
+		if err := s.r.Savepoint(tx, "mandate_transfer"); err != nil {
+			return err
+		}
+		events, err = s.runMandate(tx, mandate, authorize)
+		code, rejected := model.ErrorCode(err)
+		if err != nil && !rejected {
+			return err
+		}
+
+		next, finished := mandate.NextRun(now)
+		if err == nil {
+			mandate.Failures = 0
+			mandate.LastError = nil
+		} else {
+			if err := s.r.RollbackToSavepoint(tx, "mandate_transfer"); err != nil {
+				return err
+			}
+			events, err = s.failMandate(tx, mandate, code, err)
+			if err != nil {
+				return err
+			}
+			retry := now.Add(s.mandateRetryDelay())
+			if mandate.Status == model.MandateStatusActive && (finished || retry.Before(next)) &&
+				(mandate.EndTime == nil || !retry.After(*mandate.EndTime)) {
+				next, finished = retry, false
+			}
+		}
+
+		if mandate.Status == model.MandateStatusActive {
+			mandate.NextRunTime = next
+			if finished {
+				mandate.Status = model.MandateStatusFinished
+			}
+		}
+		return s.r.UpdateMandate(tx, mandate)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return ok, s.SendEvents(ctx, events)
+}
+
+// runMandate checks that mandate may still be run and makes its transfer.
+func (s *Service) runMandate(tx pg.DBI, mandate *model.Mandate, authorize MandateAuthorizer) ([]*model.Event, error) {
+	if authorize != nil {
+		if err := authorize(*mandate); err != nil {
+			return nil, err
+		}
+	}
+	balances, err := s.lockBalances(tx, mandate.TenantID, mandate.FromUserID, mandate.ToUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMandateAccounts(balances[mandate.FromUserID], balances[mandate.ToUserID]); err != nil {
+		return nil, err
+	}
+	return s.transfer(tx, mandate.TenantID, mandate.FromUserID, mandate.ToUserID, mandate.Amount, "", &mandate.ID, true)
+}
+
+// checkMandateAccounts returns error unless both accounts are active. Recipient may receive transfers
+// while it is frozen, but mandate must not keep paying to it.
+func checkMandateAccounts(from, to model.Balance) error {
+	if err := from.CanSend(); err != nil {
+		return fmt.Errorf("%w: payer %d", err, from.UserID)
+	}
+	if err := to.CanSend(); err != nil {
+		return fmt.Errorf("%w: recipient %d", err, to.UserID)
+	}
+	return nil
+}
+
+// failMandate records rejected transfer, mandate is suspended if it must not be retried.
+func (s *Service) failMandate(tx pg.DBI, mandate *model.Mandate, code string, transferErr error) ([]*model.Event, error) {
+	msg := transferErr.Error()
+	mandate.Failures++
+	mandate.LastError = &msg
+
+	failed, err := s.r.AddEvent(tx, mandate.TenantID, &model.Event{
+		Type:        model.EventTypeMandateFailed,
+		FromUserID:  mandate.FromUserID,
+		Amount:      &mandate.Amount,
+		MandateID:   &mandate.ID,
+		ErrorCode:   &code,
+		CreatedTime: time.Now(),
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := []*model.Event{failed}
+
+	if errors.Is(transferErr, model.ErrNegativeBalance) && mandate.Failures <= mandate.MaxRetries {
+		return events, nil
+	}
+
+	mandate.Status = model.MandateStatusSuspended
+	suspended, err := s.r.AddEvent(tx, mandate.TenantID, &model.Event{
+		Type:        model.EventTypeMandateSuspended,
+		FromUserID:  mandate.FromUserID,
+		MandateID:   &mandate.ID,
+		CreatedTime: time.Now(),
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(events, suspended), nil
+}
+
+func (s *Service) mandateRetryDelay() time.Duration {
+	if s.cfg.MandateRetryDelay > 0 {
+		return s.cfg.MandateRetryDelay
+	}
+	return defaultMandateRetryDelay
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+var ErrReconciliationRunning = errors.New("reconciliation is already running")
+
+// Reconcile compares balances of all accounts with sums of their events and sends balance_mismatch
+// event for every new discrepancy or discrepancy which difference has changed since it was reported.
+// Everything is read from one snapshot, so it may run together with commands.
+func (s *Service) Reconcile(ctx context.Context) (report model.ReconciliationReport, err error) {
+	report.StartedTime = time.Now()
+
+	var events []*model.Event
+	err = s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		if err := s.r.SetSnapshotIsolation(tx); err != nil {
+			return err
+		}
+		locked, err := s.r.TryReconciliationLock(tx)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			return ErrReconciliationRunning
+		}
+
+		if report.Accounts, err = s.r.CountBalances(tx); err != nil {
+			return err
+		}
+		if report.Discrepancies, err = s.r.ListBalanceDiscrepancies(tx, s.cfg.FeeAccountID); err != nil {
+			return err
+		}
+
+		previous, err := s.r.ListReportedDiscrepancies(tx)
+		if err != nil {
+			return err
+		}
+		reportedTimes := make(map[model.ReportedDiscrepancy]time.Time, len(previous))
+		for _, p := range previous {
+			reportedTimes[model.ReportedDiscrepancy{TenantID: p.TenantID, UserID: p.UserID, Difference: p.Difference}] = p.ReportedTime
+		}
+
+		reported := make([]model.ReportedDiscrepancy, 0, len(report.Discrepancies))
+		for _, d := range report.Discrepancies {
+			difference := d.Difference()
+			key := model.ReportedDiscrepancy{TenantID: d.TenantID, UserID: d.UserID, Difference: difference}
+			if reportedTime, ok := reportedTimes[key]; ok {
+				key.ReportedTime = reportedTime
+				reported = append(reported, key)
+				continue
+			}
+			key.ReportedTime = time.Now()
+			reported = append(reported, key)
+
+			event, err := s.r.AddEvent(tx, d.TenantID, &model.Event{
+				Type:        model.EventTypeBalanceMismatch,
+				FromUserID:  d.UserID,
+				Amount:      &difference,
+				CreatedTime: time.Now(),
+				QueueID:     strconv.FormatInt(rand.Int63(), 10),
+			})
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return s.r.SetReportedDiscrepancies(tx, reported)
+	})
+	if err != nil {
+		return report, err
+	}
+	report.FinishedTime = time.Now()
+
+	return report, s.SendEvents(ctx, events)
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/pkg/money"
+)
+
+// Reverse undoes amount of deposit, withdraw or transfer made by userID, nil amount reverses
+// everything that was not reversed yet. Event may be reversed partially several times,
+// but never for more than its amount. Fee of the reversed event is not refunded.
+// Limits are not applied, credit limit and account statuses are, except that money may be
+// returned to a frozen account.
+func (s *Service) Reverse(ctx context.Context, userID, eventID int64, amount *int64) error {
+	if amount != nil && *amount <= 0 {
+		return model.ErrNegativeAmount
+	}
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		// original event is locked, so concurrent reversals of it are serialized
+		original, err := s.r.GetEvent(tx, tenantID, eventID, true)
+		if err != nil {
+			return err
+		}
+		if original.FromUserID != userID {
+			return fmt.Errorf("%w: event %d was not made by user %d", model.ErrEventNotFound, eventID, userID)
+		}
+		if currency := model.CurrencyFromContext(ctx); currency != "" && currency != original.AmountCurrency() {
+			return fmt.Errorf("%w: event %d is in %s, not %s", money.ErrCurrencyMismatch, eventID, original.AmountCurrency(), currency)
+		}
+		// only these events have amount
+		switch original.Type {
+		case model.EventTypeDeposit, model.EventTypeWithdraw, model.EventTypeTransfer:
+		default:
+			return fmt.Errorf("%w: %s", model.ErrNotReversible, original.Type)
+		}
+		if original.Type == model.EventTypeTransfer && !model.ReviewerFromContext(ctx) {
+			return fmt.Errorf("%w: reversal of transfer takes money from user %d, only reviewers can make it",
+				model.ErrForbidden, *original.ToUserID)
+		}
+
+		reversed, err := s.r.GetReversedAmount(tx, tenantID, eventID)
+		if err != nil {
+			return err
+		}
+		remaining, err := money.Sub(*original.Amount, reversed)
+		if err != nil {
+			return err
+		}
+		reverseAmount := remaining
+		if amount != nil {
+			reverseAmount = *amount
+		}
+		if remaining <= 0 || reverseAmount > remaining {
+			return fmt.Errorf("%w: %d of %d is left", model.ErrReversalExceedsAmount, remaining, *original.Amount)
+		}
+
+		if err := s.applyReversal(tx, original, reverseAmount); err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:            model.EventTypeReversal,
+			FromUserID:      original.FromUserID,
+			ToUserID:        original.ToUserID,
+			Amount:          &reverseAmount,
+			Currency:        original.AmountCurrency(),
+			ReversedEventID: &original.ID,
+			CreatedTime:     time.Now(),
+			QueueID:         strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+func (s *Service) applyReversal(tx pg.DBI, original model.Event, amount int64) error {
+	tenantID := original.TenantID
+	switch original.Type {
+	case model.EventTypeDeposit:
+		return s.reverseDebit(tx, tenantID, original.FromUserID, amount)
+	case model.EventTypeWithdraw:
+		return s.reverseCredit(tx, tenantID, original.FromUserID, amount)
+	case model.EventTypeTransfer:
+		if _, err := s.lockBalances(tx, tenantID, original.FromUserID, *original.ToUserID); err != nil {
+			return err
+		}
+		if err := s.reverseCredit(tx, tenantID, original.FromUserID, amount); err != nil {
+			return err
+		}
+		return s.reverseDebit(tx, tenantID, *original.ToUserID, amount)
+	default:
+		return fmt.Errorf("%w: %s", model.ErrNotReversible, original.Type)
+	}
+}
+
+// reverseDebit takes money that was received by the account back.
+func (s *Service) reverseDebit(tx pg.DBI, tenantID string, userID, amount int64) error {
+	balance, err := s.r.GetBalance(tx, tenantID, userID, true)
+	if err != nil {
+		return err
+	}
+	if err := balance.CheckReverseDebit(amount); err != nil {
+		return err
+	}
+	newBalance, err := balance.AfterDebit(amount)
+	if err != nil {
+		return err
+	}
+	return s.r.UpdateBalance(tx, tenantID, userID, newBalance)
+}
+
+// reverseCredit returns money that was sent by the account.
+func (s *Service) reverseCredit(tx pg.DBI, tenantID string, userID, amount int64) error {
+	balance, err := s.r.GetBalance(tx, tenantID, userID, true)
+	if err != nil {
+		return err
+	}
+	if err := balance.CheckCredit(); err != nil {
+		return err
+	}
+	newBalance, err := balance.AfterCredit(amount)
+	if err != nil {
+		return err
+	}
+	return s.r.UpdateBalance(tx, tenantID, userID, newBalance)
+}
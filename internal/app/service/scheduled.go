@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// Schedule saves command of producer to be executed at command.ExecuteAt. Command itself is checked only when executed.
+func (s *Service) Schedule(ctx context.Context, producer string, command model.Command) error {
+	executeAt := *command.ExecuteAt
+	command.ExecuteAt = nil
+	tenantID := model.TenantIDFromContext(ctx)
+	command.TenantID = tenantID
+
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		if _, err := s.r.GetBalance(tx, tenantID, command.FromUserID, false); err != nil {
+			return err
+		}
+
+		scheduled, err := s.r.AddScheduledCommand(tx, tenantID, &model.ScheduledCommand{
+			UserID:      command.FromUserID,
+			Command:     command,
+			Producer:    &producer,
+			ExecuteAt:   executeAt,
+			Status:      model.ScheduledStatusPending,
+			CreatedTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:               model.EventTypeScheduled,
+			FromUserID:         command.FromUserID,
+			ScheduledCommandID: &scheduled.ID,
+			CreatedTime:        time.Now(),
+			QueueID:            strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// CancelScheduled cancels command of the user if it is still pending.
+func (s *Service) CancelScheduled(ctx context.Context, userID, scheduledID int64) error {
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		scheduled, err := s.r.GetScheduledCommand(tx, tenantID, scheduledID, true)
+		if err != nil {
+			return err
+		}
+		if scheduled.UserID != userID {
+			return fmt.Errorf("%w: command %d was not scheduled by user %d", model.ErrScheduledNotFound, scheduledID, userID)
+		}
+		if scheduled.Status != model.ScheduledStatusPending {
+			return fmt.Errorf("%w: command is %s", model.ErrScheduledNotPending, scheduled.Status)
+		}
+
+		scheduled.Status = model.ScheduledStatusCancelled
+		if err := s.r.UpdateScheduledCommand(tx, &scheduled); err != nil {
+			return err
+		}
+
+		event, err = s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:               model.EventTypeScheduleCancelled,
+			FromUserID:         userID,
+			ScheduledCommandID: &scheduled.ID,
+			CreatedTime:        time.Now(),
+			QueueID:            strconv.FormatInt(rand.Int63(), 10),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+// ClaimDueCommands returns due commands of shards of shardsCount that this worker must execute, including commands
+// claimed longer than claimTimeout ago by a worker that probably died. Each of them must be finished with
+// FinishScheduled or put back with ReleaseScheduled.
+func (s *Service) ClaimDueCommands(
+	ctx context.Context, shards []int, shardsCount int, claimTimeout time.Duration, limit int,
+) (commands []model.ScheduledCommand, err error) {
+	now := time.Now()
+	err = s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		commands, err = s.r.ClaimDueScheduledCommands(tx, now, now.Add(-claimTimeout), shards, shardsCount, limit)
+		return err
+	})
+	return commands, err
+}
+
+// WasScheduledExecuted returns true if command claimed not for the first time was executed already
+// by the previous attempt, e.g. worker died before finishing it. Command must be executed with
+// model.ContextWithScheduledCommandID, so that its events are found by the scheduled command id.
+func (s *Service) WasScheduledExecuted(ctx context.Context, command model.ScheduledCommand) (bool, error) {
+	if command.Attempts <= 1 {
+		return false, nil
+	}
+	return s.r.HasScheduledEvents(s.r.GetDB(ctx), command.TenantID, command.ID)
+}
+
+// ReleaseScheduled puts claimed command back to pending, so it is executed again on the next run.
+func (s *Service) ReleaseScheduled(ctx context.Context, command model.ScheduledCommand) error {
+	command.Status = model.ScheduledStatusPending
+	command.ClaimedTime = nil
+	return s.r.UpdateScheduledCommand(s.r.GetDB(ctx), &command)
+}
+
+// FinishScheduled saves result of execution of the command, execErr is nil if command succeeded.
+func (s *Service) FinishScheduled(ctx context.Context, command model.ScheduledCommand, execErr error) error {
+	now := time.Now()
+	command.ExecutedTime = &now
+	command.Status = model.ScheduledStatusDone
+	if execErr != nil {
+		msg := execErr.Error()
+		command.Status = model.ScheduledStatusFailed
+		command.Error = &msg
+	}
+	return s.r.UpdateScheduledCommand(s.r.GetDB(ctx), &command)
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// Screener decides if withdrawal or transfer may be made. It is called in the transaction of the operation
+// after balance and limit checks, when balances of the operation are locked.
+// ScreenAccounts is called the same way for batch transfers and sweeps on close, they can't be held for review.
+type Screener interface {
+	Screen(tx pg.DBI, op model.Operation) (model.Screening, error)
+	ScreenAccounts(tx pg.DBI, tenantID string, userIDs []int64) (model.Screening, error)
+}
+
+type ActivityRepository interface {
+	GetAccountActivity(tx pg.DBI, tenantID string, userID int64, since time.Time) (model.AccountActivity, error)
+}
+
+// RuleScreener is Screener evaluating model.ScreeningRules.
+type RuleScreener struct {
+	r     ActivityRepository
+	rules model.ScreeningRules
+}
+
+func NewRuleScreener(r ActivityRepository, rules model.ScreeningRules) *RuleScreener {
+	return &RuleScreener{r: r, rules: rules}
+}
+
+func (s *RuleScreener) Screen(tx pg.DBI, op model.Operation) (model.Screening, error) {
+	now := time.Now()
+	activity := model.AccountActivity{}
+	if s.rules.NeedsActivity() {
+		var err error
+		activity, err = s.r.GetAccountActivity(tx, op.TenantID, op.FromUserID, now.Add(-time.Duration(s.rules.RapidWindow)))
+		if err != nil {
+			return model.Screening{}, err
+		}
+	}
+	return s.rules.Evaluate(op, activity, now), nil
+}
+
+// ScreenAccounts rejects operation if any of its accounts is blocked.
+func (s *RuleScreener) ScreenAccounts(_ pg.DBI, _ string, userIDs []int64) (model.Screening, error) {
+	return s.rules.CheckBlocked(userIDs...), nil
+}
+
+// screen checks operation with Config.Screener. If operation is held for review, the review event is
+// returned and operation must not be made.
+func (s *Service) screen(tx pg.DBI, op model.Operation) ([]*model.Event, error) {
+	if s.cfg.Screener == nil {
+		return nil, nil
+	}
+	screening, err := s.cfg.Screener.Screen(tx, op)
+	if err != nil {
+		return nil, err
+	}
+
+	switch screening.Decision {
+	case model.ScreeningReject:
+		// reason is not returned, so that senders can't find out thresholds
+		return nil, fmt.Errorf("%w: rule %s", model.ErrScreeningRejected, screening.Rule)
+	case model.ScreeningReview:
+		if op.MandateID != nil {
+			return nil, fmt.Errorf("%w: rule %s, mandate transfer can't be held", model.ErrScreeningRejected, screening.Rule)
+		}
+		review, err := s.r.AddReview(tx, op.TenantID, &model.Review{
+			UserID:      op.FromUserID,
+			Command:     op.Command(),
+			Rule:        screening.Rule,
+			Reason:      screening.Reason,
+			Status:      model.ReviewStatusPending,
+			CreatedTime: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		event, err := s.r.AddEvent(tx, op.TenantID, &model.Event{
+			Type:        model.EventTypeReviewHeld,
+			FromUserID:  op.FromUserID,
+			ReviewID:    &review.ID,
+			CreatedTime: time.Now(),
+			QueueID:     strconv.FormatInt(rand.Int63(), 10),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []*model.Event{event}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// screenAccounts checks accounts of operation that can't be held for review with Config.Screener,
+// so it is rejected by any rule holding or rejecting it.
+func (s *Service) screenAccounts(tx pg.DBI, tenantID string, userIDs ...int64) error {
+	if s.cfg.Screener == nil {
+		return nil
+	}
+	screening, err := s.cfg.Screener.ScreenAccounts(tx, tenantID, userIDs)
+	if err != nil {
+		return err
+	}
+	if screening.Decision != model.ScreeningAllow {
+		return fmt.Errorf("%w: rule %s", model.ErrScreeningRejected, screening.Rule)
+	}
+	return nil
+}
+
+// ApproveReview makes the held operation without screening. If operation fails now, e.g. because
+// balance has changed, the review stays pending. Producer of the held command can't approve it.
+func (s *Service) ApproveReview(ctx context.Context, userID, reviewID int64) error {
+	tenantID := model.TenantIDFromContext(ctx)
+	var events []*model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		review, err := s.lockPendingReview(tx, tenantID, userID, reviewID, model.ProducerFromContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		command := review.Command
+		var opEvents []*model.Event
+		switch command.Type {
+		case model.CommandTypeWithdraw:
+			opEvents, err = s.withdraw(tx, tenantID, command.FromUserID, *command.Amount, command.Currency, false)
+		case model.CommandTypeTransfer:
+			opEvents, err = s.transfer(tx, tenantID, command.FromUserID, *command.ToUserID, *command.Amount, command.Currency, nil, false)
+		default:
+			err = fmt.Errorf("%w: %q can't be reviewed", model.ErrUnknownCommand, command.Type)
+		}
+		if err != nil {
+			return err
+		}
+
+		event, err := s.decideReview(tx, &review, model.ReviewStatusApproved, model.EventTypeReviewApproved)
+		if err != nil {
+			return err
+		}
+		events = append([]*model.Event{event}, opEvents...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvents(ctx, events)
+}
+
+// RejectReview drops the held operation. Producer of the held command can't reject it.
+func (s *Service) RejectReview(ctx context.Context, userID, reviewID int64) error {
+	tenantID := model.TenantIDFromContext(ctx)
+	var event *model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+		review, err := s.lockPendingReview(tx, tenantID, userID, reviewID, model.ProducerFromContext(ctx))
+		if err != nil {
+			return err
+		}
+		event, err = s.decideReview(tx, &review, model.ReviewStatusRejected, model.EventTypeReviewRejected)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvent(ctx, event)
+}
+
+func (s *Service) lockPendingReview(tx pg.DBI, tenantID string, userID, reviewID int64, reviewer *string) (model.Review, error) {
+	review, err := s.r.GetReview(tx, tenantID, reviewID, true)
+	if err != nil {
+		return review, err
+	}
+	if review.UserID != userID {
+		return review, fmt.Errorf("%w: review %d is not of user %d", model.ErrReviewNotFound, reviewID, userID)
+	}
+	if reviewer != nil && review.Producer != nil && *reviewer == *review.Producer {
+		return review, fmt.Errorf("%w: %q can't decide review of its own command", model.ErrForbidden, *reviewer)
+	}
+	if review.Status != model.ReviewStatusPending {
+		return review, fmt.Errorf("%w: review is %s", model.ErrReviewNotPending, review.Status)
+	}
+	return review, nil
+}
+
+func (s *Service) decideReview(
+	tx pg.DBI, review *model.Review, status model.ReviewStatus, eventType model.EventType,
+) (*model.Event, error) {
+	now := time.Now()
+	review.Status = status
+	review.DecidedTime = &now
+	if err := s.r.UpdateReview(tx, review); err != nil {
+		return nil, err
+	}
+	return s.r.AddEvent(tx, review.TenantID, &model.Event{
+		Type:        eventType,
+		FromUserID:  review.UserID,
+		ReviewID:    &review.ID,
+		CreatedTime: now,
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	})
+}
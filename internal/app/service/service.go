@@ -14,37 +14,96 @@ import (
 	"github.com/itimofeev/simple-billing/internal/app/model"
 )
 
+// Repository methods with tenantID read and change only rows of the tenant, the rest work with rows of all tenants.
 type Repository interface {
-	GetBalance(tx pg.DBI, userID int64, withLock bool) (model.Balance, error)
+	GetBalance(tx pg.DBI, tenantID string, userID int64, withLock bool) (model.Balance, error)
 
 	GetDB(ctx context.Context) pg.DBI
 	DoInTX(ctx context.Context, f func(tx pg.DBI) error) error
-	CreateAccount(tx pg.DBI, userID int64) error
-	UpdateBalance(tx pg.DBI, userID, newBalance int64) error
-
-	AddEvent(tx pg.DBI, event *model.Event) (*model.Event, error)
+	CreateAccount(tx pg.DBI, tenantID string, userID int64, currency string) error
+	UpdateBalance(tx pg.DBI, tenantID string, userID, newBalance int64) error
+	UpdateStatus(tx pg.DBI, tenantID string, userID int64, status model.AccountStatus) error
+	UpdateCreditLimit(tx pg.DBI, tenantID string, userID, creditLimit int64) error
+
+	GetAccountLimits(tx pg.DBI, tenantID string, userID int64) (model.Limits, error)
+	SetAccountLimits(tx pg.DBI, tenantID string, userID int64, limits model.Limits) error
+	GetOutgoingUsage(tx pg.DBI, tenantID string, userID int64, now time.Time) (model.OutgoingUsage, error)
+
+	AddScheduledCommand(tx pg.DBI, tenantID string, command *model.ScheduledCommand) (*model.ScheduledCommand, error)
+	GetScheduledCommand(tx pg.DBI, tenantID string, id int64, withLock bool) (model.ScheduledCommand, error)
+	UpdateScheduledCommand(tx pg.DBI, command *model.ScheduledCommand) error
+	ClaimDueScheduledCommands(tx pg.DBI, now, claimedBefore time.Time, shards []int, shardsCount, limit int) ([]model.ScheduledCommand, error)
+	HasScheduledEvents(tx pg.DBI, tenantID string, scheduledID int64) (bool, error)
+
+	AddMandate(tx pg.DBI, tenantID string, mandate *model.Mandate) (*model.Mandate, error)
+	GetMandate(tx pg.DBI, tenantID string, id int64, withLock bool) (model.Mandate, error)
+	UpdateMandate(tx pg.DBI, mandate *model.Mandate) error
+	LockDueMandate(tx pg.DBI, now time.Time, shards []int, shardsCount int) (*model.Mandate, error)
+
+	AddReview(tx pg.DBI, tenantID string, review *model.Review) (*model.Review, error)
+	GetReview(tx pg.DBI, tenantID string, id int64, withLock bool) (model.Review, error)
+	UpdateReview(tx pg.DBI, review *model.Review) error
+	Savepoint(tx pg.DBI, name string) error
+	RollbackToSavepoint(tx pg.DBI, name string) error
+
+	SetSnapshotIsolation(tx pg.DBI) error
+	TryReconciliationLock(tx pg.DBI) (bool, error)
+	CountBalances(tx pg.DBI) (int, error)
+	ListBalanceDiscrepancies(tx pg.DBI, feeAccountID int64) ([]model.Discrepancy, error)
+	ListReportedDiscrepancies(tx pg.DBI) ([]model.ReportedDiscrepancy, error)
+	SetReportedDiscrepancies(tx pg.DBI, reported []model.ReportedDiscrepancy) error
+
+	GetCurrentFeeRule(tx pg.DBI, tenantID string, operation model.EventType) (*model.FeeRule, error)
+	AddFeeRule(tx pg.DBI, tenantID string, rule *model.FeeRule) (*model.FeeRule, error)
+	AddFeeEntry(tx pg.DBI, tenantID string, entry *model.FeeEntry) error
+
+	AddEvent(tx pg.DBI, tenantID string, event *model.Event) (*model.Event, error)
+	AddEventLegs(tx pg.DBI, tenantID string, eventID int64, legs []*model.EventLeg) error
+	GetEvent(tx pg.DBI, tenantID string, eventID int64, withLock bool) (model.Event, error)
+	GetReversedAmount(tx pg.DBI, tenantID string, eventID int64) (int64, error)
 
 	SetMessageID(tx pg.DBI, event *model.Event, messageID string) error
 	SetMessageSent(tx pg.DBI, messageID string, now time.Time) error
+	ClaimUnsentEvents(tx pg.DBI, afterID int64, createdBefore time.Time, limit int) ([]model.Event, error)
+	SetEventSent(tx pg.DBI, event *model.Event, now time.Time) error
 }
 
 type Queue interface {
 	PublishOperationCompleted(_ context.Context, event *model.Event, handler stan.AckHandler) (string, error)
+	RepublishOperationCompleted(_ context.Context, event *model.Event) error
+}
+
+type Config struct {
+	// GlobalLimits apply to all accounts together with limits of the account.
+	GlobalLimits model.Limits
+	// FeeAccountID is the system account fees are credited to, 0 disables fees.
+	FeeAccountID int64
+	// MandateRetryDelay is how long to wait before retrying mandate transfer rejected because of insufficient funds.
+	MandateRetryDelay time.Duration
+	// Screener checks withdrawals and transfers requested by commands, nil allows all of them.
+	Screener Screener
 }
 
 type Service struct {
-	r Repository
-	q Queue
+	r   Repository
+	q   Queue
+	cfg Config
 }
 
-func New(r Repository, q Queue) *Service {
-	return &Service{r: r, q: q}
+func New(r Repository, q Queue, cfg Config) *Service {
+	return &Service{r: r, q: q, cfg: cfg}
 }
 
+// CreateAccount opens account in the currency of ctx, model.DefaultCurrency if it is not set.
 func (s *Service) CreateAccount(ctx context.Context, userID int64) error {
+	tenantID := model.TenantIDFromContext(ctx)
+	currency := model.CurrencyFromContext(ctx)
+	if currency == "" {
+		currency = model.DefaultCurrency.Code
+	}
 	var event *model.Event
 	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
-		_, err := s.r.GetBalance(tx, userID, true)
+		_, err := s.r.GetBalance(tx, tenantID, userID, true)
 		if err == nil {
 			return model.ErrAlreadyExists
 		}
@@ -52,17 +111,18 @@ func (s *Service) CreateAccount(ctx context.Context, userID int64) error {
 			return err
 		}
 
-		if err := s.r.CreateAccount(tx, userID); err != nil {
+		if err := s.r.CreateAccount(tx, tenantID, userID, currency); err != nil {
 			return err
 		}
 
 		event = &model.Event{
 			Type:        model.EventTypeOpen,
 			FromUserID:  userID,
+			Currency:    currency,
 			CreatedTime: time.Now(),
 			QueueID:     strconv.FormatInt(rand.Int63(), 10),
 		}
-		event, err = s.r.AddEvent(tx, event)
+		event, err = s.r.AddEvent(tx, tenantID, event)
 		return err
 	})
 	if err != nil {
@@ -76,14 +136,25 @@ func (s *Service) Deposit(ctx context.Context, userID, amount int64) error {
 	if amount < 0 {
 		return model.ErrNegativeAmount
 	}
+	tenantID := model.TenantIDFromContext(ctx)
 	var event *model.Event
 	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
-		balance, err := s.r.GetBalance(tx, userID, true)
+		balance, err := s.r.GetBalance(tx, tenantID, userID, true)
 		if err != nil {
 			return err
 		}
+		if err := model.CheckCurrency(model.CurrencyFromContext(ctx), balance); err != nil {
+			return err
+		}
+		if err := balance.CheckCredit(); err != nil {
+			return err
+		}
 
-		if err := s.r.UpdateBalance(tx, userID, balance.Balance+amount); err != nil {
+		newBalance, err := balance.AfterCredit(amount)
+		if err != nil {
+			return err
+		}
+		if err := s.r.UpdateBalance(tx, tenantID, userID, newBalance); err != nil {
 			return err
 		}
 
@@ -91,10 +162,11 @@ func (s *Service) Deposit(ctx context.Context, userID, amount int64) error {
 			Type:        model.EventTypeDeposit,
 			FromUserID:  userID,
 			Amount:      &amount,
+			Currency:    balance.Currency,
 			CreatedTime: time.Now(),
 			QueueID:     strconv.FormatInt(rand.Int63(), 10),
 		}
-		event, err = s.r.AddEvent(tx, event)
+		event, err = s.r.AddEvent(tx, tenantID, event)
 		return err
 	})
 
@@ -106,34 +178,238 @@ func (s *Service) Deposit(ctx context.Context, userID, amount int64) error {
 }
 
 func (s *Service) Withdraw(ctx context.Context, userID, amount int64) error {
+	var events []*model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) (err error) {
+		events, err = s.withdraw(tx, model.TenantIDFromContext(ctx), userID, amount, model.CurrencyFromContext(ctx), true)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvents(ctx, events)
+}
+
+// withdraw takes amount from the account in tx, operation is checked by Config.Screener if screen is true.
+// Amount is in currency, empty currency means currency of the account.
+// All business errors are returned before anything is written.
+func (s *Service) withdraw(tx pg.DBI, tenantID string, userID, amount int64, currency string, screen bool) ([]*model.Event, error) {
 	if amount < 0 {
-		return model.ErrNegativeAmount
+		return nil, model.ErrNegativeAmount
+	}
+	balance, err := s.r.GetBalance(tx, tenantID, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.CheckCurrency(currency, balance); err != nil {
+		return nil, err
+	}
+	if err := balance.CanSend(); err != nil {
+		return nil, err
+	}
+	fee, feeRule, err := s.calculateFee(tx, model.EventTypeWithdraw, balance, amount)
+	if err != nil {
+		return nil, err
+	}
+	debit, err := model.DebitWithFee(amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := balance.CheckDebit(debit); err != nil {
+		return nil, err
+	}
+	if err := s.checkLimits(tx, tenantID, userID, amount); err != nil {
+		return nil, err
+	}
+	if screen {
+		held, err := s.screen(tx, model.Operation{
+			TenantID: tenantID, Type: model.EventTypeWithdraw, FromUserID: userID, Amount: amount, Currency: balance.Currency,
+		})
+		if err != nil || held != nil {
+			return held, err
+		}
+	}
+
+	newBalance, err := balance.AfterDebit(debit)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.r.UpdateBalance(tx, tenantID, userID, newBalance); err != nil {
+		return nil, err
+	}
+
+	event := &model.Event{
+		Type:        model.EventTypeWithdraw,
+		FromUserID:  userID,
+		Amount:      &amount,
+		Currency:    balance.Currency,
+		CreatedTime: time.Now(),
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	}
+	s.setFee(event, fee, feeRule)
+	event, err = s.r.AddEvent(tx, tenantID, event)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.chargeFee(tx, tenantID, event); err != nil {
+		return nil, err
+	}
+	events := []*model.Event{event}
+
+	if err := s.addOverdraftEvent(tx, &events, balance, newBalance); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *Service) GetBalance(ctx context.Context, userID int64) (model.Balance, error) {
+	return s.r.GetBalance(s.r.GetDB(ctx), model.TenantIDFromContext(ctx), userID, false)
+}
+
+func (s *Service) Transfer(ctx context.Context, fromUserID, toUserID, amount int64) error {
+	var events []*model.Event
+	err := s.r.DoInTX(ctx, func(tx pg.DBI) (err error) {
+		events, err = s.transfer(tx, model.TenantIDFromContext(ctx), fromUserID, toUserID, amount, model.CurrencyFromContext(ctx), nil, true)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.SendEvents(ctx, events)
+}
+
+// transfer moves amount in tx, mandateID is set if transfer is made by mandate. Transfer is checked
+// by Config.Screener if screen is true. Both accounts must be in currency of amount, empty currency
+// means currency of the payer. All business errors are returned before anything is written.
+func (s *Service) transfer(
+	tx pg.DBI, tenantID string, fromUserID, toUserID, amount int64, currency string, mandateID *int64, screen bool,
+) ([]*model.Event, error) {
+	if amount < 0 {
+		return nil, model.ErrNegativeAmount
+	}
+	if fromUserID == toUserID {
+		return nil, model.ErrSameAccount
+	}
+
+	balances, err := s.lockBalances(tx, tenantID, fromUserID, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	fromBalance, toBalance := balances[fromUserID], balances[toUserID]
+
+	if err := model.CheckCurrency(currency, fromBalance, toBalance); err != nil {
+		return nil, err
+	}
+	if err := fromBalance.CanSend(); err != nil {
+		return nil, err
+	}
+	fee, feeRule, err := s.calculateFee(tx, model.EventTypeTransfer, fromBalance, amount)
+	if err != nil {
+		return nil, err
+	}
+	debit, err := model.DebitWithFee(amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := fromBalance.CheckDebit(debit); err != nil {
+		return nil, err
 	}
+	if err := s.checkLimits(tx, tenantID, fromUserID, amount); err != nil {
+		return nil, err
+	}
+
+	if err := toBalance.CheckCredit(); err != nil {
+		return nil, err
+	}
+	if screen {
+		held, err := s.screen(tx, model.Operation{
+			TenantID: tenantID, Type: model.EventTypeTransfer, FromUserID: fromUserID, ToUserID: &toUserID, Amount: amount,
+			Currency: fromBalance.Currency, MandateID: mandateID,
+		})
+		if err != nil || held != nil {
+			return held, err
+		}
+	}
+
+	newFromBalance, err := fromBalance.AfterDebit(debit)
+	if err != nil {
+		return nil, err
+	}
+	newToBalance, err := toBalance.AfterCredit(amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.r.UpdateBalance(tx, tenantID, fromUserID, newFromBalance); err != nil {
+		return nil, err
+	}
+
+	if err := s.r.UpdateBalance(tx, tenantID, toUserID, newToBalance); err != nil {
+		return nil, err
+	}
+
+	event := &model.Event{
+		Type:        model.EventTypeTransfer,
+		FromUserID:  fromUserID,
+		ToUserID:    &toUserID,
+		Amount:      &amount,
+		Currency:    fromBalance.Currency,
+		MandateID:   mandateID,
+		CreatedTime: time.Now(),
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	}
+	s.setFee(event, fee, feeRule)
+	event, err = s.r.AddEvent(tx, tenantID, event)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.chargeFee(tx, tenantID, event); err != nil {
+		return nil, err
+	}
+	events := []*model.Event{event}
+
+	if err := s.addOverdraftEvent(tx, &events, fromBalance, newFromBalance); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Freeze forbids sending money from the account, it still may receive money.
+func (s *Service) Freeze(ctx context.Context, userID int64) error {
+	return s.changeStatus(ctx, userID, model.AccountStatusActive, model.AccountStatusFrozen, model.EventTypeFreeze)
+}
+
+func (s *Service) Unfreeze(ctx context.Context, userID int64) error {
+	return s.changeStatus(ctx, userID, model.AccountStatusFrozen, model.AccountStatusActive, model.EventTypeUnfreeze)
+}
+
+func (s *Service) changeStatus(ctx context.Context, userID int64, from, to model.AccountStatus, eventType model.EventType) error {
+	tenantID := model.TenantIDFromContext(ctx)
 	var event *model.Event
 	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
-		balance, err := s.r.GetBalance(tx, userID, true)
+		balance, err := s.r.GetBalance(tx, tenantID, userID, true)
 		if err != nil {
 			return err
 		}
-		if balance.Balance-amount < 0 {
-			return model.ErrNegativeBalance
+		if balance.Status != from {
+			return fmt.Errorf("%w: account is %s", model.ErrWrongAccountStatus, balance.Status)
 		}
 
-		if err := s.r.UpdateBalance(tx, userID, balance.Balance-amount); err != nil {
+		if err := s.r.UpdateStatus(tx, tenantID, userID, to); err != nil {
 			return err
 		}
 
 		event = &model.Event{
-			Type:        model.EventTypeWithdraw,
+			Type:        eventType,
 			FromUserID:  userID,
-			Amount:      &amount,
 			CreatedTime: time.Now(),
 			QueueID:     strconv.FormatInt(rand.Int63(), 10),
 		}
-		event, err = s.r.AddEvent(tx, event)
+		event, err = s.r.AddEvent(tx, tenantID, event)
 		return err
 	})
-
 	if err != nil {
 		return err
 	}
@@ -141,54 +417,106 @@ func (s *Service) Withdraw(ctx context.Context, userID, amount int64) error {
 	return s.SendEvent(ctx, event)
 }
 
-func (s *Service) GetBalance(ctx context.Context, userID int64) (model.Balance, error) {
-	return s.r.GetBalance(s.r.GetDB(ctx), userID, false)
-}
-
-func (s *Service) Transfer(ctx context.Context, fromUserID, toUserID, amount int64) error {
-	if amount < 0 {
-		return model.ErrNegativeAmount
+// Close closes active account. If sweepToUserID is not nil, the rest of the balance is
+// transferred there first, otherwise balance must be zero.
+func (s *Service) Close(ctx context.Context, userID int64, sweepToUserID *int64) error {
+	if sweepToUserID != nil && *sweepToUserID == userID {
+		return model.ErrSameAccount
 	}
-	var event *model.Event
+	tenantID := model.TenantIDFromContext(ctx)
+	lockIDs := []int64{userID}
+	if sweepToUserID != nil {
+		lockIDs = append(lockIDs, *sweepToUserID)
+	}
+	var events []*model.Event
 	err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
-		fromBalance, err := s.r.GetBalance(tx, fromUserID, true)
+		// both accounts are locked in the same order as transfers lock them
+		balances, err := s.lockBalances(tx, tenantID, lockIDs...)
 		if err != nil {
 			return err
 		}
-		if fromBalance.Balance-amount < 0 {
-			return model.ErrNegativeBalance
+		balance := balances[userID]
+		if balance.Status != model.AccountStatusActive {
+			return fmt.Errorf("%w: account is %s", model.ErrWrongAccountStatus, balance.Status)
 		}
 
-		toBalance, err := s.r.GetBalance(tx, toUserID, true)
-		if err != nil {
-			return err
+		if balance.Balance < 0 {
+			return model.ErrNegativeBalance
 		}
-
-		if err := s.r.UpdateBalance(tx, fromUserID, fromBalance.Balance-amount); err != nil {
-			return err
+		if balance.Balance != 0 {
+			if sweepToUserID == nil {
+				return model.ErrNonZeroBalance
+			}
+			sweepEvent, err := s.sweep(tx, balance, balances[*sweepToUserID])
+			if err != nil {
+				return err
+			}
+			events = append(events, sweepEvent)
 		}
 
-		if err := s.r.UpdateBalance(tx, toUserID, toBalance.Balance+amount); err != nil {
+		if err := s.r.UpdateStatus(tx, tenantID, userID, model.AccountStatusClosed); err != nil {
 			return err
 		}
 
-		event = &model.Event{
-			Type:        model.EventTypeTransfer,
-			FromUserID:  fromUserID,
-			ToUserID:    &toUserID,
-			Amount:      &amount,
+		event, err := s.r.AddEvent(tx, tenantID, &model.Event{
+			Type:        model.EventTypeClose,
+			FromUserID:  userID,
 			CreatedTime: time.Now(),
 			QueueID:     strconv.FormatInt(rand.Int63(), 10),
-		}
-		event, err = s.r.AddEvent(tx, event)
+		})
+		events = append(events, event)
 		return err
 	})
-
 	if err != nil {
 		return err
 	}
 
-	return s.SendEvent(ctx, event)
+	return s.SendEvents(ctx, events)
+}
+
+// sweep transfers whole balance of closing account to another one, both must be locked already.
+func (s *Service) sweep(tx pg.DBI, from, toBalance model.Balance) (*model.Event, error) {
+	toUserID := toBalance.UserID
+	if err := model.CheckCurrency("", from, toBalance); err != nil {
+		return nil, err
+	}
+	if err := toBalance.CheckCredit(); err != nil {
+		return nil, err
+	}
+	if err := s.screenAccounts(tx, from.TenantID, from.UserID, toUserID); err != nil {
+		return nil, err
+	}
+
+	newToBalance, err := toBalance.AfterCredit(from.Balance)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.r.UpdateBalance(tx, from.TenantID, from.UserID, 0); err != nil {
+		return nil, err
+	}
+	if err := s.r.UpdateBalance(tx, from.TenantID, toUserID, newToBalance); err != nil {
+		return nil, err
+	}
+
+	amount := from.Balance
+	return s.r.AddEvent(tx, from.TenantID, &model.Event{
+		Type:        model.EventTypeTransfer,
+		FromUserID:  from.UserID,
+		ToUserID:    &toUserID,
+		Amount:      &amount,
+		Currency:    from.Currency,
+		CreatedTime: time.Now(),
+		QueueID:     strconv.FormatInt(rand.Int63(), 10),
+	})
+}
+
+func (s *Service) SendEvents(ctx context.Context, events []*model.Event) error {
+	for _, event := range events {
+		if err := s.SendEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Service) SendEvent(ctx context.Context, event *model.Event) error {
@@ -200,6 +528,50 @@ func (s *Service) SendEvent(ctx context.Context, event *model.Event) error {
 	return s.r.SetMessageID(s.r.GetDB(ctx), event, messageID)
 }
 
+const resendBatchSize = 100
+
+// ResendUnsentEvents publishes once more every event created before createdBefore that has no
+// queue_sent_time, e.g. because worker was stopped before NATS acked it. Events are claimed and marked
+// sent in one transaction, so workers flushing outbox together don't publish the same events twice.
+// Consumers get events at least once anyway: event whose ack is still awaited by the worker that
+// created it may be duplicated.
+func (s *Service) ResendUnsentEvents(ctx context.Context, createdBefore time.Time) (int, error) {
+	sent := 0
+	var lastID int64
+	for {
+		var claimed int
+		var publishErr error
+		err := s.r.DoInTX(ctx, func(tx pg.DBI) error {
+			events, err := s.r.ClaimUnsentEvents(tx, lastID, createdBefore, resendBatchSize)
+			if err != nil {
+				return err
+			}
+			claimed = len(events)
+			for i := range events {
+				// events published before the error are committed as sent
+				if publishErr = s.q.RepublishOperationCompleted(ctx, &events[i]); publishErr != nil {
+					return nil
+				}
+				if err := s.r.SetEventSent(tx, &events[i], time.Now()); err != nil {
+					return err
+				}
+				lastID = events[i].ID
+				sent++
+			}
+			return nil
+		})
+		if err != nil {
+			return sent, err
+		}
+		if publishErr != nil {
+			return sent, publishErr
+		}
+		if claimed < resendBatchSize {
+			return sent, nil
+		}
+	}
+}
+
 func (s *Service) ackHandler(messageID string, err error) {
 	if err != nil {
 		fmt.Println(err) // log
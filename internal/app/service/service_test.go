@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
+	"math"
 	"math/rand"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/itimofeev/simple-billing/internal/app/model"
 	"github.com/itimofeev/simple-billing/internal/app/queue"
 	"github.com/itimofeev/simple-billing/internal/app/repository"
+	"github.com/itimofeev/simple-billing/pkg/money"
 )
 
 type ServiceSuite struct {
@@ -44,7 +47,7 @@ func (s *ServiceSuite) SetupSuite() {
 	s.Require().NoError(err)
 	s.queue = q
 
-	s.srv = New(s.repo, s.queue)
+	s.srv = New(s.repo, s.queue, Config{})
 	s.ctx = context.Background()
 }
 
@@ -68,8 +71,11 @@ func (s *ServiceSuite) Test_GetBalanceOK_IfUserExists() {
 	s.Require().NoError(err)
 
 	expected := model.Balance{
-		UserID:  s.userID,
-		Balance: 0,
+		TenantID: model.DefaultTenant,
+		UserID:   s.userID,
+		Balance:  0,
+		Currency: model.DefaultCurrency.Code,
+		Status:   model.AccountStatusActive,
 	}
 
 	s.Require().Equal(expected, balance)
@@ -87,8 +93,11 @@ func (s *ServiceSuite) Test_Deposit() {
 	s.Require().NoError(err)
 
 	expected := model.Balance{
-		UserID:  s.userID,
-		Balance: 10,
+		TenantID: model.DefaultTenant,
+		UserID:   s.userID,
+		Balance:  10,
+		Currency: model.DefaultCurrency.Code,
+		Status:   model.AccountStatusActive,
 	}
 
 	s.Require().Equal(expected, balance)
@@ -109,8 +118,11 @@ func (s *ServiceSuite) Test_Withdraw() {
 	s.Require().NoError(err)
 
 	expected := model.Balance{
-		UserID:  s.userID,
-		Balance: 7,
+		TenantID: model.DefaultTenant,
+		UserID:   s.userID,
+		Balance:  7,
+		Currency: model.DefaultCurrency.Code,
+		Status:   model.AccountStatusActive,
 	}
 
 	s.Require().Equal(expected, balance)
@@ -147,11 +159,554 @@ func (s *ServiceSuite) Test_Transfer() {
 	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeTransfer)
 }
 
+func (s *ServiceSuite) Test_FrozenAccount_ReceivesButNotSends() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	userID2 := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, userID2))
+	s.Require().NoError(s.srv.Deposit(s.ctx, userID2, 100))
+
+	s.Require().NoError(s.srv.Freeze(s.ctx, s.userID))
+	s.Require().ErrorIs(s.srv.Freeze(s.ctx, s.userID), model.ErrWrongAccountStatus)
+
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 10))
+	s.Require().NoError(s.srv.Transfer(s.ctx, userID2, s.userID, 10))
+	s.Require().ErrorIs(s.srv.Withdraw(s.ctx, s.userID, 1), model.ErrAccountFrozen)
+	s.Require().ErrorIs(s.srv.Transfer(s.ctx, s.userID, userID2, 1), model.ErrAccountFrozen)
+
+	s.Require().NoError(s.srv.Unfreeze(s.ctx, s.userID))
+	s.Require().NoError(s.srv.Withdraw(s.ctx, s.userID, 1))
+
+	s.checkUserEvents(s.userID,
+		model.EventTypeOpen, model.EventTypeFreeze, model.EventTypeDeposit, model.EventTypeUnfreeze, model.EventTypeWithdraw)
+}
+
+func (s *ServiceSuite) Test_Close() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	userID2 := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, userID2))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+
+	s.Require().ErrorIs(s.srv.Close(s.ctx, s.userID, nil), model.ErrNonZeroBalance)
+	s.Require().ErrorIs(s.srv.Close(s.ctx, s.userID, &s.userID), model.ErrSameAccount)
+	s.Require().NoError(s.srv.Close(s.ctx, s.userID, &userID2))
+
+	balance1, err := s.srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(0, balance1.Balance)
+	s.Require().Equal(model.AccountStatusClosed, balance1.Status)
+
+	balance2, err := s.srv.GetBalance(s.ctx, userID2)
+	s.Require().NoError(err)
+	s.Require().EqualValues(100, balance2.Balance)
+
+	s.Require().ErrorIs(s.srv.Deposit(s.ctx, s.userID, 1), model.ErrAccountClosed)
+	s.Require().ErrorIs(s.srv.Transfer(s.ctx, userID2, s.userID, 1), model.ErrAccountClosed)
+
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeTransfer, model.EventTypeClose)
+}
+
+func (s *ServiceSuite) Test_WithdrawWithinCreditLimit() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 10))
+	s.Require().NoError(s.srv.SetCreditLimit(s.ctx, s.userID, 50))
+
+	s.Require().NoError(s.srv.Withdraw(s.ctx, s.userID, 40))
+	s.Require().ErrorIs(s.srv.Withdraw(s.ctx, s.userID, 21), model.ErrNegativeBalance)
+	s.Require().NoError(s.srv.Withdraw(s.ctx, s.userID, 20))
+
+	balance, err := s.srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(-50, balance.Balance)
+
+	s.Require().ErrorIs(s.srv.SetCreditLimit(s.ctx, s.userID, 49), model.ErrNegativeBalance)
+
+	s.checkUserEvents(s.userID,
+		model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeCreditLimit,
+		model.EventTypeWithdraw, model.EventTypeOverdraft, model.EventTypeWithdraw)
+}
+
+func (s *ServiceSuite) Test_ErrorOnWithdraw_IfLimitExceeded() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+	s.Require().NoError(s.srv.SetLimits(s.ctx, s.userID, model.Limits{MaxAmount: 30, MaxDailyOutgoing: 50}))
+
+	s.Require().ErrorIs(s.srv.Withdraw(s.ctx, s.userID, 31), model.ErrLimitExceeded)
+	s.Require().NoError(s.srv.Withdraw(s.ctx, s.userID, 30))
+
+	err := s.srv.Withdraw(s.ctx, s.userID, 30)
+	var limitErr *model.LimitExceededError
+	s.Require().ErrorAs(err, &limitErr)
+	s.Require().Equal(model.LimitUsage{Limit: model.LimitMaxDailyOutgoing, Max: 50, Used: 30, Requested: 30}, limitErr.Usage)
+
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeLimits, model.EventTypeWithdraw)
+}
+
+func (s *ServiceSuite) Test_WithdrawChargesFee() {
+	feeAccountID := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, feeAccountID))
+	srv := New(s.repo, s.queue, Config{FeeAccountID: feeAccountID})
+
+	s.Require().NoError(srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(srv.Deposit(s.ctx, s.userID, 100))
+	s.Require().NoError(srv.SetFeeRule(s.ctx, model.FeeRule{Operation: model.EventTypeWithdraw, Kind: model.FeeKindFlat, Flat: 5}))
+	defer func() {
+		s.Require().NoError(srv.SetFeeRule(s.ctx, model.FeeRule{Operation: model.EventTypeWithdraw, Kind: model.FeeKindNone}))
+	}()
+
+	s.Require().ErrorIs(srv.Withdraw(s.ctx, s.userID, 96), model.ErrNegativeBalance)
+	s.Require().NoError(srv.Withdraw(s.ctx, s.userID, 95))
+
+	balance, err := srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(0, balance.Balance)
+
+	feeBalance, err := srv.GetBalance(s.ctx, feeAccountID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(5, feeBalance.Balance)
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	withdraw := events[len(events)-1]
+	s.Require().Equal(model.EventTypeWithdraw, withdraw.Type)
+	s.Require().EqualValues(5, *withdraw.Fee)
+	s.Require().NotNil(withdraw.FeeRuleID)
+}
+
+func (s *ServiceSuite) Test_ReverseTransferPartially() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	userID2 := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, userID2))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+	s.Require().NoError(s.srv.Transfer(s.ctx, s.userID, userID2, 60))
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	transferID := events[len(events)-1].ID
+
+	// reversal takes money from the recipient, so the sender can't make it
+	s.Require().ErrorIs(s.srv.Reverse(s.ctx, s.userID, transferID, nil), model.ErrForbidden)
+
+	ctx := model.ContextWithReviewer(s.ctx)
+	s.Require().ErrorIs(s.srv.Reverse(ctx, userID2, transferID, nil), model.ErrEventNotFound)
+	s.Require().NoError(s.srv.Reverse(ctx, s.userID, transferID, int64Ptr(20)))
+	s.Require().ErrorIs(s.srv.Reverse(ctx, s.userID, transferID, int64Ptr(41)), model.ErrReversalExceedsAmount)
+	s.Require().NoError(s.srv.Reverse(ctx, s.userID, transferID, nil))
+	s.Require().ErrorIs(s.srv.Reverse(ctx, s.userID, transferID, nil), model.ErrReversalExceedsAmount)
+
+	balance1, err := s.srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(100, balance1.Balance)
+
+	balance2, err := s.srv.GetBalance(s.ctx, userID2)
+	s.Require().NoError(err)
+	s.Require().EqualValues(0, balance2.Balance)
+
+	s.checkUserEvents(s.userID,
+		model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeTransfer, model.EventTypeReversal, model.EventTypeReversal)
+}
+
+func (s *ServiceSuite) Test_BatchTransfer() {
+	sellerID, platformID := rand.Int63(), rand.Int63()
+	for _, userID := range []int64{s.userID, sellerID, platformID} {
+		s.Require().NoError(s.srv.CreateAccount(s.ctx, userID))
+	}
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+
+	legs := func(amount int64) []*model.EventLeg {
+		return []*model.EventLeg{
+			{UserID: s.userID, Amount: -amount},
+			{UserID: sellerID, Amount: amount - 10},
+			{UserID: platformID, Amount: 10},
+		}
+	}
+	s.Require().ErrorIs(s.srv.BatchTransfer(s.ctx, s.userID, legs(101)), model.ErrNegativeBalance)
+	// sender can't take money from other accounts
+	s.Require().ErrorIs(s.srv.BatchTransfer(s.ctx, s.userID, append(legs(50), &model.EventLeg{UserID: sellerID, Amount: -10},
+		&model.EventLeg{UserID: platformID, Amount: 10})), model.ErrInvalidCommand)
+	s.Require().NoError(s.srv.BatchTransfer(s.ctx, s.userID, legs(100)))
+
+	for userID, expected := range map[int64]int64{s.userID: 0, sellerID: 90, platformID: 10} {
+		balance, err := s.srv.GetBalance(s.ctx, userID)
+		s.Require().NoError(err)
+		s.Require().EqualValues(expected, balance.Balance)
+	}
+
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeBatchTransfer)
+}
+
+func (s *ServiceSuite) Test_ScheduleAndCancel() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+
+	executeAt := time.Now().Add(time.Hour)
+	command := model.Command{Type: model.CommandTypeDeposit, FromUserID: s.userID, Amount: int64Ptr(10), ExecuteAt: &executeAt}
+	s.Require().NoError(s.srv.Schedule(s.ctx, "test", command))
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	scheduledID := *events[len(events)-1].ScheduledCommandID
+
+	s.Require().ErrorIs(s.srv.CancelScheduled(s.ctx, rand.Int63(), scheduledID), model.ErrScheduledNotFound)
+	s.Require().NoError(s.srv.CancelScheduled(s.ctx, s.userID, scheduledID))
+	s.Require().ErrorIs(s.srv.CancelScheduled(s.ctx, s.userID, scheduledID), model.ErrScheduledNotPending)
+
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeScheduled, model.EventTypeScheduleCancelled)
+}
+
+func (s *ServiceSuite) Test_ScheduledReleasedAndReclaimed() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+
+	executeAt := time.Now().Add(-time.Minute)
+	command := model.Command{ID: rand.Int63(), Type: model.CommandTypeDeposit, FromUserID: s.userID, Amount: int64Ptr(10), ExecuteAt: &executeAt}
+	s.Require().NoError(s.srv.Schedule(model.ContextWithCommandID(s.ctx, command.ID), "test", command))
+
+	claim := func(claimTimeout time.Duration) model.ScheduledCommand {
+		shards := []int{queue.CommandShard(s.userID)}
+		commands, err := s.srv.ClaimDueCommands(s.ctx, shards, queue.CommandShards, claimTimeout, 1000)
+		s.Require().NoError(err)
+		for _, scheduled := range commands {
+			if scheduled.UserID == s.userID {
+				return scheduled
+			}
+		}
+		s.FailNow("scheduled command is not claimed")
+		return model.ScheduledCommand{}
+	}
+
+	// temporary error puts command back to pending
+	scheduled := claim(time.Hour)
+	s.Require().NoError(s.srv.ReleaseScheduled(s.ctx, scheduled))
+	scheduled = claim(time.Hour)
+	s.Require().Equal(2, scheduled.Attempts)
+	executed, err := s.srv.WasScheduledExecuted(s.ctx, scheduled)
+	s.Require().NoError(err)
+	s.Require().False(executed)
+
+	// events of another command with the same client-chosen id don't count
+	s.Require().NoError(s.srv.Deposit(model.ContextWithCommandID(s.ctx, command.ID), s.userID, 10))
+	executed, err = s.srv.WasScheduledExecuted(s.ctx, scheduled)
+	s.Require().NoError(err)
+	s.Require().False(executed)
+
+	// worker died after executing command, it is claimed again after timeout but not executed twice
+	s.Require().NoError(s.srv.Deposit(model.ContextWithScheduledCommandID(s.ctx, scheduled.ID), s.userID, 10))
+	scheduled = claim(0)
+	s.Require().Equal(3, scheduled.Attempts)
+	executed, err = s.srv.WasScheduledExecuted(s.ctx, scheduled)
+	s.Require().NoError(err)
+	s.Require().True(executed)
+	s.Require().NoError(s.srv.FinishScheduled(s.ctx, scheduled, nil))
+}
+
+func (s *ServiceSuite) Test_MandateSuspendedAndResumed() {
+	userID2 := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, userID2))
+
+	s.Require().ErrorIs(s.srv.CreateMandate(s.ctx, s.userID, userID2, 30, model.MandateTerms{Schedule: "* *"}), model.ErrInvalidSchedule)
+	s.Require().NoError(s.srv.CreateMandate(s.ctx, s.userID, userID2, 30, model.MandateTerms{Schedule: "@monthly"}))
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	mandateID := *events[len(events)-1].MandateID
+
+	runNow := func() {
+		mandate, err := s.repo.GetMandate(s.repo.GetDB(s.ctx), model.DefaultTenant, mandateID, false)
+		s.Require().NoError(err)
+		mandate.NextRunTime = time.Now()
+		s.Require().NoError(s.repo.UpdateMandate(s.repo.GetDB(s.ctx), &mandate))
+		s.runDueMandates(s.srv, nil)
+	}
+
+	runNow()
+	mandate, err := s.repo.GetMandate(s.repo.GetDB(s.ctx), model.DefaultTenant, mandateID, false)
+	s.Require().NoError(err)
+	s.Require().Equal(model.MandateStatusSuspended, mandate.Status)
+
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+	s.Require().NoError(s.srv.ResumeMandate(s.ctx, s.userID, mandateID))
+	s.Require().ErrorIs(s.srv.ResumeMandate(s.ctx, s.userID, mandateID), model.ErrWrongMandateStatus)
+	runNow()
+
+	balance2, err := s.srv.GetBalance(s.ctx, userID2)
+	s.Require().NoError(err)
+	s.Require().EqualValues(30, balance2.Balance)
+
+	s.Require().ErrorIs(s.srv.CancelMandate(s.ctx, userID2, mandateID), model.ErrMandateNotFound)
+	s.Require().NoError(s.srv.CancelMandate(s.ctx, s.userID, mandateID))
+
+	s.checkUserEvents(s.userID,
+		model.EventTypeOpen, model.EventTypeMandateCreated, model.EventTypeMandateFailed, model.EventTypeMandateSuspended,
+		model.EventTypeDeposit, model.EventTypeMandateResumed, model.EventTypeTransfer, model.EventTypeMandateCancelled)
+}
+
+// runDueMandates runs due mandates of the shard of s.userID.
+func (s *ServiceSuite) runDueMandates(srv *Service, authorize MandateAuthorizer) {
+	shards := []int{queue.CommandShard(s.userID)}
+	for ok := true; ok; {
+		var err error
+		ok, err = srv.RunDueMandate(s.ctx, shards, queue.CommandShards, authorize)
+		s.Require().NoError(err)
+	}
+}
+
+func (s *ServiceSuite) Test_MandateNeedsActiveAccountsAndAuthorization() {
+	userID2 := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, userID2))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+
+	s.Require().NoError(s.srv.Freeze(s.ctx, userID2))
+	s.Require().ErrorIs(s.srv.CreateMandate(s.ctx, s.userID, userID2, 30, model.MandateTerms{Schedule: "@monthly"}), model.ErrAccountFrozen)
+	s.Require().NoError(s.srv.Unfreeze(s.ctx, userID2))
+
+	createAndRun := func(authorize MandateAuthorizer, beforeRun func()) model.Mandate {
+		ctx := model.ContextWithProducer(s.ctx, "shop")
+		s.Require().NoError(s.srv.CreateMandate(ctx, s.userID, userID2, 30, model.MandateTerms{Schedule: "@monthly"}))
+		events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+		s.Require().NoError(err)
+		mandate, err := s.repo.GetMandate(s.repo.GetDB(s.ctx), model.DefaultTenant, *events[len(events)-1].MandateID, false)
+		s.Require().NoError(err)
+		s.Require().Equal("shop", *mandate.Producer)
+
+		beforeRun()
+		mandate.NextRunTime = time.Now()
+		s.Require().NoError(s.repo.UpdateMandate(s.repo.GetDB(s.ctx), &mandate))
+		s.runDueMandates(s.srv, authorize)
+		mandate, err = s.repo.GetMandate(s.repo.GetDB(s.ctx), model.DefaultTenant, mandate.ID, false)
+		s.Require().NoError(err)
+		return mandate
+	}
+
+	// recipient frozen after the mandate was created
+	mandate := createAndRun(nil, func() { s.Require().NoError(s.srv.Freeze(s.ctx, userID2)) })
+	s.Require().Equal(model.MandateStatusSuspended, mandate.Status)
+	s.Require().NoError(s.srv.Unfreeze(s.ctx, userID2))
+
+	// producer is not allowed to make the mandate anymore
+	mandate = createAndRun(func(mandate model.Mandate) error {
+		return model.Policies{}.Authorize(*mandate.Producer, model.Command{Type: model.CommandTypeCreateMandate})
+	}, func() {})
+	s.Require().Equal(model.MandateStatusSuspended, mandate.Status)
+
+	balance, err := s.srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(100, balance.Balance)
+}
+
+func (s *ServiceSuite) Test_ReconcileReportsChangedBalance() {
+	userID2 := rand.Int63()
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, userID2))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, 100))
+	s.Require().NoError(s.srv.Transfer(s.ctx, s.userID, userID2, 40))
+
+	userDiscrepancy := func(report model.ReconciliationReport) *model.Discrepancy {
+		for _, d := range report.Discrepancies {
+			if d.UserID == s.userID {
+				return &d
+			}
+		}
+		return nil
+	}
+
+	report, err := s.srv.Reconcile(s.ctx)
+	s.Require().NoError(err)
+	s.Require().Nil(userDiscrepancy(report))
+
+	s.Require().NoError(s.repo.UpdateBalance(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID, 70))
+	report, err = s.srv.Reconcile(s.ctx)
+	s.Require().NoError(err)
+	s.Require().Equal(&model.Discrepancy{UserID: s.userID, Balance: 70, Expected: 60}, userDiscrepancy(report))
+
+	// discrepancy that was reported already is not sent again until the difference changes
+	report, err = s.srv.Reconcile(s.ctx)
+	s.Require().NoError(err)
+	s.Require().NotNil(userDiscrepancy(report))
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeTransfer, model.EventTypeBalanceMismatch)
+
+	s.Require().NoError(s.repo.UpdateBalance(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID, 80))
+	_, err = s.srv.Reconcile(s.ctx)
+	s.Require().NoError(err)
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeTransfer,
+		model.EventTypeBalanceMismatch, model.EventTypeBalanceMismatch)
+}
+
+func (s *ServiceSuite) Test_TakeTokenEmptiesBucket() {
+	key := "test:" + strconv.FormatInt(s.userID, 10)
+	limit := model.RateLimit{Rate: 0.001, Burst: 2}
+
+	for i := 0; i < limit.Burst; i++ {
+		ok, err := s.repo.TakeToken(s.repo.GetDB(s.ctx), model.DefaultTenant, key, limit)
+		s.Require().NoError(err)
+		s.Require().True(ok)
+	}
+	ok, err := s.repo.TakeToken(s.repo.GetDB(s.ctx), model.DefaultTenant, key, limit)
+	s.Require().NoError(err)
+	s.Require().False(ok)
+
+	// buckets of other tenants are separate
+	ok, err = s.repo.TakeToken(s.repo.GetDB(s.ctx), "other", key, limit)
+	s.Require().NoError(err)
+	s.Require().True(ok)
+}
+
+func (s *ServiceSuite) Test_ScreeningHoldsAndApprovesWithdrawal() {
+	srv := New(s.repo, s.queue, Config{Screener: NewRuleScreener(s.repo, model.ScreeningRules{
+		ReviewAmount: 50, RejectAmount: 1000,
+	})})
+	s.Require().NoError(srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(srv.Deposit(s.ctx, s.userID, 100))
+
+	s.Require().ErrorIs(srv.Withdraw(s.ctx, s.userID, 1000), model.ErrNegativeBalance)
+	s.Require().NoError(srv.Withdraw(s.ctx, s.userID, 10))
+	s.Require().NoError(srv.Withdraw(s.ctx, s.userID, 60))
+
+	balance, err := srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(90, balance.Balance)
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	reviewID := *events[len(events)-1].ReviewID
+
+	s.Require().ErrorIs(srv.ApproveReview(s.ctx, s.userID+1, reviewID), model.ErrReviewNotFound)
+	s.Require().NoError(srv.ApproveReview(s.ctx, s.userID, reviewID))
+	s.Require().ErrorIs(srv.RejectReview(s.ctx, s.userID, reviewID), model.ErrReviewNotPending)
+
+	balance, err = srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(30, balance.Balance)
+
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit, model.EventTypeWithdraw,
+		model.EventTypeReviewHeld, model.EventTypeWithdraw, model.EventTypeReviewApproved)
+}
+
+func (s *ServiceSuite) Test_ScreeningCoversMandatesBatchesAndSweeps() {
+	blockedID, userID2 := rand.Int63(), rand.Int63()
+	srv := New(s.repo, s.queue, Config{Screener: NewRuleScreener(s.repo, model.ScreeningRules{
+		ReviewAmount: 50, BlockedAccounts: []int64{blockedID},
+	})})
+	for _, userID := range []int64{s.userID, userID2, blockedID} {
+		s.Require().NoError(srv.CreateAccount(s.ctx, userID))
+	}
+	s.Require().NoError(srv.Deposit(s.ctx, s.userID, 100))
+
+	s.Require().ErrorIs(srv.BatchTransfer(s.ctx, s.userID, []*model.EventLeg{
+		{UserID: s.userID, Amount: -20}, {UserID: userID2, Amount: 10}, {UserID: blockedID, Amount: 10},
+	}), model.ErrScreeningRejected)
+	s.Require().ErrorIs(srv.Close(s.ctx, s.userID, &blockedID), model.ErrScreeningRejected)
+
+	// mandate transfer that would be held is rejected, nobody waits for it
+	s.Require().NoError(srv.CreateMandate(s.ctx, s.userID, userID2, 60, model.MandateTerms{Schedule: "@monthly"}))
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	mandate, err := s.repo.GetMandate(s.repo.GetDB(s.ctx), model.DefaultTenant, *events[len(events)-1].MandateID, false)
+	s.Require().NoError(err)
+	mandate.NextRunTime = time.Now()
+	s.Require().NoError(s.repo.UpdateMandate(s.repo.GetDB(s.ctx), &mandate))
+	s.runDueMandates(srv, nil)
+
+	balance, err := srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(100, balance.Balance)
+	s.checkUserEvents(s.userID, model.EventTypeOpen, model.EventTypeDeposit,
+		model.EventTypeMandateCreated, model.EventTypeMandateFailed, model.EventTypeMandateSuspended)
+}
+
+func (s *ServiceSuite) Test_CurrencyMismatchIsRejected() {
+	usdID := rand.Int63()
+	usdCtx := model.ContextWithCurrency(s.ctx, money.USD.Code)
+	rubCtx := model.ContextWithCurrency(s.ctx, money.RUB.Code)
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.CreateAccount(usdCtx, usdID))
+
+	s.Require().NoError(s.srv.Deposit(usdCtx, usdID, 100))
+	s.Require().NoError(s.srv.Deposit(s.ctx, usdID, 100))
+	s.Require().ErrorIs(s.srv.Deposit(rubCtx, usdID, 100), money.ErrCurrencyMismatch)
+	s.Require().ErrorIs(s.srv.Transfer(s.ctx, usdID, s.userID, 10), money.ErrCurrencyMismatch)
+	s.Require().ErrorIs(s.srv.BatchTransfer(s.ctx, usdID, []*model.EventLeg{
+		{UserID: usdID, Amount: -10}, {UserID: s.userID, Amount: 10},
+	}), money.ErrCurrencyMismatch)
+	s.Require().ErrorIs(s.srv.Close(s.ctx, usdID, &s.userID), money.ErrCurrencyMismatch)
+
+	balance, err := s.srv.GetBalance(s.ctx, usdID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(200, balance.Balance)
+	s.Require().Equal(money.USD.Code, balance.Currency)
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, usdID)
+	s.Require().NoError(err)
+	for _, event := range events {
+		s.Require().Equal(money.USD.Code, event.Currency)
+	}
+}
+
+func (s *ServiceSuite) Test_ProducerCantDecideReviewOfItsCommand() {
+	srv := New(s.repo, s.queue, Config{Screener: NewRuleScreener(s.repo, model.ScreeningRules{ReviewAmount: 50})})
+	shopCtx := model.ContextWithProducer(s.ctx, "shop")
+	s.Require().NoError(srv.CreateAccount(shopCtx, s.userID))
+	s.Require().NoError(srv.Deposit(shopCtx, s.userID, 100))
+	s.Require().NoError(srv.Withdraw(shopCtx, s.userID, 60))
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	reviewID := *events[len(events)-1].ReviewID
+
+	s.Require().ErrorIs(srv.ApproveReview(shopCtx, s.userID, reviewID), model.ErrForbidden)
+	s.Require().ErrorIs(srv.RejectReview(shopCtx, s.userID, reviewID), model.ErrForbidden)
+	s.Require().NoError(srv.RejectReview(model.ContextWithProducer(s.ctx, "admin"), s.userID, reviewID))
+}
+
+func (s *ServiceSuite) Test_ErrorOnReverse_IfEventHasNoAmount() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.Freeze(s.ctx, s.userID))
+
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, s.userID)
+	s.Require().NoError(err)
+	for _, event := range events {
+		s.Require().ErrorIs(s.srv.Reverse(s.ctx, s.userID, event.ID, nil), model.ErrNotReversible)
+	}
+}
+
 func (s *ServiceSuite) checkUserEvents(userID int64, eventTypes ...model.EventType) {
-	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), userID)
+	events, err := s.repo.ListEventsByFromUserID(s.repo.GetDB(s.ctx), model.DefaultTenant, userID)
 	s.Require().NoError(err)
 	s.Require().Len(events, len(eventTypes))
 	for i := range events {
 		s.Require().Equal(eventTypes[i], events[i].Type)
 	}
 }
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func (s *ServiceSuite) Test_TenantsHaveSeparateAccounts() {
+	brandCtx := model.ContextWithTenantID(s.ctx, "brand")
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.CreateAccount(brandCtx, s.userID))
+	s.Require().NoError(s.srv.Deposit(brandCtx, s.userID, 10))
+
+	balance, err := s.srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(0, balance.Balance)
+
+	brandBalance, err := s.srv.GetBalance(brandCtx, s.userID)
+	s.Require().NoError(err)
+	s.Require().Equal("brand", brandBalance.TenantID)
+	s.Require().EqualValues(10, brandBalance.Balance)
+
+	_, err = s.srv.GetBalance(model.ContextWithTenantID(s.ctx, "other"), s.userID)
+	s.Require().ErrorIs(err, model.ErrUserNotFound)
+}
+
+func (s *ServiceSuite) Test_ErrorOnDeposit_IfBalanceOverflows() {
+	s.Require().NoError(s.srv.CreateAccount(s.ctx, s.userID))
+	s.Require().NoError(s.srv.Deposit(s.ctx, s.userID, math.MaxInt64))
+
+	s.Require().ErrorIs(s.srv.Deposit(s.ctx, s.userID, 1), money.ErrOverflow)
+
+	balance, err := s.srv.GetBalance(s.ctx, s.userID)
+	s.Require().NoError(err)
+	s.Require().EqualValues(int64(math.MaxInt64), balance.Balance)
+}
@@ -0,0 +1,265 @@
+// Package billingclient sends commands to billing workers over NATS Streaming and waits for their results.
+package billingclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/stan.go"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/internal/app/queue"
+)
+
+type Config struct {
+	// URL of NATS server, nats://localhost:4222 by default.
+	URL       string
+	ClusterID string
+	// ClientID must be unique among all clients connected to the cluster.
+	ClientID string
+	// TenantID is the tenant accounts belong to, model.DefaultTenant by default.
+	TenantID string
+	// Currency is ISO 4217 code of amounts of all commands, accounts are opened in it and commands for accounts
+	// in other currencies fail with ErrCurrencyMismatch. Empty means amounts are in the currency of the account.
+	Currency string
+	// Timeout is used for commands if ctx has no deadline, 30 seconds by default.
+	Timeout time.Duration
+	// ReconnectWait is the delay between attempts to restore lost connection, 1 second by default.
+	ReconnectWait time.Duration
+	// SchemaVersion of the message envelope, 0 means SchemaVersion.
+	SchemaVersion int
+	// ContentType of sent commands, ContentTypeJSON by default. Results are read in any content type.
+	ContentType string
+	// SigningKey signs commands, it is required if workers verify signatures. Key must be in workers keyring for ClientID.
+	SigningKey *Key
+	// OnError is called with errors that can't be returned to caller, e.g. failed reconnect attempts.
+	OnError func(err error)
+}
+
+// Client is safe for concurrent use. Lost connection is restored in background, commands sent meanwhile
+// fail with ErrNotConnected, results that came while client was disconnected are lost and such commands get ErrTimeout.
+type Client struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	sc     stan.Conn
+	closed bool
+
+	waitersMu sync.Mutex
+	waiters   map[int64]chan result
+
+	lastCommandID int64
+}
+
+type result struct {
+	event *Event
+	err   error
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.URL == "" {
+		cfg.URL = stan.DefaultNatsURL
+	}
+	if cfg.ClusterID == "" {
+		cfg.ClusterID = queue.ClusterID
+	}
+	if cfg.TenantID == "" {
+		cfg.TenantID = model.DefaultTenant
+	}
+	if err := model.ValidateTenantID(cfg.TenantID); err != nil {
+		return nil, err
+	}
+	if cfg.SchemaVersion == 0 {
+		cfg.SchemaVersion = SchemaVersion
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = ContentTypeJSON
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.ReconnectWait <= 0 {
+		cfg.ReconnectWait = time.Second
+	}
+	if cfg.OnError == nil {
+		cfg.OnError = func(error) {}
+	}
+
+	c := &Client{
+		cfg:           cfg,
+		waiters:       make(map[int64]chan result),
+		lastCommandID: time.Now().UnixNano(),
+	}
+	sc, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	c.sc = sc
+	return c, nil
+}
+
+// Close closes connection, commands waiting for results get ErrNotConnected.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.sc == nil {
+		return nil
+	}
+	err := c.sc.Close()
+	c.sc = nil
+
+	c.waitersMu.Lock()
+	for id, ch := range c.waiters {
+		ch <- result{err: ErrNotConnected}
+		delete(c.waiters, id)
+	}
+	c.waitersMu.Unlock()
+	return err
+}
+
+// connect subscribes to results before any command is published, so no result is missed.
+func (c *Client) connect() (stan.Conn, error) {
+	sc, err := stan.Connect(c.cfg.ClusterID, c.cfg.ClientID,
+		stan.NatsURL(c.cfg.URL),
+		stan.SetConnectionLostHandler(c.connectionLost),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sc.Subscribe(queue.TenantSubject(queue.OperationCompletedSubject, c.cfg.TenantID), c.onCompleted); err != nil {
+		_ = sc.Close()
+		return nil, err
+	}
+	if _, err := sc.Subscribe(queue.TenantSubject(queue.OperationFailedSubject, c.cfg.TenantID), c.onFailed); err != nil {
+		_ = sc.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (c *Client) connectionLost(_ stan.Conn, err error) {
+	c.cfg.OnError(err)
+
+	c.mu.Lock()
+	c.sc = nil
+	c.mu.Unlock()
+
+	go c.reconnect()
+}
+
+func (c *Client) reconnect() {
+	for {
+		time.Sleep(c.cfg.ReconnectWait)
+
+		c.mu.RLock()
+		closed := c.closed
+		c.mu.RUnlock()
+		if closed {
+			return
+		}
+
+		sc, err := c.connect()
+		if err != nil {
+			c.cfg.OnError(err)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			_ = sc.Close()
+		} else {
+			c.sc = sc
+		}
+		c.mu.Unlock()
+		return
+	}
+}
+
+func (c *Client) onCompleted(m *stan.Msg) {
+	event := model.Event{}
+	if _, err := queue.Decode(m.Data, &event); err != nil {
+		c.cfg.OnError(err)
+		return
+	}
+	if event.CommandID != nil {
+		c.resolve(*event.CommandID, result{event: newEvent(event)})
+	}
+}
+
+func (c *Client) onFailed(m *stan.Msg) {
+	failed := model.OperationFailed{}
+	if _, err := queue.Decode(m.Data, &failed); err != nil {
+		c.cfg.OnError(err)
+		return
+	}
+	c.resolve(failed.CommandID, result{err: newError(failed)})
+}
+
+// resolve passes the first result of the command to its waiter, results of other commands are ignored.
+func (c *Client) resolve(commandID int64, res result) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	if ch, ok := c.waiters[commandID]; ok {
+		ch <- res
+		delete(c.waiters, commandID)
+	}
+}
+
+// do publishes command with new id and waits for its result. If command caused several events,
+// e.g. transfer and overdraft, the first one is returned.
+func (c *Client) do(ctx context.Context, command model.Command) (*Event, error) {
+	command.ID = atomic.AddInt64(&c.lastCommandID, 1)
+	command.TenantID = c.cfg.TenantID
+	command.Currency = c.cfg.Currency
+	data, err := queue.Encode(ctx, queue.Encoding{
+		SchemaVersion: c.cfg.SchemaVersion,
+		ContentType:   c.cfg.ContentType,
+		Producer:      c.cfg.ClientID,
+		Key:           queueKey(c.cfg.SigningKey),
+	}, queue.MessageTypeCommand, command)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan result, 1)
+	c.waitersMu.Lock()
+	c.waiters[command.ID] = ch
+	c.waitersMu.Unlock()
+	defer func() {
+		c.waitersMu.Lock()
+		delete(c.waiters, command.ID)
+		c.waitersMu.Unlock()
+	}()
+
+	c.mu.RLock()
+	sc := c.sc
+	c.mu.RUnlock()
+	if sc == nil {
+		return nil, ErrNotConnected
+	}
+	if err := sc.Publish(queue.CommandSubject(queue.CommandShard(command.FromUserID)), data); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	select {
+	case res := <-ch:
+		return res.event, res.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
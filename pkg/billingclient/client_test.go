@@ -0,0 +1,52 @@
+package billingclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+	"github.com/itimofeev/simple-billing/internal/app/queue"
+)
+
+func msg(t *testing.T, v interface{}) *stan.Msg {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return &stan.Msg{MsgProto: pb.MsgProto{Data: data}}
+}
+
+func TestClientResolvesResultsByCommandID(t *testing.T) {
+	c := &Client{cfg: Config{OnError: func(error) {}}, waiters: make(map[int64]chan result)}
+	completed, failed := make(chan result, 1), make(chan result, 1)
+	c.waiters[1], c.waiters[2] = completed, failed
+
+	commandID, otherID := int64(1), int64(3)
+	c.onCompleted(msg(t, model.Event{ID: 10, CommandID: &otherID, Type: model.EventTypeDeposit}))
+	c.onCompleted(msg(t, model.Event{ID: 11, CommandID: &commandID, Type: model.EventTypeTransfer}))
+	c.onCompleted(msg(t, model.Event{ID: 12, CommandID: &commandID, Type: model.EventTypeOverdraft}))
+	c.onFailed(msg(t, model.NewOperationFailed(model.Command{ID: 2}, fmt.Errorf("%w: 5 > 3", model.ErrNegativeBalance))))
+
+	res := <-completed
+	require.NoError(t, res.err)
+	require.Equal(t, &Event{ID: 11, CommandID: 1, Type: "transfer"}, res.event)
+
+	res = <-failed
+	require.True(t, errors.Is(res.err, ErrNegativeBalance))
+	require.False(t, errors.Is(res.err, ErrAccountClosed))
+	require.Equal(t, "billing: command 2 rejected: negative balance: 5 > 3", res.err.Error())
+
+	require.Empty(t, c.waiters)
+}
+
+func TestPublicConstantsMatchQueue(t *testing.T) {
+	require.Equal(t, queue.ContentTypeJSON, ContentTypeJSON)
+	require.Equal(t, queue.ContentTypeProtobuf, ContentTypeProtobuf)
+	require.Equal(t, queue.CurrentSchemaVersion, SchemaVersion)
+	require.Nil(t, queueKey(nil))
+	require.Equal(t, &queue.Key{ID: "k1", Secret: []byte("secret")}, queueKey(&Key{ID: "k1", Secret: []byte("secret")}))
+}
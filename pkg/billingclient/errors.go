@@ -0,0 +1,66 @@
+package billingclient
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTimeout is returned if no result of the command was received in time. The command may still be applied later.
+var ErrTimeout = errors.New("billingclient: timeout waiting for command result")
+
+// ErrNotConnected is returned while connection to NATS is being restored.
+var ErrNotConnected = errors.New("billingclient: not connected")
+
+// Error is a rejection of the command by billing, rejected commands are not retried by workers.
+// Use errors.Is with sentinel errors below to check the reason.
+type Error struct {
+	Code      string
+	Message   string
+	CommandID int64
+	// LimitUsage is set if Code is "limit_exceeded".
+	LimitUsage *LimitUsage
+}
+
+type LimitUsage struct {
+	Limit     string `json:"limit"`
+	Max       int64  `json:"max"`
+	Used      int64  `json:"used"`
+	Requested int64  `json:"requested"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("billing: command %d rejected: %s", e.CommandID, e.Message)
+}
+
+// Is matches errors with the same Code.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	return errors.As(target, &t) && t.Code == e.Code
+}
+
+// Sentinel errors matching codes of model.ErrorCode.
+var (
+	ErrUserNotFound          = &Error{Code: "user_not_found"}
+	ErrAlreadyExists         = &Error{Code: "already_exists"}
+	ErrNegativeAmount        = &Error{Code: "negative_amount"}
+	ErrNegativeBalance       = &Error{Code: "negative_balance"}
+	ErrAccountFrozen         = &Error{Code: "account_frozen"}
+	ErrAccountClosed         = &Error{Code: "account_closed"}
+	ErrWrongAccountStatus    = &Error{Code: "wrong_account_status"}
+	ErrNonZeroBalance        = &Error{Code: "non_zero_balance"}
+	ErrLimitExceeded         = &Error{Code: "limit_exceeded"}
+	ErrUnknownCommand        = &Error{Code: "unknown_command"}
+	ErrInvalidCommand        = &Error{Code: "invalid_command"}
+	ErrSameAccount           = &Error{Code: "same_account"}
+	ErrEventNotFound         = &Error{Code: "event_not_found"}
+	ErrNotReversible         = &Error{Code: "not_reversible"}
+	ErrReversalExceedsAmount = &Error{Code: "reversal_exceeds_amount"}
+	ErrInvalidSignature      = &Error{Code: "invalid_signature"}
+	ErrStaleMessage          = &Error{Code: "stale_message"}
+	ErrReplayedMessage       = &Error{Code: "replayed_message"}
+	ErrForbidden             = &Error{Code: "forbidden"}
+	ErrThrottled             = &Error{Code: "throttled"}
+	ErrScreeningRejected     = &Error{Code: "screening_rejected"}
+	ErrAmountOverflow        = &Error{Code: "amount_overflow"}
+	ErrCurrencyMismatch      = &Error{Code: "currency_mismatch"}
+)
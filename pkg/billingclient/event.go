@@ -0,0 +1,47 @@
+package billingclient
+
+import (
+	"time"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+// Event is the result of the applied command.
+type Event struct {
+	ID         int64
+	CommandID  int64
+	Type       string
+	FromUserID int64
+	ToUserID   *int64
+	Amount     *int64
+	Fee        *int64
+	// Currency of Amount and Fee, empty for events made before currencies, they are in roubles.
+	Currency string
+
+	CreatedTime time.Time
+}
+
+func newEvent(event model.Event) *Event {
+	e := &Event{
+		ID:          event.ID,
+		Type:        string(event.Type),
+		FromUserID:  event.FromUserID,
+		ToUserID:    event.ToUserID,
+		Amount:      event.Amount,
+		Fee:         event.Fee,
+		Currency:    event.Currency,
+		CreatedTime: event.CreatedTime,
+	}
+	if event.CommandID != nil {
+		e.CommandID = *event.CommandID
+	}
+	return e
+}
+
+func newError(failed model.OperationFailed) *Error {
+	err := &Error{Code: failed.Error, Message: failed.Message, CommandID: failed.CommandID}
+	if u := failed.LimitUsage; u != nil {
+		err.LimitUsage = &LimitUsage{Limit: string(u.Limit), Max: u.Max, Used: u.Used, Requested: u.Requested}
+	}
+	return err
+}
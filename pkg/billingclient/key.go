@@ -0,0 +1,36 @@
+package billingclient
+
+import (
+	"github.com/itimofeev/simple-billing/internal/app/queue"
+)
+
+// Content types commands may be sent in, see Config.ContentType.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// SchemaVersion is the latest message envelope version the client writes, see Config.SchemaVersion.
+const SchemaVersion = 1
+
+// Key is a shared HMAC key commands are signed with, Secret is base64 in JSON.
+// The same key must be in workers keyring for Config.ClientID.
+type Key struct {
+	ID     string `json:"id"`
+	Secret []byte `json:"secret"`
+}
+
+// LoadKey reads Key from JSON file: {"id": "...", "secret": "..."}.
+func LoadKey(path string) (Key, error) {
+	key, err := queue.LoadKey(path)
+	return Key(key), err
+}
+
+// queueKey converts key to the one queue signs envelopes with, nil stays nil.
+func queueKey(key *Key) *queue.Key {
+	if key == nil {
+		return nil
+	}
+	k := queue.Key(*key)
+	return &k
+}
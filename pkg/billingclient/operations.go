@@ -0,0 +1,47 @@
+package billingclient
+
+import (
+	"context"
+
+	"github.com/itimofeev/simple-billing/internal/app/model"
+)
+
+func (c *Client) Open(ctx context.Context, userID int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeOpen, FromUserID: userID})
+}
+
+func (c *Client) Deposit(ctx context.Context, userID, amount int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeDeposit, FromUserID: userID, Amount: &amount})
+}
+
+func (c *Client) Withdraw(ctx context.Context, userID, amount int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeWithdraw, FromUserID: userID, Amount: &amount})
+}
+
+func (c *Client) Transfer(ctx context.Context, fromUserID, toUserID, amount int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeTransfer, FromUserID: fromUserID, ToUserID: &toUserID, Amount: &amount})
+}
+
+func (c *Client) Freeze(ctx context.Context, userID int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeFreeze, FromUserID: userID})
+}
+
+func (c *Client) Unfreeze(ctx context.Context, userID int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeUnfreeze, FromUserID: userID})
+}
+
+// CloseAccount closes account of the user. If sweepToUserID is not nil, the rest of the balance is transferred to it,
+// the transfer event is returned then.
+func (c *Client) CloseAccount(ctx context.Context, userID int64, sweepToUserID *int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeClose, FromUserID: userID, ToUserID: sweepToUserID})
+}
+
+func (c *Client) SetCreditLimit(ctx context.Context, userID, creditLimit int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeSetCreditLimit, FromUserID: userID, Amount: &creditLimit})
+}
+
+// Reverse reverses amount of deposit, withdrawal or transfer eventID of the user, nil amount reverses the rest of it.
+// Transfers are reversed only if the client is one of the reviewers of the worker, otherwise ErrForbidden is returned.
+func (c *Client) Reverse(ctx context.Context, userID, eventID int64, amount *int64) (*Event, error) {
+	return c.do(ctx, model.Command{Type: model.CommandTypeReverse, FromUserID: userID, EventID: &eventID, Amount: amount})
+}
@@ -0,0 +1,145 @@
+// Package money keeps amounts as int64 minor units of their currency (kopecks for RUB)
+// and does arithmetic that fails on int64 overflow instead of wrapping around.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrOverflow is returned if result doesn't fit into int64 minor units.
+var ErrOverflow = errors.New("money: amount overflow")
+
+// ErrCurrencyMismatch is returned when amounts of different currencies are mixed.
+var ErrCurrencyMismatch = errors.New("money: currency mismatch")
+
+// ErrInvalidAmount is returned if amount can't be parsed.
+var ErrInvalidAmount = errors.New("money: invalid amount")
+
+// Currency is ISO 4217 code with the number of minor unit digits.
+type Currency struct {
+	Code string
+	// Exponent is the number of digits after the decimal point, 2 for RUB: 1 ruble is 100 kopecks.
+	Exponent int
+}
+
+var (
+	RUB = Currency{Code: "RUB", Exponent: 2}
+	USD = Currency{Code: "USD", Exponent: 2}
+	EUR = Currency{Code: "EUR", Exponent: 2}
+	JPY = Currency{Code: "JPY", Exponent: 0}
+)
+
+var currencies = map[string]Currency{RUB.Code: RUB, USD.Code: USD, EUR.Code: EUR, JPY.Code: JPY}
+
+// ParseCurrency returns known currency by its code.
+func ParseCurrency(code string) (Currency, error) {
+	c, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("money: unknown currency %q", code)
+	}
+	return c, nil
+}
+
+func (c Currency) String() string {
+	return c.Code
+}
+
+// Add returns a+b of minor units or ErrOverflow.
+func Add(a, b int64) (int64, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, fmt.Errorf("%w: %d + %d", ErrOverflow, a, b)
+	}
+	return sum, nil
+}
+
+// Sub returns a-b of minor units or ErrOverflow.
+func Sub(a, b int64) (int64, error) {
+	diff := a - b
+	if (b > 0 && diff > a) || (b < 0 && diff < a) {
+		return 0, fmt.Errorf("%w: %d - %d", ErrOverflow, a, b)
+	}
+	return diff, nil
+}
+
+// Money is amount in minor units of its currency, it is used to parse and format decimal amounts.
+// Zero value is zero amount of no currency.
+type Money struct {
+	amount   int64
+	currency Currency
+}
+
+// New returns amount of minor units, New(150, RUB) is 1.50 RUB.
+func New(amount int64, currency Currency) Money {
+	return Money{amount: amount, currency: currency}
+}
+
+// Amount returns minor units.
+func (m Money) Amount() int64 {
+	return m.amount
+}
+
+// Parse parses decimal amount in major units, Parse("1.5", RUB) is 150 kopecks.
+// Amounts with more fractional digits than the currency has are rejected rather than rounded.
+func Parse(s string, currency Currency) (Money, error) {
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+		if fraction == "" {
+			return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+	}
+	if len(fraction) > currency.Exponent {
+		return Money{}, fmt.Errorf("%w: %q has more than %d fractional digits of %s", ErrInvalidAmount, s, currency.Exponent, currency)
+	}
+	digits := strings.TrimLeft(integer, "+-")
+	if len(integer)-len(digits) > 1 || !isDigits(digits) || !isDigits(fraction) || digits == "" {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	amount, err := strconv.ParseInt(integer+fraction+strings.Repeat("0", currency.Exponent-len(fraction)), 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return Money{}, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	return New(amount, currency), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Format returns decimal amount in major units without currency, e.g. "-1.50".
+func (m Money) Format() string {
+	sign := ""
+	digits := strconv.FormatInt(m.amount, 10)
+	if m.amount < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	exp := m.currency.Exponent
+	if exp <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// String returns amount with currency code, e.g. "1.50 RUB".
+func (m Money) String() string {
+	if m.currency.Code == "" {
+		return m.Format()
+	}
+	return m.Format() + " " + m.currency.Code
+}
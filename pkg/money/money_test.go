@@ -0,0 +1,70 @@
+package money
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddSub(t *testing.T) {
+	sum, err := Add(math.MaxInt64-1, 1)
+	require.NoError(t, err)
+	require.EqualValues(t, math.MaxInt64, sum)
+
+	_, err = Add(math.MaxInt64, 1)
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Add(math.MinInt64, -1)
+	require.ErrorIs(t, err, ErrOverflow)
+
+	diff, err := Sub(-5, 10)
+	require.NoError(t, err)
+	require.EqualValues(t, -15, diff)
+
+	_, err = Sub(math.MinInt64, 1)
+	require.ErrorIs(t, err, ErrOverflow)
+	_, err = Sub(0, math.MinInt64)
+	require.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestParseAndFormat(t *testing.T) {
+	cases := []struct {
+		in       string
+		currency Currency
+		amount   int64
+		out      string
+	}{
+		{"1.5", RUB, 150, "1.50"},
+		{"0.05", RUB, 5, "0.05"},
+		{"-0.05", RUB, -5, "-0.05"},
+		{"+12", RUB, 1200, "12.00"},
+		{"1000", JPY, 1000, "1000"},
+		{"92233720368547758.07", RUB, math.MaxInt64, "92233720368547758.07"},
+		{"-92233720368547758.08", RUB, math.MinInt64, "-92233720368547758.08"},
+	}
+	for _, c := range cases {
+		m, err := Parse(c.in, c.currency)
+		require.NoError(t, err, c.in)
+		require.EqualValues(t, c.amount, m.Amount(), c.in)
+		require.Equal(t, c.out, m.Format(), c.in)
+	}
+	require.Equal(t, "1.50 RUB", New(150, RUB).String())
+
+	for _, in := range []string{"", ".", "1.", ".5", "1.234", "1,5", "--1", "1e3", "1.-5", " 1"} {
+		_, err := Parse(in, RUB)
+		require.ErrorIs(t, err, ErrInvalidAmount, in)
+	}
+	_, err := Parse("1.5", JPY)
+	require.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = Parse("92233720368547758.08", RUB)
+	require.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestParseCurrency(t *testing.T) {
+	c, err := ParseCurrency("rub")
+	require.NoError(t, err)
+	require.Equal(t, RUB, c)
+
+	_, err = ParseCurrency("XXX")
+	require.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stage is one step of an ordered shutdown. Do gets a context that is cancelled after Timeout
+// (no deadline if Timeout is zero).
+type Stage struct {
+	Name    string
+	Timeout time.Duration
+	Do      func(ctx context.Context) error
+}
+
+// Stages are executed one after another in the given order.
+type Stages []Stage
+
+// Run executes every stage even if previous ones failed, so that e.g. connections are closed
+// after a drain timed out. Returned error lists all failed stages.
+func (s Stages) Run(ctx context.Context) error {
+	var failed []string
+	for _, stage := range s {
+		if err := stage.run(ctx); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", stage.Name, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("shutdown stages failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (s Stage) run(ctx context.Context) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return s.Do(ctx)
+}
+
+// WaitGroup waits for wg or returns ctx error if it is done earlier.
+func WaitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,43 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStagesRunAllInOrder(t *testing.T) {
+	var called []string
+	stage := func(name string, err error) Stage {
+		return Stage{Name: name, Do: func(ctx context.Context) error {
+			called = append(called, name)
+			return err
+		}}
+	}
+
+	err := Stages{
+		stage("first", nil),
+		stage("second", errors.New("boom")),
+		stage("third", nil),
+	}.Run(context.Background())
+
+	require.EqualError(t, err, "shutdown stages failed: second: boom")
+	require.Equal(t, []string{"first", "second", "third"}, called)
+}
+
+func TestStageTimeout(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	err := Stages{
+		{Name: "wait", Timeout: 10 * time.Millisecond, Do: func(ctx context.Context) error {
+			return WaitGroup(ctx, wg)
+		}},
+	}.Run(context.Background())
+
+	require.EqualError(t, err, "shutdown stages failed: wait: context deadline exceeded")
+}